@@ -0,0 +1,168 @@
+package main
+
+import (
+	"auth/internal/config"
+	"auth/internal/data"
+	"auth/internal/geoip"
+	"context"
+	"log/slog"
+	"math"
+	"strings"
+	"time"
+)
+
+// earthRadius is the mean radius of the Earth in kilometers.
+const earthRadius = 6371.0
+
+// checkLogin adds the login that created session to the user's history
+// and, when it looks suspicious, notifies the user. It is best effort: a
+// failure is logged and does not fail the login.
+func (app *application) checkLogin(ctx context.Context, cfg config.LoginAlerts, session *data.Session, loc geoip.Location) {
+	login := &data.Login{
+		UserID:    session.UserID,
+		SessionID: session.SessionID,
+		Device:    deviceFingerprint(session),
+		IPAddress: session.IPAddress,
+		Country:   loc.Country,
+		City:      loc.City,
+		// Coordinates are only kept along with their accuracy.
+		Latitude:       loc.Latitude,
+		Longitude:      loc.Longitude,
+		AccuracyRadius: loc.AccuracyRadius,
+	}
+
+	// The history is kept while alerts are off, so that turning them on
+	// does not flag every device as new.
+	if cfg.Enabled {
+		prev, err := app.models.LoginModel.Previous(ctx, login.UserID, login.Device, login.Country)
+		if err != nil {
+			app.logger.Error("failed to read login history", slog.Any("err", err.Error()))
+			return
+		}
+		login.Flags = loginFlags(cfg, prev, login, time.Now())
+	}
+
+	if err := app.models.LoginModel.Insert(ctx, login, cfg.History); err != nil {
+		app.logger.Error("failed to record login", slog.Any("err", err.Error()))
+	}
+	if len(login.Flags) == 0 {
+		return
+	}
+
+	for _, flag := range login.Flags {
+		app.metrics.loginAlerts.WithLabelValues(flag).Inc()
+	}
+	app.logger.Warn("suspicious login",
+		slog.String("user_id", login.UserID),
+		slog.String("session_id", login.SessionID),
+		slog.Any("flags", login.Flags))
+
+	// Flags are in order of severity; the message is about the worst.
+	message := localizerFromContext(ctx).T("login_alert."+login.Flags[len(login.Flags)-1], describeLogin(session, loc))
+	app.publishEvent(ctx, data.SubjectNotificationCreated, data.NestEvent{
+		Pattern: data.SubjectNotificationCreated,
+		Data: data.LoginAlertNotification{
+			UserID:     session.UserID,
+			Mensaje:    message,
+			Tipo:       "login_alert",
+			Flags:      login.Flags,
+			SessionID:  session.SessionID,
+			IPAddress:  session.IPAddress,
+			Browser:    session.Browser,
+			OS:         session.OS,
+			Device:     session.Device,
+			Country:    session.Country,
+			City:       session.City,
+			OccurredAt: time.Now().UTC(),
+		},
+	})
+}
+
+// loginFlags returns why login, made at now, looks suspicious given the
+// user's previous logins, in order of severity. The first login of a user
+// is never flagged, as there is nothing to compare it with.
+func loginFlags(cfg config.LoginAlerts, prev data.PreviousLogins, login *data.Login, now time.Time) []string {
+	if !prev.Any {
+		return nil
+	}
+
+	var flags []string
+	if login.Device != "" && !prev.Device {
+		flags = append(flags, data.LoginFlagNewDevice)
+	}
+	if login.Country != "" && !prev.Country {
+		flags = append(flags, data.LoginFlagNewCountry)
+	}
+	if last := prev.Last; last != nil && login.AccuracyRadius > 0 {
+		// Both locations may be off by their accuracy radius, so only the
+		// distance that cannot be explained by it counts.
+		distance := haversine(last.Latitude, last.Longitude, login.Latitude, login.Longitude) -
+			float64(last.AccuracyRadius+login.AccuracyRadius)
+		if distance > 0 && distance > cfg.MaxTravelSpeed*now.Sub(last.CreatedAt).Hours() {
+			flags = append(flags, data.LoginFlagImpossibleTravel)
+		}
+	}
+	return flags
+}
+
+// haversine returns the great-circle distance in kilometers between two
+// points given in degrees.
+func haversine(lat1, lon1, lat2, lon2 float64) float64 {
+	rad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	dLat, dLon := rad(lat2-lat1), rad(lon2-lon1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(rad(lat1))*math.Cos(rad(lat2))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// deviceFingerprint identifies the client of session by its device name,
+// model, operating system and browser, without their versions, so that
+// updates do not make a known device look new. It is empty when the
+// client told nothing about itself.
+func deviceFingerprint(session *data.Session) string {
+	parts := []string{session.DeviceName, session.Device, withoutVersion(session.OS), withoutVersion(session.Browser)}
+	if strings.Join(parts, "") == "" {
+		return ""
+	}
+	return strings.ToLower(strings.Join(parts, "|"))
+}
+
+// withoutVersion drops the trailing version of a name such as "Chrome 120"
+// or "Windows 8.1".
+func withoutVersion(name string) string {
+	before, version, ok := cutLast(name, " ")
+	if ok && version != "" && version[0] >= '0' && version[0] <= '9' {
+		return before
+	}
+	return name
+}
+
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
+
+// describeLogin names the client and place of a login for the user, such
+// as "Chrome 120, Windows, Madrid, Spain, 203.0.113.7".
+func describeLogin(session *data.Session, loc geoip.Location) string {
+	country := loc.CountryName
+	if country == "" {
+		country = loc.Country
+	}
+
+	var parts []string
+	for _, p := range []string{session.Browser, session.OS, session.Device, loc.City, country} {
+		if p != "" {
+			parts = append(parts, p)
+		}
+	}
+	if session.IPAddress != nil {
+		parts = append(parts, *session.IPAddress)
+	}
+	if len(parts) == 0 {
+		return session.DeviceName
+	}
+	return strings.Join(parts, ", ")
+}
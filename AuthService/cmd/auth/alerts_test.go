@@ -0,0 +1,140 @@
+package main
+
+import (
+	"auth/internal/config"
+	"auth/internal/data"
+	"auth/internal/testutils"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+func TestLoginFlags(t *testing.T) {
+	cfg := config.LoginAlerts{Enabled: true, History: 100, MaxTravelSpeed: 1000}
+	now := time.Now()
+
+	// Buenos Aires and Madrid are about 10,000 km apart.
+	buenosAires := &data.Login{Device: "laptop", Country: "AR", Latitude: -34.6131, Longitude: -58.3772, AccuracyRadius: 50, CreatedAt: now.Add(-2 * time.Hour)}
+	madrid := &data.Login{Device: "laptop", Country: "ES", Latitude: 40.4165, Longitude: -3.7026, AccuracyRadius: 20}
+	nearby := &data.Login{Device: "laptop", Country: "AR", Latitude: -34.9215, Longitude: -57.9545, AccuracyRadius: 20}
+
+	tests := []struct {
+		name  string
+		prev  data.PreviousLogins
+		login *data.Login
+		want  []string
+	}{
+		{"first login", data.PreviousLogins{}, madrid, nil},
+		{"known device and country", data.PreviousLogins{Any: true, Device: true, Country: true, Last: buenosAires}, nearby, nil},
+		{"new device", data.PreviousLogins{Any: true, Country: true}, nearby, []string{data.LoginFlagNewDevice}},
+		{"unknown device is not new", data.PreviousLogins{Any: true, Country: true}, &data.Login{Country: "AR"}, nil},
+		{"new country", data.PreviousLogins{Any: true, Device: true}, nearby, []string{data.LoginFlagNewCountry}},
+		{"unlocated login is not from a new country", data.PreviousLogins{Any: true, Device: true, Last: buenosAires}, &data.Login{Device: "laptop"}, nil},
+		{
+			"impossible travel",
+			data.PreviousLogins{Any: true, Device: true, Last: buenosAires},
+			madrid,
+			[]string{data.LoginFlagNewCountry, data.LoginFlagImpossibleTravel},
+		},
+		{
+			"possible travel",
+			data.PreviousLogins{Any: true, Device: true, Country: true, Last: &data.Login{Latitude: -34.6131, Longitude: -58.3772, AccuracyRadius: 50, CreatedAt: now.Add(-24 * time.Hour)}},
+			madrid,
+			nil,
+		},
+		{
+			"within the accuracy radius",
+			data.PreviousLogins{Any: true, Device: true, Country: true, Last: &data.Login{Latitude: -34.6131, Longitude: -58.3772, AccuracyRadius: 100, CreatedAt: now}},
+			nearby,
+			nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := loginFlags(cfg, tt.prev, tt.login, now); !slices.Equal(got, tt.want) {
+				t.Errorf("got %v want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeviceFingerprint(t *testing.T) {
+	before := &data.Session{DeviceName: "Work", Browser: "Chrome 119", OS: "Android 13", Device: "Pixel 8"}
+	after := &data.Session{DeviceName: "Work", Browser: "Chrome 120", OS: "Android 14", Device: "Pixel 8"}
+	if deviceFingerprint(before) != deviceFingerprint(after) {
+		t.Errorf("got %q and %q want updates to keep the fingerprint", deviceFingerprint(before), deviceFingerprint(after))
+	}
+	if other := (&data.Session{DeviceName: "Work", Browser: "Firefox 121", OS: "Android 14", Device: "Pixel 8"}); deviceFingerprint(other) == deviceFingerprint(after) {
+		t.Errorf("got the same fingerprint for another browser")
+	}
+	if got := deviceFingerprint(&data.Session{}); got != "" {
+		t.Errorf("got %q for an unknown client want empty", got)
+	}
+}
+
+func TestLoginAlert(t *testing.T) {
+	testutils.ResetTestDB(t, dsn)
+	user := createTestUser(t)
+
+	events, err := app.nc.SubscribeSync(data.SubjectNotificationCreated)
+	if err != nil {
+		t.Fatalf("failed to subscribe to %s: %v", data.SubjectNotificationCreated, err)
+	}
+	defer events.Unsubscribe()
+
+	login := func(userAgent string) {
+		t.Helper()
+		msg := nats.NewMsg("auth.v1.login")
+		msg.Header.Set(localeHeader, "es")
+		msg.Data = []byte(fmt.Sprintf(`{"email":"test@mail.com","password":"12345678","ip_address":"203.0.113.7","user_agent":%q}`, userAgent))
+		reply, err := app.nc.RequestMsg(msg, 2*time.Second)
+		if err != nil {
+			t.Fatalf("failed to get login response: %v", err)
+		}
+		var r data.Response
+		if err := json.Unmarshal(reply.Data, &r); err != nil {
+			t.Fatalf("failed to unmarshal login response: %v", err)
+		}
+		if r.StatusCode != http.StatusOK {
+			t.Fatalf("got %d want %d, %s %s", r.StatusCode, http.StatusOK, r.Code, r.Message)
+		}
+	}
+
+	const windows = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"
+	const iPhone = "Mozilla/5.0 (iPhone; CPU iPhone OS 17_1_2 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1.2 Mobile/15E148 Safari/604.1"
+
+	login(windows)
+	login(strings.Replace(windows, "Chrome/120", "Chrome/121", 1))
+	if msg, err := events.NextMsg(200 * time.Millisecond); err == nil {
+		t.Fatalf("got %s for a known device", msg.Data)
+	}
+
+	login(iPhone)
+	msg, err := events.NextMsg(2 * time.Second)
+	if err != nil {
+		t.Fatalf("expected a %s event: %v", data.SubjectNotificationCreated, err)
+	}
+	var event struct {
+		Pattern string `json:"pattern"`
+		Data    data.LoginAlertNotification
+	}
+	if err := json.Unmarshal(msg.Data, &event); err != nil {
+		t.Fatalf("failed to unmarshal event: %v", err)
+	}
+	if event.Pattern != data.SubjectNotificationCreated || event.Data.UserID != user.ID {
+		t.Errorf("got event %+v", event)
+	}
+	if !slices.Equal(event.Data.Flags, []string{data.LoginFlagNewDevice}) {
+		t.Errorf("got flags %v want %v", event.Data.Flags, []string{data.LoginFlagNewDevice})
+	}
+	if !strings.HasPrefix(event.Data.Mensaje, "nuevo inicio de sesión") || !strings.Contains(event.Data.Mensaje, "iPhone") {
+		t.Errorf("got message %q want it in Spanish and naming the device", event.Data.Mensaje)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"auth/internal/config"
+	"auth/internal/data"
+	v1 "auth/internal/data/v1"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+const (
+	// breakGlassBatchSize is how many sessions each statement of a break
+	// glass revokes.
+	breakGlassBatchSize = 1000
+	// breakGlassReloadInterval is how often the break glass in force is
+	// reloaded, in case its event was missed.
+	breakGlassReloadInterval = 30 * time.Second
+)
+
+// authorizeAdmin checks that req carries the admin token in its
+// Authorization header and returns the actor it identifies, for audit
+// trails, or sends an error response and returns false. The actor names
+// the token by its key ID, so that it tells tokens apart across rotations
+// without revealing them.
+func (app *application) authorizeAdmin(ctx context.Context, req micro.Request, cfg *config.Config) (string, bool) {
+	token, ok := strings.CutPrefix(req.Headers().Get("Authorization"), "Bearer ")
+	if !ok || cfg.Admin.Token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(cfg.Admin.Token)) != 1 {
+		app.sendErrorResponse(ctx, req, http.StatusForbidden, data.CodeForbidden)
+		return "", false
+	}
+	return "admin_token:" + keyID(cfg.Admin.Token), true
+}
+
+// breakGlassHandler revokes every session and access token at once, for
+// when the signing secret has leaked. The revocation is in force as soon
+// as it is recorded: tokens issued before it, or signed with any key known
+// at the time, are rejected and sessions created before it can no longer
+// be refreshed. New tokens are signed with a key derived from
+// JWT_BREAK_GLASS_SECRET until a new JWT_ACCESS_SECRET is configured. The
+// sessions are then marked revoked in batches; that work is finished even
+// if the caller stops waiting.
+func (app *application) breakGlassHandler(ctx context.Context, req micro.Request) {
+	cfg := app.config.Load()
+
+	actor, ok := app.authorizeAdmin(ctx, req, cfg)
+	if !ok {
+		return
+	}
+
+	var input v1.BreakGlassInput
+	if !app.readJSON(ctx, req, &input) {
+		return
+	}
+
+	work := context.WithoutCancel(ctx)
+
+	bg := &data.BreakGlass{
+		Actor:          actor,
+		Reason:         input.Reason,
+		SigningKeySalt: rand.Text(),
+		RevokedKeyIDs:  app.knownKeyIDs(cfg),
+	}
+	if err := app.models.BreakGlassModel.Insert(work, bg); err != nil {
+		app.sendInternalServerErrorResponse(ctx, req)
+		return
+	}
+	app.breakGlass.Store(bg)
+
+	app.logger.Warn("break glass: all sessions and tokens revoked",
+		slog.Int64("id", bg.ID),
+		slog.String("actor", bg.Actor),
+		slog.String("reason", bg.Reason),
+		slog.Time("not_before", bg.NotBefore),
+	)
+	app.publishEvent(work, data.SubjectBreakGlass, data.BreakGlassEvent{
+		ID:        bg.ID,
+		NotBefore: bg.NotBefore,
+		Actor:     bg.Actor,
+		Reason:    bg.Reason,
+		KeyID:     keyID(breakGlassKey(cfg, bg)),
+	})
+
+	revoked, err := app.revokeSessionsBefore(work, bg.NotBefore)
+	if err != nil {
+		app.logger.Error("break glass: failed to revoke sessions", slog.Int64("id", bg.ID), slog.Int64("revoked", revoked), slog.Any("err", err.Error()))
+		app.sendInternalServerErrorResponse(ctx, req)
+		return
+	}
+	if err := app.models.BreakGlassModel.Complete(work, bg.ID, revoked); err != nil {
+		app.logger.Error("break glass: failed to record completion", slog.Int64("id", bg.ID), slog.Any("err", err.Error()))
+	}
+	app.logger.Warn("break glass: sessions revoked", slog.Int64("id", bg.ID), slog.Int64("revoked", revoked))
+
+	app.sendSuccessResponse(ctx, req, http.StatusOK, v1.BreakGlassResponse{
+		ID:              bg.ID,
+		NotBefore:       bg.NotBefore,
+		KeyID:           keyID(breakGlassKey(cfg, bg)),
+		SessionsRevoked: revoked,
+	})
+}
+
+// revokedByBreakGlass reports whether s was created before the break glass
+// in force, which revokes it even before its row has been marked.
+func (app *application) revokedByBreakGlass(s *data.Session) bool {
+	bg := app.breakGlass.Load()
+	return bg != nil && s.CreatedAt.Before(bg.NotBefore)
+}
+
+// knownKeyIDs returns the key IDs of every secret tokens are accepted with
+// under cfg, together with the ones already revoked, so that a break glass
+// keeps revoked secrets revoked.
+func (app *application) knownKeyIDs(cfg *config.Config) []string {
+	var ids []string
+	if bg := app.breakGlass.Load(); bg != nil {
+		ids = append(ids, bg.RevokedKeyIDs...)
+	}
+	for _, secret := range app.verificationSecrets(cfg) {
+		ids = append(ids, keyID(secret))
+	}
+	slices.Sort(ids)
+	return slices.Compact(ids)
+}
+
+// revokeSessionsBefore revokes, in batches, every live session created
+// before before and returns how many it revoked.
+func (app *application) revokeSessionsBefore(ctx context.Context, before time.Time) (int64, error) {
+	var total int64
+	for {
+		n, err := app.models.SessionModel.RevokeBatch(ctx, before, breakGlassBatchSize)
+		total += n
+		if err != nil || n == 0 {
+			return total, err
+		}
+	}
+}
+
+// loadBreakGlass loads the break glass in force. On failure the one loaded
+// before is kept.
+func (app *application) loadBreakGlass(ctx context.Context) {
+	bg, err := app.models.BreakGlassModel.Latest(ctx)
+	if err != nil {
+		if !errors.Is(err, data.ErrNoRecord) {
+			app.logger.Error("failed to load break glass", slog.Any("err", err.Error()))
+		}
+		return
+	}
+	app.breakGlass.Store(bg)
+}
+
+// watchBreakGlass loads the break glass in force at startup, whenever a
+// break glass event is published by any instance, and periodically in case
+// an event was missed.
+func (app *application) watchBreakGlass(ctx context.Context) {
+	reload := make(chan struct{}, 1)
+	sub, err := app.nc.Subscribe(data.SubjectBreakGlass, func(*nats.Msg) {
+		select {
+		case reload <- struct{}{}:
+		default:
+		}
+	})
+	if err != nil {
+		app.logger.Error("failed to subscribe to break glass events", slog.Any("err", err.Error()))
+	} else {
+		defer func() {
+			_ = sub.Unsubscribe()
+		}()
+	}
+
+	app.loadBreakGlass(ctx)
+
+	ticker := time.NewTicker(breakGlassReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-reload:
+		}
+		app.loadBreakGlass(ctx)
+	}
+}
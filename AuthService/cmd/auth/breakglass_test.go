@@ -0,0 +1,181 @@
+package main
+
+import (
+	"auth/internal/data"
+	v1 "auth/internal/data/v1"
+	"auth/internal/testutils"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/nats-io/nats.go"
+)
+
+const adminToken = "admin-token-ensure-32-bytes-long-string!"
+
+func TestBreakGlass(t *testing.T) {
+	testutils.ResetTestDB(t, dsn)
+
+	previous := app.config.Load()
+	t.Cleanup(func() {
+		app.config.Store(previous)
+		app.breakGlass.Store(nil)
+	})
+
+	cfg := *previous
+	cfg.Admin.Token = adminToken
+	cfg.JWT.BreakGlassSecret = "break-glass-secret-ensure-32-bytes-long!"
+	app.config.Store(&cfg)
+
+	user := createTestUser(t)
+	refreshToken := generateOpaqueTokenForTest(t)
+	hash := sha256.Sum256([]byte(refreshToken))
+	session := createTestSession(t, user.ID, hash[:], time.Now().Add(24*time.Hour))
+	token, err := app.generateAccessToken(&cfg, user, session.SessionID)
+	if err != nil {
+		t.Fatalf("failed to generate access token: %v", err)
+	}
+
+	breakGlass := func(authorization string) (data.Response, v1.BreakGlassResponse) {
+		t.Helper()
+
+		msg := nats.NewMsg("auth.v1.break_glass")
+		// The actor sent along is ignored in favour of the admin token.
+		msg.Data = []byte(`{"actor":"oncall@example.com","reason":"signing secret leaked"}`)
+		if authorization != "" {
+			msg.Header.Set("Authorization", authorization)
+		}
+		reply, err := app.nc.RequestMsg(msg, 5*time.Second)
+		if err != nil {
+			t.Fatalf("failed to get response from auth.v1.break_glass: %v", err)
+		}
+
+		var r struct {
+			data.Response
+			Data v1.BreakGlassResponse `json:"data"`
+		}
+		if err := json.Unmarshal(reply.Data, &r); err != nil {
+			t.Fatalf("failed to unmarshal break glass response: %v", err)
+		}
+		return r.Response, r.Data
+	}
+
+	for _, authorization := range []string{"", "Bearer wrong-token", adminToken} {
+		if r, _ := breakGlass(authorization); r.StatusCode != http.StatusForbidden || r.Code != data.CodeForbidden {
+			t.Errorf("got %d %s with authorization %q want %d %s", r.StatusCode, r.Code, authorization, http.StatusForbidden, data.CodeForbidden)
+		}
+	}
+
+	r, result := breakGlass("Bearer " + adminToken)
+	if r.StatusCode != http.StatusOK {
+		t.Fatalf("got %d %s want %d", r.StatusCode, r.Code, http.StatusOK)
+	}
+	if result.SessionsRevoked != 1 {
+		t.Errorf("got %d sessions revoked want 1", result.SessionsRevoked)
+	}
+
+	runTests(t, "auth.v1.validate", []Test{{
+		name:    "fail - token issued before the break glass",
+		payload: []byte(fmt.Sprintf(`{"access_token": "%s"}`, token)),
+		want:    http.StatusUnauthorized,
+		code:    data.CodeTokenRevoked,
+	}})
+	runTests(t, "auth.v1.refresh", []Test{{
+		name:    "fail - session created before the break glass",
+		payload: []byte(fmt.Sprintf(`{"refresh_token": "%s"}`, refreshToken)),
+		want:    http.StatusUnauthorized,
+		code:    data.CodeSessionRevoked,
+	}})
+
+	// Whoever holds the leaked secret cannot mint tokens that postdate the
+	// break glass.
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS512, &AccessToken{
+		UserID:       user.ID,
+		SessionID:    session.SessionID,
+		TokenVersion: user.TokenVersion,
+		RegisteredClaims: jwt.RegisteredClaims{
+			IssuedAt:  jwt.NewNumericDate(time.Now().Add(time.Minute)),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	})
+	forged.Header["kid"] = keyID(cfg.JWT.AccessSecret)
+	forgedToken, err := forged.SignedString([]byte(cfg.JWT.AccessSecret))
+	if err != nil {
+		t.Fatalf("failed to sign forged token: %v", err)
+	}
+	if _, err := app.validateAccessToken(&cfg, forgedToken); !errors.Is(err, errTokenRevoked) {
+		t.Errorf("got error %v for a token signed with the revoked secret want %v", err, errTokenRevoked)
+	}
+
+	// New logins get tokens signed with the generated key.
+	msg, err := app.nc.Request("auth.v1.login", []byte(`{"email":"test@mail.com","password":"12345678"}`), 2*time.Second)
+	if err != nil {
+		t.Fatalf("failed to get login response: %v", err)
+	}
+	var login struct {
+		data.Response
+		Data v1.LoginResponse `json:"data"`
+	}
+	if err := json.Unmarshal(msg.Data, &login); err != nil {
+		t.Fatalf("failed to unmarshal login response: %v", err)
+	}
+	runTests(t, "auth.v1.validate", []Test{{
+		name:    "success - token issued after the break glass",
+		payload: []byte(fmt.Sprintf(`{"access_token": "%s"}`, login.Data.AccessToken)),
+		want:    http.StatusOK,
+	}})
+	parsed, _, err := jwt.NewParser().ParseUnverified(login.Data.AccessToken, &AccessToken{})
+	if err != nil {
+		t.Fatalf("failed to parse new token: %v", err)
+	}
+	if kid := parsed.Header["kid"]; kid != result.KeyID {
+		t.Errorf("got new token signed with key %v want %s", kid, result.KeyID)
+	}
+
+	stored, err := app.models.BreakGlassModel.Latest(t.Context())
+	if err != nil {
+		t.Fatalf("failed to load break glass: %v", err)
+	}
+	if stored.Actor != "admin_token:"+keyID(adminToken) || stored.CompletedAt == nil || stored.SessionsRevoked == nil || *stored.SessionsRevoked != 1 {
+		t.Errorf("got audit record %+v", stored)
+	}
+
+	// The table holds only a salt, so nothing can be signed without the
+	// break glass secret.
+	withoutSecret := cfg
+	withoutSecret.JWT.BreakGlassSecret = ""
+	if _, err := app.generateAccessToken(&withoutSecret, user, session.SessionID); !errors.Is(err, errNoSigningKey) {
+		t.Errorf("got error %v without the break glass secret want %v", err, errNoSigningKey)
+	}
+}
+
+func TestBreakGlassKey(t *testing.T) {
+	cfg := *app.config.Load()
+	cfg.JWT.BreakGlassSecret = "break-glass-secret-ensure-32-bytes-long!"
+	bg := &data.BreakGlass{SigningKeySalt: "first"}
+
+	key := breakGlassKey(&cfg, bg)
+	if len(key) < 32 {
+		t.Fatalf("got key %q want at least 32 bytes", key)
+	}
+	if other := breakGlassKey(&cfg, &data.BreakGlass{SigningKeySalt: "second"}); other == key {
+		t.Error("expected another salt to give another key")
+	}
+
+	rotated := cfg
+	rotated.JWT.BreakGlassSecret = strings.ToUpper(cfg.JWT.BreakGlassSecret)
+	if breakGlassKey(&rotated, bg) == key {
+		t.Error("expected another secret to give another key")
+	}
+
+	rotated.JWT.BreakGlassSecret = ""
+	if got := breakGlassKey(&rotated, bg); got != "" {
+		t.Errorf("got key %q without a secret want none", got)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"auth/internal/config"
+	"auth/internal/data"
+	"auth/internal/geoip"
+	"auth/internal/useragent"
+	"errors"
+	"log/slog"
+	"net/netip"
+)
+
+// describeClient fills in what the user agent and IP address of session
+// tell about the client, so that users can recognize their sessions, and
+// returns where the client was located. A device type the caller did not
+// send is taken from the user agent.
+func (app *application) describeClient(session *data.Session) geoip.Location {
+	ua := useragent.Parse(session.UserAgent)
+	session.Browser, session.OS, session.Device = ua.Browser, ua.OS, ua.Device
+	if session.DeviceType == "" {
+		session.DeviceType = ua.DeviceType
+	}
+
+	if session.IPAddress == nil {
+		return geoip.Location{}
+	}
+	addr, err := netip.ParseAddr(*session.IPAddress)
+	if err != nil {
+		return geoip.Location{}
+	}
+
+	loc, err := app.locate(app.geoip.Load(), addr)
+	if err != nil {
+		app.logger.Warn("failed to locate ip address", slog.Any("err", err.Error()))
+		return geoip.Location{}
+	}
+	session.Country, session.City = loc.Country, loc.City
+	return loc
+}
+
+// locate looks addr up in locator. A reload closes the database it
+// replaces, possibly while a lookup that loaded it is still on its way, so a
+// closed database hands the lookup to the one that replaced it.
+func (app *application) locate(locator *geoip.Reader, addr netip.Addr) (geoip.Location, error) {
+	for locator != nil {
+		loc, err := locator.Lookup(addr)
+		if !errors.Is(err, geoip.ErrClosed) {
+			return loc, err
+		}
+		current := app.geoip.Load()
+		if current == locator {
+			return geoip.Location{}, err
+		}
+		locator = current
+	}
+	return geoip.Location{}, nil
+}
+
+// openGeoIP opens the GeoIP database cfg names. It returns nil, and
+// sessions are not located, when cfg names none.
+func openGeoIP(cfg config.GeoIP) (*geoip.Reader, error) {
+	if cfg.Database == "" {
+		return nil, nil
+	}
+	return geoip.Open(cfg.Database)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/nats-io/nats.go/micro"
+)
+
+// Callers tell the service how long they will wait for a reply with either
+// header; when both are set the earlier deadline wins. Request-Deadline is
+// an RFC 3339 timestamp, Request-Timeout a duration ("1500ms", "2s")
+// counted from when the message arrives, which is immune to clock skew.
+const (
+	deadlineHeader = "Request-Deadline"
+	timeoutHeader  = "Request-Timeout"
+)
+
+// withDeadline bounds the handling of req by the deadline its caller asked
+// for, and in any case by the configured request timeout. Invalid header
+// values are ignored.
+func (app *application) withDeadline(ctx context.Context, req micro.Request) (context.Context, context.CancelFunc) {
+	received := time.Now()
+	deadline := received.Add(app.config.Load().RequestTimeout)
+
+	if v := req.Headers().Get(deadlineHeader); v != "" {
+		if d, err := time.Parse(time.RFC3339Nano, v); err == nil && d.Before(deadline) {
+			deadline = d
+		}
+	}
+	if v := req.Headers().Get(timeoutHeader); v != "" {
+		if timeout, err := time.ParseDuration(v); err == nil && received.Add(timeout).Before(deadline) {
+			deadline = received.Add(timeout)
+		}
+	}
+
+	return context.WithDeadline(ctx, deadline)
+}
+
+// expired reports whether the caller of req has stopped waiting for the
+// reply, in which case sending it would only waste work. It is recorded so
+// that slow dependencies show up in the metrics.
+func (app *application) expired(ctx context.Context, req micro.Request) bool {
+	if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return false
+	}
+
+	app.metrics.deadlineExceeded.WithLabelValues(req.Subject()).Inc()
+	app.logger.Warn("request deadline exceeded, not responding", slog.String("subject", req.Subject()))
+	return true
+}
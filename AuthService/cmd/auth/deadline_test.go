@@ -0,0 +1,43 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+func TestRequestDeadline(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		value   string
+		replied bool
+	}{
+		{"no deadline", "", "", true},
+		{"future deadline", deadlineHeader, time.Now().Add(time.Minute).Format(time.RFC3339Nano), true},
+		{"past deadline", deadlineHeader, time.Now().Add(-time.Second).Format(time.RFC3339Nano), false},
+		{"generous timeout", timeoutHeader, "30s", true},
+		{"exhausted timeout", timeoutHeader, "1ns", false},
+		{"invalid value is ignored", timeoutHeader, "soon", true},
+	}
+
+	for _, ts := range tests {
+		t.Run(ts.name, func(t *testing.T) {
+			msg := nats.NewMsg("auth.v1.validate")
+			msg.Data = []byte(`{}`)
+			if ts.header != "" {
+				msg.Header.Set(ts.header, ts.value)
+			}
+
+			_, err := app.nc.RequestMsg(msg, 500*time.Millisecond)
+			switch {
+			case ts.replied && err != nil:
+				t.Errorf("expected a reply, got %v", err)
+			case !ts.replied && !errors.Is(err, nats.ErrTimeout):
+				t.Errorf("expected no reply, got %v", err)
+			}
+		})
+	}
+}
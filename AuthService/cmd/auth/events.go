@@ -0,0 +1,32 @@
+package main
+
+import (
+	"auth/internal/data"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+)
+
+// publishEvent publishes event on subject. Events are best effort: a
+// failure is logged and does not fail the request that caused it.
+func (app *application) publishEvent(ctx context.Context, subject string, event any) {
+	payload, err := json.Marshal(event)
+	if err == nil {
+		err = app.publish(ctx, subject, payload)
+	}
+	if err != nil {
+		app.logger.Error("failed to publish event", slog.String("subject", subject), slog.Any("err", err.Error()))
+	}
+}
+
+// tokensRevoked announces that the token version of userID moved on to
+// version for reason.
+func (app *application) tokensRevoked(ctx context.Context, userID string, version int, reason string) {
+	app.publishEvent(ctx, data.SubjectTokensRevoked, data.TokensRevokedEvent{
+		UserID:       userID,
+		TokenVersion: version,
+		Reason:       reason,
+		OccurredAt:   time.Now().UTC(),
+	})
+}
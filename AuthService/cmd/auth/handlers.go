@@ -1,109 +1,313 @@
 package main
 
 import (
+	"auth/internal/config"
 	"auth/internal/data"
-	"auth/internal/validator"
+	v1 "auth/internal/data/v1"
+	"auth/internal/passhash"
+	"context"
 	"crypto/sha256"
 	"errors"
+	"io/fs"
+	"log/slog"
+	"maps"
 	"net/http"
-	"strings"
+	"slices"
 	"time"
 
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
-	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
 )
 
+// endpoint describes a request-reply subject served under an API version.
+// Mutating endpoints honour the Idempotency-Key header; heavy ones hash
+// passwords or, like break_glass, hold a worker for long, and are handled in
+// the heavy worker lane.
+type endpoint struct {
+	name        string
+	description string
+	mutating    bool
+	heavy       bool
+	handler     func(context.Context, micro.Request)
+}
+
+// apiVersion is a set of endpoints served under auth.<name>.*, together with
+// the JSON schemas of their payloads.
+type apiVersion struct {
+	name      string
+	schemas   fs.FS
+	endpoints []endpoint
+}
+
+// apiVersions lists the served API versions, oldest first. A new version
+// gets its own DTO package under internal/data and its own entry here; the
+// older ones keep running unchanged until their callers have moved on.
+func (app *application) apiVersions() []apiVersion {
+	return []apiVersion{
+		{"v1", v1.Schemas, app.v1Endpoints()},
+	}
+}
+
+func (app *application) v1Endpoints() []endpoint {
+	return []endpoint{
+		{"healthcheck", "Reports the health of the service and its dependencies.", false, false, app.healthcheck},
+		{"register", "Creates a new user.", true, true, app.registerHandler},
+		{"login", "Authenticates a user and opens a device session.", true, true, app.loginHandler},
+		{"validate", "Validates an access token and returns its claims.", false, false, app.accessTokenHandler},
+		{"refresh", "Issues a new access token for a refresh token.", true, false, app.refreshTokenHandler},
+		{"logout", "Revokes a device session.", true, false, app.logOutHandler},
+		{"change_password", "Replaces the password of the authenticated user.", true, true, app.changePasswordHandler},
+		{"logout_all", "Revokes every session and access token of the authenticated user.", true, false, app.logOutEverywhereHandler},
+		{"break_glass", "Admin only: revokes every session and access token and rotates the signing key.", true, true, app.breakGlassHandler},
+		{"set_role", "Admin only: changes the role of a user, which makes their access tokens stale.", true, false, app.setRoleHandler},
+	}
+}
+
+// start registers the auth endpoints with the NATS micro framework, which
+// makes them discoverable through $SRV.PING, $SRV.INFO and $SRV.STATS.
 func (app *application) start() error {
-	_, err := app.nc.QueueSubscribe("auth.*", "auth_workers", func(msg *nats.Msg) {
-		subject := strings.Split(msg.Subject, ".")[1]
-
-		switch subject {
-		case "healthcheck":
-			app.healthcheck(msg)
-		case "register":
-			app.registerHandler(msg)
-		case "login":
-			app.loginHandler(msg)
-		case "validate":
-			app.accessTokenHandler(msg)
-		case "refresh":
-			app.refreshTokenHandler(msg)
-		case "logout":
-			app.logOutHandler(msg)
-		default:
-			app.sendErrorResponse(msg, http.StatusUnprocessableEntity, "invalid subject")
-		}
+	svc, err := micro.AddService(app.nc, micro.Config{
+		Name:        "auth",
+		Version:     version,
+		Description: "Authentication and session management.",
+		QueueGroup:  "auth_workers",
+		ErrorHandler: func(_ micro.Service, err *micro.NATSError) {
+			app.logger.Error("service error", slog.String("subject", err.Subject), slog.String("err", err.Description))
+		},
 	})
-	return err
+	if err != nil {
+		return err
+	}
+
+	cfg := app.config.Load()
+	dummyUser, err := newDummyUser(newHasher(cfg.Password), passhash.NewPepper(cfg.Password.Pepper))
+	if err != nil {
+		return err
+	}
+	app.dummyUser.Store(dummyUser)
+
+	locator, err := openGeoIP(cfg.GeoIP)
+	if err != nil {
+		return err
+	}
+	app.geoip.Store(locator)
+
+	app.heavyLane = newLane("heavy", cfg.Workers.Heavy, cfg.Workers.HeavyQueue)
+	app.lightLane = newLane("light", cfg.Workers.Light, cfg.Workers.LightQueue)
+
+	// legacy collects, for every unversioned auth.<name> subject, the handler
+	// of each version that serves it.
+	legacy := map[string]map[string]func(context.Context, micro.Request){}
+	replacedBy := map[string]string{}
+	lanes := map[string]*lane{}
+
+	for _, api := range app.apiVersions() {
+		group := svc.AddGroup("auth." + api.name)
+		for _, e := range api.endpoints {
+			metadata, err := data.EndpointMetadata(api.schemas, e.name, e.description)
+			if err != nil {
+				return err
+			}
+			metadata["api_version"] = api.name
+
+			handler := e.handler
+			if e.mutating {
+				handler = app.idempotent(handler)
+				metadata["idempotency_key_header"] = idempotencyKeyHeader
+			}
+
+			l := app.lightLane
+			if e.heavy {
+				l = app.heavyLane
+			}
+
+			err = group.AddEndpoint(api.name+"_"+e.name, app.handle(l, versioned(api.name, handler)),
+				micro.WithEndpointSubject(e.name),
+				micro.WithEndpointMetadata(metadata),
+			)
+			if err != nil {
+				return err
+			}
+
+			if !slices.Contains(legacyEndpoints, e.name) {
+				continue
+			}
+			if legacy[e.name] == nil {
+				legacy[e.name] = map[string]func(context.Context, micro.Request){}
+			}
+			legacy[e.name][api.name] = handler
+			replacedBy[e.name] = "auth." + api.name + "." + e.name
+			lanes[e.name] = l
+		}
+	}
+
+	// The unversioned subjects predate versioning. They stay available as
+	// deprecated aliases so existing callers keep working while they move to
+	// the versioned ones.
+	group := svc.AddGroup("auth")
+	for _, name := range slices.Sorted(maps.Keys(legacy)) {
+		metadata := map[string]string{
+			"description": "Deprecated alias of " + replacedBy[name] + ".",
+			"format":      "application/json",
+			"deprecated":  "true",
+			"replaced_by": replacedBy[name],
+		}
+		err = group.AddEndpoint(name, app.handle(lanes[name], app.legacy(replacedBy[name], legacy[name])),
+			micro.WithEndpointMetadata(metadata),
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	app.service = svc
+	return nil
 }
 
-func (app *application) healthcheck(msg *nats.Msg) {
-	app.sendSuccessResponse(msg, http.StatusOK, "auth up and running")
+// handle wraps an endpoint handler with the bookkeeping every request needs:
+// in-flight tracking for graceful shutdown, tracing, the reply language, the
+// caller's deadline and latency metrics. The handler runs on a worker of l;
+// when l's queue is full the request is rejected with a 503 at once. The
+// deadline starts when the message arrives, so time spent queued counts
+// against it.
+func (app *application) handle(l *lane, handler func(context.Context, micro.Request)) micro.HandlerFunc {
+	return func(req micro.Request) {
+		app.wg.Add(1)
+		ctx, span := startHandlerSpan(req)
+		ctx = contextWithLocalizer(ctx, app.localizer(req))
+		ctx, cancel := app.withDeadline(ctx, req)
+
+		done := func() {
+			cancel()
+			span.End()
+			app.wg.Done()
+		}
+
+		queued := app.metrics.queuedRequests.WithLabelValues(l.name)
+		queued.Inc()
+		accepted := l.submit(func() {
+			defer done()
+			queued.Dec()
+
+			if app.expired(ctx, req) {
+				return
+			}
+
+			start := time.Now()
+			handler(ctx, req)
+			app.metrics.requestDuration.WithLabelValues(req.Subject()).Observe(time.Since(start).Seconds())
+		})
+		if accepted {
+			return
+		}
+
+		defer done()
+		queued.Dec()
+		app.metrics.shedRequests.WithLabelValues(l.name).Inc()
+		app.sendErrorResponse(ctx, req, http.StatusServiceUnavailable, data.CodeOverloaded)
+	}
 }
 
-func (app *application) registerHandler(msg *nats.Msg) {
-	var input data.RegisterInput
-	if !app.readJSON(msg, &input, func(v *validator.Validator) {
-		data.ValidateRegisterInput(v, input)
-	}) {
+func (app *application) healthcheck(ctx context.Context, req micro.Request) {
+	report, ready := app.checkHealth(ctx)
+	if !ready {
+		app.respond(ctx, req, data.Response{
+			StatusCode: http.StatusServiceUnavailable,
+			Code:       data.CodeServiceUnavailable,
+			Data:       report,
+		})
+		return
+	}
+	app.sendSuccessResponse(ctx, req, http.StatusOK, report)
+}
+
+func (app *application) registerHandler(ctx context.Context, req micro.Request) {
+	cfg := app.config.Load()
+
+	var input v1.RegisterInput
+	if !app.readJSON(ctx, req, &input) {
+		return
+	}
+
+	if !app.checkNewPassword(ctx, req, cfg.Password, "/password", input.Password, input.Email, input.Username) {
 		return
 	}
 
 	user := &data.User{Email: input.Email, Username: input.Username}
-	if err := user.Password.Set(input.Password); err != nil {
-		app.sendInternalServerErrorResponse(msg)
+	if !app.setPassword(ctx, req, cfg.Password, user, "/password", input.Password) {
 		return
 	}
-	if err := app.models.UserModel.Insert(user); err != nil {
+	if err := app.models.UserModel.Insert(ctx, user); err != nil {
 		if errors.Is(err, data.ErrDuplicateEmail) {
-			app.sendErrorResponse(msg, http.StatusConflict, "email is already in use")
+			app.sendErrorResponse(ctx, req, http.StatusConflict, data.CodeEmailInUse)
 			return
 		}
-		app.sendInternalServerErrorResponse(msg)
+		app.sendInternalServerErrorResponse(ctx, req)
 		return
 	}
-	app.sendSuccessResponse(msg, http.StatusCreated, "user successfully created")
+	app.sendSuccessResponse(ctx, req, http.StatusCreated, localizerFromContext(ctx).T("user.created"))
 }
 
-func (app *application) loginHandler(msg *nats.Msg) {
-	var input data.LoginInput
-	if !app.readJSON(msg, &input, func(v *validator.Validator) {
-		data.ValidateLoginInput(v, input)
-	}) {
+func (app *application) loginHandler(ctx context.Context, req micro.Request) {
+	cfg := app.config.Load()
+
+	var input v1.LoginInput
+	if !app.readJSON(ctx, req, &input) {
 		return
 	}
 
-	user, err := app.models.UserModel.GetByEmail(input.Email)
+	user, err := app.models.UserModel.GetByEmail(ctx, input.Email)
 	if err != nil && !errors.Is(err, data.ErrNoRecord) {
-		app.sendInternalServerErrorResponse(msg)
+		app.sendInternalServerErrorResponse(ctx, req)
 		return
 	}
 
-	ok, err := func() (bool, error) {
-		dummyHash := data.User{}
-		_ = dummyHash.Password.Set(input.Password)
-		if user != nil {
-			return user.Password.Matches(input.Password)
-		}
-		return dummyHash.Password.Matches(input.Password)
-	}()
+	start := time.Now()
+	ok, err := app.checkPassword(cfg.Password, user, input.Password)
+	app.metrics.observePasswordHashing("compare", start)
 
 	if err != nil || !ok || user == nil {
-		app.sendErrorResponse(msg, http.StatusUnauthorized, "invalid credentials")
+		switch {
+		case user == nil:
+			app.metrics.loginFailures.WithLabelValues("unknown_user").Inc()
+		case err != nil:
+			app.metrics.loginFailures.WithLabelValues("error").Inc()
+		default:
+			app.metrics.loginFailures.WithLabelValues("wrong_password").Inc()
+		}
+		app.sendErrorResponse(ctx, req, http.StatusUnauthorized, data.CodeInvalidCredentials)
+		return
+	}
+
+	hasher, pepper := newHasher(cfg.Password), passhash.NewPepper(cfg.Password.Pepper)
+	if user.Password.NeedsRehash(hasher, pepper) {
+		app.rehashPassword(ctx, user, input.Password, hasher, pepper)
+	}
+
+	if passwordExpired(cfg.Password, user) {
+		token, err := app.generatePasswordChangeToken(cfg, user)
+		if err != nil {
+			app.sendInternalServerErrorResponse(ctx, req)
+			return
+		}
+		app.respond(ctx, req, data.Response{
+			StatusCode: http.StatusForbidden,
+			Code:       data.CodePasswordExpired,
+			Data:       v1.PasswordExpiredResponse{PasswordChangeToken: token},
+		})
 		return
 	}
 
 	sessionID := uuid.NewString()
-	accessToken, err := app.generateAccessToken(user.ID, user.Email, user.Username, sessionID)
+	accessToken, err := app.generateAccessToken(cfg, user, sessionID)
 	if err != nil {
-		app.sendInternalServerErrorResponse(msg)
+		app.sendInternalServerErrorResponse(ctx, req)
 		return
 	}
 	opaqueToken, err := app.generateOpaqueToken()
 	if err != nil {
 		app.logger.Error("error generating opaque token")
-		app.sendInternalServerErrorResponse(msg)
+		app.sendInternalServerErrorResponse(ctx, req)
 		return
 	}
 	hash := sha256.Sum256([]byte(opaqueToken))
@@ -115,7 +319,6 @@ func (app *application) loginHandler(msg *nats.Msg) {
 		DeviceName: input.DeviceName,
 		DeviceType: input.DeviceType,
 		RememberMe: input.RememberMe,
-		ExpiresAt:  time.Now().Add(24 * time.Hour),
 		IPAddress:  nil,
 		UserAgent:  input.UserAgent,
 	}
@@ -123,148 +326,264 @@ func (app *application) loginHandler(msg *nats.Msg) {
 	if input.IPAddress != "" {
 		session.IPAddress = &input.IPAddress
 	}
-	if session.RememberMe {
-		session.ExpiresAt = time.Now().Add(30 * 24 * time.Hour)
-	}
+	loc := app.describeClient(session)
 
-	if err := app.models.SessionModel.Insert(session); err != nil {
-		app.sendInternalServerErrorResponse(msg)
+	now := time.Now()
+	idle, lifetime := cfg.Session.Limits(session.DeviceType, session.RememberMe)
+	session.ExpiresAt = now.Add(idle)
+	session.AbsoluteExpiresAt = now.Add(lifetime)
+
+	maxSessions, maxForDevice := cfg.Session.MaxSessionsFor(user.Role, session.DeviceType)
+	evicted, err := app.models.SessionModel.Create(ctx, session, data.SessionQuota{
+		Max:          maxSessions,
+		MaxForDevice: maxForDevice,
+		Reject:       cfg.Session.OnLimit == config.SessionLimitReject,
+	})
+	if err != nil {
+		if errors.Is(err, data.ErrSessionLimit) {
+			app.sendErrorResponse(ctx, req, http.StatusConflict, data.CodeSessionLimit)
+			return
+		}
+		app.sendInternalServerErrorResponse(ctx, req)
 		return
 	}
+	app.checkLogin(ctx, cfg.LoginAlerts, session, loc)
 
-	otherSessions, err := app.models.GetOtherSessions(user.ID, session.SessionID)
+	otherSessions, err := app.models.GetOtherSessions(ctx, user.ID, session.SessionID)
 	if err != nil {
-		app.sendInternalServerErrorResponse(msg)
+		app.sendInternalServerErrorResponse(ctx, req)
 		return
 	}
-	if len(otherSessions) > 4 {
-		err = app.models.SessionModel.Revoke(otherSessions[4].SessionID)
-		if err != nil {
-			app.sendInternalServerErrorResponse(msg)
-			return
-		}
-	}
-	app.sendSuccessResponse(msg, http.StatusOK, data.LoginResponse{
-		AccessToken:  accessToken,
-		RefreshToken: opaqueToken,
-		CurrentSession: data.SessionResponse{
-			SessionID:  session.SessionID,
-			DeviceName: session.DeviceName,
-			DeviceType: session.DeviceType,
-			LastUsedAt: session.LastUsedAt,
-		},
-		OtherSessions: otherSessions,
+
+	app.sendSuccessResponse(ctx, req, http.StatusOK, v1.LoginResponse{
+		AccessToken:     accessToken,
+		RefreshToken:    opaqueToken,
+		CurrentSession:  v1.NewSessionResponse(*session),
+		OtherSessions:   v1.NewSessionResponses(otherSessions),
+		EvictedSessions: v1.NewSessionResponses(evicted),
 	})
 }
 
-func (app *application) logOutHandler(msg *nats.Msg) {
-	var input data.LogoutInput
-	if !app.readJSON(msg, &input, func(v *validator.Validator) {
-		data.ValidateLogoutInput(v, input)
-	}) {
+func (app *application) logOutHandler(ctx context.Context, req micro.Request) {
+	var input v1.LogoutInput
+	if !app.readJSON(ctx, req, &input) {
 		return
 	}
 
-	err := app.models.SessionModel.Revoke(input.SessionID)
+	err := app.models.SessionModel.Revoke(ctx, input.SessionID)
 	if err != nil {
 		if errors.Is(err, data.ErrNoRecord) {
-			app.sendErrorResponse(msg, http.StatusNotFound, "session not found")
+			app.sendErrorResponse(ctx, req, http.StatusNotFound, data.CodeSessionNotFound)
 			return
 		}
-		app.sendInternalServerErrorResponse(msg)
+		app.sendInternalServerErrorResponse(ctx, req)
 		return
 	}
 
-	app.sendSuccessResponse(msg, http.StatusOK, "user successfully logged out")
+	app.sendSuccessResponse(ctx, req, http.StatusOK, localizerFromContext(ctx).T("user.logged_out"))
 }
 
-func (app *application) accessTokenHandler(msg *nats.Msg) {
-	var input data.AccessTokenInput
-	if !app.readJSON(msg, &input, func(v *validator.Validator) {
-		data.ValidateAccessTokenInput(v, input)
-	}) {
+// logOutEverywhereHandler ends every session of the user and makes all the
+// access tokens issued to them stale, including the one sent.
+func (app *application) logOutEverywhereHandler(ctx context.Context, req micro.Request) {
+	cfg := app.config.Load()
+
+	var input v1.AccessTokenInput
+	if !app.readJSON(ctx, req, &input) {
+		return
+	}
+
+	claims, ok := app.authenticate(ctx, req, cfg, input.TokenString)
+	if !ok {
 		return
 	}
 
-	claims, err := app.validateAccessToken(input.TokenString)
+	if _, err := app.models.SessionModel.RevokeAllForUser(ctx, claims.UserID); err != nil {
+		app.sendInternalServerErrorResponse(ctx, req)
+		return
+	}
+	version, err := app.models.UserModel.BumpTokenVersion(ctx, claims.UserID)
 	if err != nil {
-		switch {
-		case errors.Is(err, jwt.ErrTokenMalformed), errors.Is(err, jwt.ErrTokenNotValidYet):
-			app.sendErrorResponse(msg, http.StatusUnauthorized, "invalid token")
-		case errors.Is(err, jwt.ErrTokenExpired):
-			app.sendErrorResponse(msg, http.StatusUnauthorized, "token expired")
-		default:
-			app.sendInternalServerErrorResponse(msg)
+		if errors.Is(err, data.ErrNoRecord) {
+			app.sendErrorResponse(ctx, req, http.StatusUnauthorized, data.CodeTokenInvalid)
+			return
 		}
+		app.sendInternalServerErrorResponse(ctx, req)
 		return
 	}
+	app.tokensRevoked(ctx, claims.UserID, version, data.RevokeReasonLogoutAll)
 
-	session, err := app.models.SessionModel.GetByID(claims.SessionID)
+	app.sendSuccessResponse(ctx, req, http.StatusOK, localizerFromContext(ctx).T("user.logged_out_everywhere"))
+}
+
+// setRoleHandler changes the role of a user. A change bumps their token
+// version, so the access tokens issued under the old role are rejected and
+// clients refresh; their sessions are kept.
+func (app *application) setRoleHandler(ctx context.Context, req micro.Request) {
+	cfg := app.config.Load()
+
+	actor, ok := app.authorizeAdmin(ctx, req, cfg)
+	if !ok {
+		return
+	}
+
+	var input v1.SetRoleInput
+	if !app.readJSON(ctx, req, &input) {
+		return
+	}
+
+	version, changed, err := app.models.UserModel.SetRole(ctx, input.UserID, input.Role)
 	if err != nil {
 		if errors.Is(err, data.ErrNoRecord) {
-			app.sendErrorResponse(msg, http.StatusUnauthorized, "no session found")
+			app.sendErrorResponse(ctx, req, http.StatusNotFound, data.CodeUserNotFound)
+			return
 		}
-		app.sendInternalServerErrorResponse(msg)
+		app.sendInternalServerErrorResponse(ctx, req)
+		return
+	}
+	if changed {
+		app.logger.Info("role changed",
+			slog.String("user_id", input.UserID),
+			slog.String("role", input.Role),
+			slog.String("actor", actor),
+		)
+		app.tokensRevoked(ctx, input.UserID, version, data.RevokeReasonRoleChanged)
+	}
+
+	app.sendSuccessResponse(ctx, req, http.StatusOK, v1.SetRoleResponse{
+		UserID:        input.UserID,
+		Role:          input.Role,
+		TokensRevoked: changed,
+	})
+}
+
+func (app *application) accessTokenHandler(ctx context.Context, req micro.Request) {
+	cfg := app.config.Load()
+
+	var input v1.AccessTokenInput
+	if !app.readJSON(ctx, req, &input) {
 		return
 	}
 
-	if session.RevokedAt != nil {
-		app.sendErrorResponse(msg, http.StatusUnauthorized, "token expired")
+	claims, ok := app.authenticate(ctx, req, cfg, input.TokenString)
+	if !ok {
 		return
 	}
 
-	app.sendSuccessResponse(msg, http.StatusOK, data.TokenValidationResponse{
+	app.sendSuccessResponse(ctx, req, http.StatusOK, v1.TokenValidationResponse{
 		UserID:   claims.UserID,
 		Email:    claims.Email,
 		Username: claims.Username,
 	})
 }
 
-func (app *application) refreshTokenHandler(msg *nats.Msg) {
-	var input data.RefreshTokenInput
-	if !app.readJSON(msg, &input, func(v *validator.Validator) {
-		data.ValidateRefreshTokenInput(v, input)
-	}) {
+func (app *application) refreshTokenHandler(ctx context.Context, req micro.Request) {
+	cfg := app.config.Load()
+
+	var input v1.RefreshTokenInput
+	if !app.readJSON(ctx, req, &input) {
 		return
 	}
 
 	hash := sha256.Sum256([]byte(input.TokenString))
-	session, err := app.models.SessionModel.GetByTokenHash(hash[:])
+	session, err := app.models.SessionModel.GetByTokenHash(ctx, hash[:])
 	if err != nil {
 		if errors.Is(err, data.ErrNoRecord) {
-			app.sendErrorResponse(msg, http.StatusUnauthorized, "invalid token")
+			app.sendErrorResponse(ctx, req, http.StatusUnauthorized, data.CodeTokenInvalid)
 			return
 		}
-		app.sendInternalServerErrorResponse(msg)
+		app.sendInternalServerErrorResponse(ctx, req)
 		return
 	}
 	switch {
-	case session.RevokedAt != nil:
-		app.sendErrorResponse(msg, http.StatusUnauthorized, true)
+	case session.RevokedAt != nil, app.revokedByBreakGlass(session):
+		app.sendErrorResponse(ctx, req, http.StatusUnauthorized, data.CodeSessionRevoked)
 		return
 	case time.Now().After(session.ExpiresAt):
-		app.sendErrorResponse(msg, http.StatusUnauthorized, false)
+		app.sendErrorResponse(ctx, req, http.StatusUnauthorized, data.CodeSessionExpired)
 		return
 	}
-	if err := app.models.SessionModel.UpdateLastUsed(session.SessionID); err != nil {
-		app.sendInternalServerErrorResponse(msg)
+	// Each refresh restarts the idle timeout, but the session still ends at
+	// its absolute expiry.
+	idle, _ := cfg.Session.Limits(session.DeviceType, session.RememberMe)
+	if err := app.models.SessionModel.Touch(ctx, session, idle); err != nil {
+		if errors.Is(err, data.ErrNoRecord) {
+			app.sendErrorResponse(ctx, req, http.StatusUnauthorized, data.CodeSessionExpired)
+			return
+		}
+		app.sendInternalServerErrorResponse(ctx, req)
 		return
 	}
-	user, err := app.models.UserModel.GetByID(session.UserID)
+	user, err := app.models.UserModel.GetByID(ctx, session.UserID)
 	if err != nil {
 		if errors.Is(err, data.ErrNoRecord) {
-			app.sendErrorResponse(msg, http.StatusUnauthorized, "invalid token")
+			app.sendErrorResponse(ctx, req, http.StatusUnauthorized, data.CodeTokenInvalid)
 			return
 		}
-		app.sendInternalServerErrorResponse(msg)
+		app.sendInternalServerErrorResponse(ctx, req)
 		return
 	}
-	accessToken, err := app.generateAccessToken(user.ID, user.Email, user.Username, session.SessionID)
+	accessToken, err := app.generateAccessToken(cfg, user, session.SessionID)
 	if err != nil {
-		app.sendInternalServerErrorResponse(msg)
+		app.sendInternalServerErrorResponse(ctx, req)
 		return
 	}
-	app.sendSuccessResponse(msg, http.StatusOK, data.TokenRefreshResponse{
-		AccessToken: accessToken,
+	app.sendSuccessResponse(ctx, req, http.StatusOK, v1.TokenRefreshResponse{
+		AccessToken:       accessToken,
+		ExpiresAt:         session.ExpiresAt,
+		AbsoluteExpiresAt: session.AbsoluteExpiresAt,
 	})
 }
+
+func (app *application) changePasswordHandler(ctx context.Context, req micro.Request) {
+	cfg := app.config.Load()
+
+	var input v1.ChangePasswordInput
+	if !app.readJSON(ctx, req, &input) {
+		return
+	}
+
+	claims, ok := app.authenticatePasswordChange(ctx, req, cfg, input.TokenString)
+	if !ok {
+		return
+	}
+
+	user, err := app.models.UserModel.GetByID(ctx, claims.UserID)
+	if err != nil {
+		if errors.Is(err, data.ErrNoRecord) {
+			app.sendErrorResponse(ctx, req, http.StatusUnauthorized, data.CodeTokenInvalid)
+			return
+		}
+		app.sendInternalServerErrorResponse(ctx, req)
+		return
+	}
+
+	start := time.Now()
+	ok, err = app.checkPassword(cfg.Password, user, input.CurrentPassword)
+	app.metrics.observePasswordHashing("compare", start)
+	if err != nil {
+		app.sendInternalServerErrorResponse(ctx, req)
+		return
+	}
+	if !ok {
+		app.sendErrorResponse(ctx, req, http.StatusUnauthorized, data.CodeInvalidCredentials)
+		return
+	}
+
+	if !app.checkNewPassword(ctx, req, cfg.Password, "/new_password", input.NewPassword, user.Email, user.Username) {
+		return
+	}
+	if !app.checkPasswordReuse(ctx, req, cfg.Password, user, "/new_password", input.NewPassword) {
+		return
+	}
+	if !app.setPassword(ctx, req, cfg.Password, user, "/new_password", input.NewPassword) {
+		return
+	}
+	if err := app.models.UserModel.ChangePassword(ctx, user, max(cfg.Password.Policy.History-1, 0)); err != nil {
+		app.sendInternalServerErrorResponse(ctx, req)
+		return
+	}
+	app.tokensRevoked(ctx, user.ID, user.TokenVersion, data.RevokeReasonPasswordChanged)
+
+	app.sendSuccessResponse(ctx, req, http.StatusOK, localizerFromContext(ctx).T("password.changed"))
+}
@@ -1,15 +1,24 @@
 package main
 
 import (
+	"auth/internal/config"
 	"auth/internal/data"
+	v1 "auth/internal/data/v1"
 	"auth/internal/testutils"
+	"bytes"
+	"context"
 	"crypto/sha256"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
 )
 
 func TestRegisterHandler(t *testing.T) {
@@ -18,22 +27,23 @@ func TestRegisterHandler(t *testing.T) {
 	tests := []Test{
 		{
 			name:    "success - valid register",
-			payload: []byte(`{ "email":"test@mail.com", "password":"password123", "username":"tester"}`),
+			payload: []byte(`{ "email":"test@mail.com", "password":"Xq7#vLm2!rTz", "username":"tester"}`),
 			want:    http.StatusCreated,
 		},
 		{
 			name:    "fail - email already in use",
-			payload: []byte(`{ "email":"test@mail.com", "password":"password123", "username":"tester"}`),
+			payload: []byte(`{ "email":"test@mail.com", "password":"Xq7#vLm2!rTz", "username":"tester"}`),
 			want:    http.StatusConflict,
+			code:    data.CodeEmailInUse,
 		},
 		{
 			name:    "fail - missing email",
-			payload: []byte(`{ "email":"", "password":"password123", "username":"tester"}`),
+			payload: []byte(`{ "email":"", "password":"Xq7#vLm2!rTz", "username":"tester"}`),
 			want:    http.StatusUnprocessableEntity,
 		},
 		{
 			name:    "fail - invalid email format",
-			payload: []byte(`{ "email":"not-an-email", "password":"password123", "username":"tester"}`),
+			payload: []byte(`{ "email":"not-an-email", "password":"Xq7#vLm2!rTz", "username":"tester"}`),
 			want:    http.StatusUnprocessableEntity,
 		},
 		{
@@ -41,16 +51,39 @@ func TestRegisterHandler(t *testing.T) {
 			payload: []byte(`{ "email":"valid@mail.com", "password":"123", "username":"tester"}`),
 			want:    http.StatusUnprocessableEntity,
 		},
+		{
+			name:    "success - password beyond bcrypt's 72 bytes",
+			payload: []byte(`{ "email":"long@mail.com", "password":"Xq7#vLm2!rTz9@` + strings.Repeat("p", 86) + `", "username":"tester"}`),
+			want:    http.StatusCreated,
+		},
+		{
+			name:    "fail - password too long",
+			payload: []byte(`{ "email":"long2@mail.com", "password":"` + strings.Repeat("p", 1025) + `", "username":"tester"}`),
+			want:    http.StatusUnprocessableEntity,
+			code:    data.CodeValidationFailed,
+		},
+		{
+			name:    "fail - common password",
+			payload: []byte(`{ "email":"weak@mail.com", "password":"password123", "username":"tester"}`),
+			want:    http.StatusUnprocessableEntity,
+			code:    data.CodeValidationFailed,
+		},
+		{
+			name:    "fail - password resembles the username",
+			payload: []byte(`{ "email":"similar@mail.com", "password":"Tester-123", "username":"tester"}`),
+			want:    http.StatusUnprocessableEntity,
+			code:    data.CodeValidationFailed,
+		},
 		{
 			name:    "fail - missing username",
-			payload: []byte(`{ "email":"valid2@mail.com", "password":"password123", "username":""}`),
+			payload: []byte(`{ "email":"valid2@mail.com", "password":"Xq7#vLm2!rTz", "username":""}`),
 			want:    http.StatusUnprocessableEntity,
 		},
 		malformedJSON,
 		emptyJSON,
 	}
 
-	runTests(t, "auth.register", tests)
+	runTests(t, "auth.v1.register", tests)
 }
 
 func TestLoginHandler(t *testing.T) {
@@ -81,6 +114,7 @@ func TestLoginHandler(t *testing.T) {
                 "user_agent":"go-test-client"
             }`),
 			want: http.StatusUnauthorized,
+			code: data.CodeInvalidCredentials,
 		},
 		{
 			name: "fail - non-existent user",
@@ -91,6 +125,7 @@ func TestLoginHandler(t *testing.T) {
                 "user_agent":"go-test-client"
             }`),
 			want: http.StatusUnauthorized,
+			code: data.CodeInvalidCredentials,
 		},
 		{
 			name: "fail - invalid email format",
@@ -101,14 +136,69 @@ func TestLoginHandler(t *testing.T) {
             }`),
 			want: http.StatusUnprocessableEntity,
 		},
+		{
+			name: "fail - unknown device type",
+			payload: []byte(`{
+                "email":"test@mail.com",
+                "password":"12345678",
+                "device_type":"toaster"
+            }`),
+			want: http.StatusUnprocessableEntity,
+			code: data.CodeValidationFailed,
+		},
+		{
+			name: "fail - invalid ip address",
+			payload: []byte(`{
+                "email":"test@mail.com",
+                "password":"12345678",
+                "ip_address":"not-an-ip"
+            }`),
+			want: http.StatusUnprocessableEntity,
+			code: data.CodeValidationFailed,
+		},
 		malformedJSON,
 		emptyJSON,
 	}
 
-	runTests(t, "auth.login", tests)
+	runTests(t, "auth.v1.login", tests)
 
 }
 
+func TestLoginClientDetails(t *testing.T) {
+	testutils.ResetTestDB(t, dsn)
+	_ = createTestUser(t)
+
+	payload := `{"email":"test@mail.com","password":"12345678","ip_address":"203.0.113.7",
+		"user_agent":"Mozilla/5.0 (iPhone; CPU iPhone OS 17_1_2 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1.2 Mobile/15E148 Safari/604.1"}`
+	msg, err := app.nc.Request("auth.v1.login", []byte(payload), 2*time.Second)
+	if err != nil {
+		t.Fatalf("failed to get login response: %v", err)
+	}
+	var r struct {
+		data.Response
+		Data v1.LoginResponse `json:"data"`
+	}
+	if err := json.Unmarshal(msg.Data, &r); err != nil {
+		t.Fatalf("failed to unmarshal login response: %v", err)
+	}
+
+	got := r.Data.CurrentSession
+	if got.IPAddress != "203.0.113.7" || got.Browser != "Safari 17" || got.OS != "iOS 17.1.2" || got.Device != "iPhone" {
+		t.Errorf("got ip %q, browser %q, os %q, device %q", got.IPAddress, got.Browser, got.OS, got.Device)
+	}
+	if got.DeviceType != "mobile" {
+		t.Errorf("got device type %q want it taken from the user agent", got.DeviceType)
+	}
+
+	stored, err := app.models.SessionModel.GetByID(context.Background(), got.SessionID)
+	if err != nil {
+		t.Fatalf("failed to get session: %v", err)
+	}
+	if stored.IPAddress == nil || *stored.IPAddress != "203.0.113.7" || stored.Browser != got.Browser {
+		t.Errorf("expected the client details to be stored, got %+v", stored)
+	}
+}
+
 func TestSessionPruning(t *testing.T) {
 	testutils.ResetTestDB(t, dsn)
 
@@ -124,7 +214,7 @@ func TestSessionPruning(t *testing.T) {
 			LastUsedAt: time.Now().Add(time.Duration(-i) * time.Hour),
 			ExpiresAt:  time.Now().Add(24 * time.Hour),
 		}
-		err := app.models.SessionModel.Insert(s)
+		err := app.models.SessionModel.Insert(context.Background(), s)
 		if err != nil {
 			t.Fatalf("failed to insert setup session %d: %v", i, err)
 		}
@@ -141,10 +231,13 @@ func TestSessionPruning(t *testing.T) {
 		ExpiresAt: time.Now().Add(24 * time.Hour),
 	}
 
-	err := app.models.SessionModel.Insert(sixthSession)
+	evicted, err := app.models.SessionModel.Create(context.Background(), sixthSession, data.SessionQuota{Max: 5})
 	if err != nil {
 		t.Fatalf("failed to insert 6th session: %v", err)
 	}
+	if len(evicted) != 1 || evicted[0].SessionID != oldestID {
+		t.Errorf("got evicted sessions %v want only %s", evicted, oldestID)
+	}
 
 	var revokedAt *time.Time
 	query := "SELECT revoked_at FROM sessions WHERE session_id = $1"
@@ -156,6 +249,65 @@ func TestSessionPruning(t *testing.T) {
 	if revokedAt == nil {
 		t.Errorf("expected oldest session %s to be revoked, but it is still active", oldestID)
 	}
+
+	seventhSession := &data.Session{
+		SessionID: uuid.NewString(),
+		UserID:    user.ID,
+		TokenHash: []byte("seventh"),
+		ExpiresAt: time.Now().Add(24 * time.Hour),
+	}
+	_, err = app.models.SessionModel.Create(context.Background(), seventhSession, data.SessionQuota{Max: 5, Reject: true})
+	if !errors.Is(err, data.ErrSessionLimit) {
+		t.Errorf("got %v want %v", err, data.ErrSessionLimit)
+	}
+}
+
+func TestLoginSessionLimit(t *testing.T) {
+	testutils.ResetTestDB(t, dsn)
+
+	previous := app.config.Load()
+	t.Cleanup(func() { app.config.Store(previous) })
+
+	cfg := *previous
+	cfg.Session.MaxSessions = 3
+	cfg.Session.DeviceMaxSessions = map[string]int{"mobile": 1}
+	app.config.Store(&cfg)
+
+	_ = createTestUser(t)
+	login := func(deviceType string) (data.Response, v1.LoginResponse) {
+		t.Helper()
+		payload := fmt.Sprintf(`{"email":"test@mail.com","password":"12345678","device_type":"%s"}`, deviceType)
+		msg, err := app.nc.Request("auth.v1.login", []byte(payload), 2*time.Second)
+		if err != nil {
+			t.Fatalf("failed to get login response: %v", err)
+		}
+		var r struct {
+			data.Response
+			Data v1.LoginResponse `json:"data"`
+		}
+		if err := json.Unmarshal(msg.Data, &r); err != nil {
+			t.Fatalf("failed to unmarshal login response: %v", err)
+		}
+		return r.Response, r.Data
+	}
+
+	_, first := login("mobile")
+	if _, second := login("mobile"); len(second.EvictedSessions) != 1 || second.EvictedSessions[0].SessionID != first.CurrentSession.SessionID {
+		t.Errorf("expected the second mobile login to evict %s, got %v", first.CurrentSession.SessionID, second.EvictedSessions)
+	}
+
+	for range 2 {
+		if _, desktop := login("desktop"); len(desktop.EvictedSessions) != 0 {
+			t.Errorf("expected no evictions under the limit, got %v", desktop.EvictedSessions)
+		}
+	}
+
+	reject := cfg
+	reject.Session.OnLimit = config.SessionLimitReject
+	app.config.Store(&reject)
+	if r, _ := login("tablet"); r.StatusCode != http.StatusConflict || r.Code != data.CodeSessionLimit {
+		t.Errorf("got %d %s want %d %s", r.StatusCode, r.Code, http.StatusConflict, data.CodeSessionLimit)
+	}
 }
 
 func TestLogoutHandler(t *testing.T) {
@@ -178,11 +330,17 @@ func TestLogoutHandler(t *testing.T) {
 			payload: []byte(fmt.Sprintf(`{"session_id": "%s"}`, expiredSession.SessionID)),
 			want:    http.StatusNotFound,
 		},
+		{
+			name:    "fail - session id is not a uuid",
+			payload: []byte(`{"session_id": "not-a-uuid"}`),
+			want:    http.StatusUnprocessableEntity,
+			code:    data.CodeValidationFailed,
+		},
 		malformedJSON,
 		emptyJSON,
 	}
 
-	runTests(t, "auth.logout", tests)
+	runTests(t, "auth.v1.logout", tests)
 }
 
 func TestValidateTokenHandler(t *testing.T) {
@@ -194,7 +352,7 @@ func TestValidateTokenHandler(t *testing.T) {
 	hash = sha256.Sum256([]byte(generateOpaqueTokenForTest(t)))
 	expiredSession := createTestSession(t, user.ID, hash[:], time.Now().Add(-1*time.Hour))
 
-	validToken, err := app.generateAccessToken(user.ID, user.Email, user.Username, validSession.SessionID)
+	validToken, err := app.generateAccessToken(app.config.Load(), user, validSession.SessionID)
 	if err != nil {
 		t.Fatalf("failed to generate valid access token: %v", err)
 	}
@@ -204,11 +362,18 @@ func TestValidateTokenHandler(t *testing.T) {
 		t.Fatalf("failed to generate expired access token: %v", err)
 	}
 
-	sessionExpiredToken, err := app.generateAccessToken(user.ID, user.Email, user.Username, expiredSession.SessionID)
+	sessionExpiredToken, err := app.generateAccessToken(app.config.Load(), user, expiredSession.SessionID)
 	if err != nil {
 		t.Fatalf("failed to generate session expired access token: %v", err)
 	}
 
+	stale := *user
+	stale.TokenVersion--
+	staleToken, err := app.generateAccessToken(app.config.Load(), &stale, validSession.SessionID)
+	if err != nil {
+		t.Fatalf("failed to generate stale access token: %v", err)
+	}
+
 	tests := []Test{
 		{
 			name:    "success - valid token",
@@ -219,22 +384,187 @@ func TestValidateTokenHandler(t *testing.T) {
 			name:    "fail - expired token",
 			payload: []byte(fmt.Sprintf(`{"access_token": "%s"}`, expiredToken)),
 			want:    http.StatusUnauthorized,
+			code:    data.CodeTokenExpired,
 		},
 		{
 			name:    "fail - invalid token",
 			payload: []byte(`{"access_token": "not a valid token"}`),
 			want:    http.StatusUnauthorized,
+			code:    data.CodeTokenInvalid,
 		},
 		{
 			name:    "fail - expired session",
 			payload: []byte(fmt.Sprintf(`{"access_token": "%s"}`, sessionExpiredToken)),
 			want:    http.StatusUnauthorized,
+			code:    data.CodeSessionExpired,
+		},
+		{
+			name:    "fail - stale token version",
+			payload: []byte(fmt.Sprintf(`{"access_token": "%s"}`, staleToken)),
+			want:    http.StatusUnauthorized,
+			code:    data.CodeTokenRevoked,
 		},
 		malformedJSON,
 		emptyJSON,
 	}
 
-	runTests(t, "auth.validate", tests)
+	runTests(t, "auth.v1.validate", tests)
+}
+
+func TestLogoutAllHandler(t *testing.T) {
+	testutils.ResetTestDB(t, dsn)
+
+	user := createTestUser(t)
+	hash := sha256.Sum256([]byte(generateOpaqueTokenForTest(t)))
+	session := createTestSession(t, user.ID, hash[:], time.Now().Add(24*time.Hour))
+	refreshToken := generateOpaqueTokenForTest(t)
+	hash = sha256.Sum256([]byte(refreshToken))
+	createTestSession(t, user.ID, hash[:], time.Now().Add(24*time.Hour))
+
+	token, err := app.generateAccessToken(app.config.Load(), user, session.SessionID)
+	if err != nil {
+		t.Fatalf("failed to generate access token: %v", err)
+	}
+
+	events, err := app.nc.SubscribeSync(data.SubjectTokensRevoked)
+	if err != nil {
+		t.Fatalf("failed to subscribe to %s: %v", data.SubjectTokensRevoked, err)
+	}
+	defer events.Unsubscribe()
+
+	runTests(t, "auth.v1.logout_all", []Test{
+		{
+			name:    "success - log out everywhere",
+			payload: []byte(fmt.Sprintf(`{"access_token": "%s"}`, token)),
+			want:    http.StatusOK,
+		},
+		{
+			name:    "fail - token issued before the logout",
+			payload: []byte(fmt.Sprintf(`{"access_token": "%s"}`, token)),
+			want:    http.StatusUnauthorized,
+			code:    data.CodeTokenRevoked,
+		},
+		malformedJSON,
+		emptyJSON,
+	})
+	runTests(t, "auth.v1.refresh", []Test{{
+		name:    "fail - other session was revoked",
+		payload: []byte(fmt.Sprintf(`{"refresh_token": "%s"}`, refreshToken)),
+		want:    http.StatusUnauthorized,
+		code:    data.CodeSessionRevoked,
+	}})
+
+	msg, err := events.NextMsg(2 * time.Second)
+	if err != nil {
+		t.Fatalf("expected a %s event: %v", data.SubjectTokensRevoked, err)
+	}
+	var event data.TokensRevokedEvent
+	if err := json.Unmarshal(msg.Data, &event); err != nil {
+		t.Fatalf("failed to unmarshal event: %v", err)
+	}
+	if event.UserID != user.ID || event.TokenVersion != user.TokenVersion+1 || event.Reason != data.RevokeReasonLogoutAll {
+		t.Errorf("got event %+v", event)
+	}
+}
+
+func TestSetRole(t *testing.T) {
+	testutils.ResetTestDB(t, dsn)
+
+	previous := app.config.Load()
+	t.Cleanup(func() { app.config.Store(previous) })
+
+	cfg := *previous
+	cfg.Admin.Token = adminToken
+	app.config.Store(&cfg)
+
+	user := createTestUser(t)
+	refreshToken := generateOpaqueTokenForTest(t)
+	hash := sha256.Sum256([]byte(refreshToken))
+	session := createTestSession(t, user.ID, hash[:], time.Now().Add(24*time.Hour))
+	token, err := app.generateAccessToken(&cfg, user, session.SessionID)
+	if err != nil {
+		t.Fatalf("failed to generate access token: %v", err)
+	}
+
+	events, err := app.nc.SubscribeSync(data.SubjectTokensRevoked)
+	if err != nil {
+		t.Fatalf("failed to subscribe to %s: %v", data.SubjectTokensRevoked, err)
+	}
+	defer events.Unsubscribe()
+
+	setRole := func(authorization, userID, role string) (data.Response, v1.SetRoleResponse) {
+		t.Helper()
+
+		msg := nats.NewMsg("auth.v1.set_role")
+		msg.Data = []byte(fmt.Sprintf(`{"user_id":%q,"role":%q}`, userID, role))
+		if authorization != "" {
+			msg.Header.Set("Authorization", authorization)
+		}
+		reply, err := app.nc.RequestMsg(msg, 2*time.Second)
+		if err != nil {
+			t.Fatalf("failed to get response from auth.v1.set_role: %v", err)
+		}
+
+		var r struct {
+			data.Response
+			Data v1.SetRoleResponse `json:"data"`
+		}
+		if err := json.Unmarshal(reply.Data, &r); err != nil {
+			t.Fatalf("failed to unmarshal set role response: %v", err)
+		}
+		return r.Response, r.Data
+	}
+
+	if r, _ := setRole("", user.ID, "admin"); r.StatusCode != http.StatusForbidden || r.Code != data.CodeForbidden {
+		t.Errorf("got %d %s without the admin token want %d %s", r.StatusCode, r.Code, http.StatusForbidden, data.CodeForbidden)
+	}
+	if r, _ := setRole("Bearer "+adminToken, uuid.NewString(), "admin"); r.StatusCode != http.StatusNotFound || r.Code != data.CodeUserNotFound {
+		t.Errorf("got %d %s for an unknown user want %d %s", r.StatusCode, r.Code, http.StatusNotFound, data.CodeUserNotFound)
+	}
+
+	r, result := setRole("Bearer "+adminToken, user.ID, "admin")
+	if r.StatusCode != http.StatusOK || !result.TokensRevoked {
+		t.Fatalf("got %d %s %+v want %d with tokens revoked", r.StatusCode, r.Code, result, http.StatusOK)
+	}
+
+	runTests(t, "auth.v1.validate", []Test{{
+		name:    "fail - token issued before the role change",
+		payload: []byte(fmt.Sprintf(`{"access_token": "%s"}`, token)),
+		want:    http.StatusUnauthorized,
+		code:    data.CodeTokenRevoked,
+	}})
+	runTests(t, "auth.v1.refresh", []Test{{
+		name:    "success - the session outlives the role change",
+		payload: []byte(fmt.Sprintf(`{"refresh_token": "%s"}`, refreshToken)),
+		want:    http.StatusOK,
+	}})
+
+	if _, result := setRole("Bearer "+adminToken, user.ID, "admin"); result.TokensRevoked {
+		t.Error("expected setting the same role to keep the tokens")
+	}
+
+	stored, err := app.models.UserModel.GetByID(t.Context(), user.ID)
+	if err != nil {
+		t.Fatalf("failed to get user: %v", err)
+	}
+	if stored.Role != "admin" || stored.TokenVersion != user.TokenVersion+1 {
+		t.Errorf("got role %q and token version %d want admin and %d", stored.Role, stored.TokenVersion, user.TokenVersion+1)
+	}
+
+	msg, err := events.NextMsg(2 * time.Second)
+	if err != nil {
+		t.Fatalf("expected a %s event: %v", data.SubjectTokensRevoked, err)
+	}
+	var event data.TokensRevokedEvent
+	if err := json.Unmarshal(msg.Data, &event); err != nil {
+		t.Fatalf("failed to unmarshal event: %v", err)
+	}
+	if event.UserID != user.ID || event.TokenVersion != user.TokenVersion+1 || event.Reason != data.RevokeReasonRoleChanged {
+		t.Errorf("got event %+v", event)
+	}
+	if msg, err := events.NextMsg(200 * time.Millisecond); err == nil {
+		t.Errorf("got %s when the role did not change", msg.Data)
+	}
 }
 
 func TestRefreshTokenHandler(t *testing.T) {
@@ -249,6 +579,13 @@ func TestRefreshTokenHandler(t *testing.T) {
 	hash = sha256.Sum256([]byte(sessionExpiredToken))
 	_ = createTestSession(t, user.ID, hash[:], time.Now().Add(-1*time.Hour))
 
+	sessionRevokedToken := generateOpaqueTokenForTest(t)
+	hash = sha256.Sum256([]byte(sessionRevokedToken))
+	revoked := createTestSession(t, user.ID, hash[:], time.Now().Add(24*time.Hour))
+	if err := app.models.SessionModel.Revoke(context.Background(), revoked.SessionID); err != nil {
+		t.Fatalf("failed to revoke session: %v", err)
+	}
+
 	tests := []Test{
 		{
 			name:    "success - valid refresh token",
@@ -259,20 +596,525 @@ func TestRefreshTokenHandler(t *testing.T) {
 			name:    "fail - session expired",
 			payload: []byte(fmt.Sprintf(`{"refresh_token": "%s"}`, sessionExpiredToken)),
 			want:    http.StatusUnauthorized,
+			code:    data.CodeSessionExpired,
+		},
+		{
+			name:    "fail - session revoked",
+			payload: []byte(fmt.Sprintf(`{"refresh_token": "%s"}`, sessionRevokedToken)),
+			want:    http.StatusUnauthorized,
+			code:    data.CodeSessionRevoked,
 		},
 		{
 			name:    "fail - invalid token",
 			payload: []byte(fmt.Sprintf(`{"refresh_token": "not a valid token"}`)),
 			want:    http.StatusUnauthorized,
+			code:    data.CodeTokenInvalid,
 		},
 		{
 			name:    "fail - invalid json",
 			payload: []byte(fmt.Sprintf(`{}`)),
 			want:    http.StatusUnprocessableEntity,
+			code:    data.CodeValidationFailed,
+		},
+		malformedJSON,
+		emptyJSON,
+	}
+
+	runTests(t, "auth.v1.refresh", tests)
+}
+
+func TestRefreshSlidesIdleExpiry(t *testing.T) {
+	testutils.ResetTestDB(t, dsn)
+
+	previous := app.config.Load()
+	t.Cleanup(func() { app.config.Store(previous) })
+
+	cfg := *previous
+	cfg.Session.TTL = time.Hour
+	app.config.Store(&cfg)
+
+	user := createTestUser(t)
+	token := generateOpaqueTokenForTest(t)
+	hash := sha256.Sum256([]byte(token))
+	session := &data.Session{
+		SessionID:         uuid.NewString(),
+		UserID:            user.ID,
+		TokenHash:         hash[:],
+		ExpiresAt:         time.Now().Add(time.Minute),
+		AbsoluteExpiresAt: time.Now().Add(30 * time.Minute),
+	}
+	if err := app.models.SessionModel.Insert(context.Background(), session); err != nil {
+		t.Fatalf("failed to insert session in db: %v", err)
+	}
+
+	msg, err := app.nc.Request("auth.v1.refresh", []byte(fmt.Sprintf(`{"refresh_token": "%s"}`, token)), 2*time.Second)
+	if err != nil {
+		t.Fatalf("failed to get refresh response: %v", err)
+	}
+	var r struct {
+		data.Response
+		Data v1.TokenRefreshResponse `json:"data"`
+	}
+	if err := json.Unmarshal(msg.Data, &r); err != nil {
+		t.Fatalf("failed to unmarshal refresh response: %v", err)
+	}
+	if r.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d want %d", r.StatusCode, http.StatusOK)
+	}
+
+	// The idle timeout would give the session another hour, but it ends
+	// at its absolute expiry.
+	if !r.Data.AbsoluteExpiresAt.Equal(session.AbsoluteExpiresAt) {
+		t.Errorf("got absolute expiry %v want %v", r.Data.AbsoluteExpiresAt, session.AbsoluteExpiresAt)
+	}
+	if !r.Data.ExpiresAt.Equal(r.Data.AbsoluteExpiresAt) {
+		t.Errorf("got idle expiry %v want it capped at %v", r.Data.ExpiresAt, r.Data.AbsoluteExpiresAt)
+	}
+}
+
+func TestChangePasswordHandler(t *testing.T) {
+	testutils.ResetTestDB(t, dsn)
+
+	user := createTestUser(t)
+	hash := sha256.Sum256([]byte(generateOpaqueTokenForTest(t)))
+	session := createTestSession(t, user.ID, hash[:], time.Now().Add(24*time.Hour))
+
+	token, err := app.generateAccessToken(app.config.Load(), user, session.SessionID)
+	if err != nil {
+		t.Fatalf("failed to generate access token: %v", err)
+	}
+
+	tests := []Test{
+		{
+			name:    "fail - wrong current password",
+			payload: []byte(fmt.Sprintf(`{"access_token": "%s", "current_password": "87654321", "new_password": "Xq7#vLm2!rTz"}`, token)),
+			want:    http.StatusUnauthorized,
+			code:    data.CodeInvalidCredentials,
+		},
+		{
+			name:    "fail - common password",
+			payload: []byte(fmt.Sprintf(`{"access_token": "%s", "current_password": "12345678", "new_password": "password123"}`, token)),
+			want:    http.StatusUnprocessableEntity,
+			code:    data.CodeValidationFailed,
+		},
+		{
+			name:    "fail - password resembles the username",
+			payload: []byte(fmt.Sprintf(`{"access_token": "%s", "current_password": "12345678", "new_password": "Tester-123"}`, token)),
+			want:    http.StatusUnprocessableEntity,
+			code:    data.CodeValidationFailed,
+		},
+		{
+			name:    "fail - invalid token",
+			payload: []byte(`{"access_token": "not a valid token", "current_password": "12345678", "new_password": "Xq7#vLm2!rTz"}`),
+			want:    http.StatusUnauthorized,
+			code:    data.CodeTokenInvalid,
+		},
+		{
+			name:    "success - password changed",
+			payload: []byte(fmt.Sprintf(`{"access_token": "%s", "current_password": "12345678", "new_password": "Xq7#vLm2!rTz"}`, token)),
+			want:    http.StatusOK,
+		},
+		{
+			name:    "fail - token issued before the change",
+			payload: []byte(fmt.Sprintf(`{"access_token": "%s", "current_password": "Xq7#vLm2!rTz", "new_password": "Bn4$kWp8@sYe"}`, token)),
+			want:    http.StatusUnauthorized,
+			code:    data.CodeTokenRevoked,
 		},
 		malformedJSON,
 		emptyJSON,
 	}
 
-	runTests(t, "auth.refresh", tests)
+	runTests(t, "auth.v1.change_password", tests)
+}
+
+func TestServiceDiscovery(t *testing.T) {
+	msg, err := app.nc.Request("$SRV.INFO.auth", nil, 2*time.Second)
+	if err != nil {
+		t.Fatalf("failed to get service info: %v", err)
+	}
+
+	var info micro.Info
+	if err := json.Unmarshal(msg.Data, &info); err != nil {
+		t.Fatalf("failed to unmarshal service info: %v", err)
+	}
+
+	subjects := make(map[string]micro.EndpointInfo)
+	for _, e := range info.Endpoints {
+		subjects[e.Subject] = e
+	}
+
+	for _, name := range legacyEndpoints {
+		for _, subj := range []string{"auth.v1." + name, "auth." + name} {
+			e, ok := subjects[subj]
+			if !ok {
+				t.Errorf("expected endpoint %s to be registered", subj)
+				continue
+			}
+			if e.QueueGroup != "auth_workers" {
+				t.Errorf("got queue group %q for %s want auth_workers", e.QueueGroup, subj)
+			}
+		}
+
+		legacy := subjects["auth."+name].Metadata
+		if legacy["deprecated"] != "true" || legacy["replaced_by"] != "auth.v1."+name {
+			t.Errorf("expected auth.%s to be a deprecated alias of auth.v1.%s, got %v", name, name, legacy)
+		}
+	}
+
+	for _, name := range []string{"change_password", "logout_all", "break_glass", "set_role"} {
+		if _, ok := subjects["auth.v1."+name]; !ok {
+			t.Errorf("expected endpoint auth.v1.%s to be registered", name)
+		}
+		if _, ok := subjects["auth."+name]; ok {
+			t.Errorf("expected no unversioned alias for auth.v1.%s", name)
+		}
+	}
+
+	if _, ok := subjects["auth.v1.login"].Metadata["request_schema"]; !ok {
+		t.Error("expected auth.v1.login to advertise its request schema")
+	}
+}
+
+func TestAPIVersionHeader(t *testing.T) {
+	tests := []struct {
+		name    string
+		subject string
+		version string
+		want    int
+	}{
+		{"versioned subject", "auth.v1.login", "", http.StatusUnprocessableEntity},
+		{"legacy subject defaults to v1", "auth.login", "", http.StatusUnprocessableEntity},
+		{"legacy subject with numeric version", "auth.login", "1", http.StatusUnprocessableEntity},
+		{"legacy subject with v1", "auth.login", "v1", http.StatusUnprocessableEntity},
+		{"legacy subject with unknown version", "auth.login", "v2", http.StatusBadRequest},
+	}
+
+	for _, ts := range tests {
+		t.Run(ts.name, func(t *testing.T) {
+			msg := nats.NewMsg(ts.subject)
+			msg.Data = []byte(`{}`)
+			if ts.version != "" {
+				msg.Header.Set(versionHeader, ts.version)
+			}
+
+			reply, err := app.nc.RequestMsg(msg, 2*time.Second)
+			if err != nil {
+				t.Fatalf("failed to get response from %s: %v", ts.subject, err)
+			}
+
+			var r data.Response
+			if err := json.Unmarshal(reply.Data, &r); err != nil {
+				t.Fatalf("failed to unmarshal %s response: %v", ts.subject, err)
+			}
+			if r.StatusCode != ts.want {
+				t.Errorf("got %d want %d, %s %s", r.StatusCode, ts.want, r.Code, r.Message)
+			}
+			if ts.want != http.StatusBadRequest && reply.Header.Get(versionHeader) != "v1" {
+				t.Errorf("got %s header %q want v1", versionHeader, reply.Header.Get(versionHeader))
+			}
+		})
+	}
+}
+
+// TestLegacyAliases goes through a whole session on the unversioned
+// subjects, which must keep answering with the v1 payloads.
+func TestLegacyAliases(t *testing.T) {
+	testutils.ResetTestDB(t, dsn)
+
+	// request sends payload to subject and decodes the data of the reply
+	// into out. A want of 0 accepts any status.
+	request := func(subject, payload string, want int, out any) {
+		t.Helper()
+		reply, err := app.nc.Request(subject, []byte(payload), 2*time.Second)
+		if err != nil {
+			t.Fatalf("failed to get response from %s: %v", subject, err)
+		}
+		r := data.Response{Data: out}
+		if err := json.Unmarshal(reply.Data, &r); err != nil {
+			t.Fatalf("failed to unmarshal %s response: %v", subject, err)
+		}
+		if want != 0 && r.StatusCode != want {
+			t.Fatalf("%s: got %d want %d, %s %s", subject, r.StatusCode, want, r.Code, r.Message)
+		}
+		if reply.Header.Get(versionHeader) != "v1" {
+			t.Errorf("%s: got %s header %q want v1", subject, versionHeader, reply.Header.Get(versionHeader))
+		}
+	}
+
+	// Readiness depends on more than this test controls.
+	var health data.HealthResponse
+	request("auth.healthcheck", `{}`, 0, &health)
+	if health.Status == "" {
+		t.Error("expected auth.healthcheck to report a status")
+	}
+
+	request("auth.register", `{"email":"test@mail.com","password":"Xq7#vLm2!rTz","username":"tester"}`, http.StatusCreated, nil)
+
+	var login v1.LoginResponse
+	request("auth.login", `{"email":"test@mail.com","password":"Xq7#vLm2!rTz"}`, http.StatusOK, &login)
+	if login.AccessToken == "" || login.RefreshToken == "" || login.CurrentSession.SessionID == "" {
+		t.Fatalf("got login response %+v", login)
+	}
+
+	var validation v1.TokenValidationResponse
+	request("auth.validate", fmt.Sprintf(`{"access_token":%q}`, login.AccessToken), http.StatusOK, &validation)
+	if validation.Email != "test@mail.com" {
+		t.Errorf("got validation response %+v", validation)
+	}
+
+	var refresh v1.TokenRefreshResponse
+	request("auth.refresh", fmt.Sprintf(`{"refresh_token":%q}`, login.RefreshToken), http.StatusOK, &refresh)
+	if refresh.AccessToken == "" {
+		t.Errorf("got refresh response %+v", refresh)
+	}
+
+	request("auth.logout", fmt.Sprintf(`{"session_id":%q}`, login.CurrentSession.SessionID), http.StatusOK, nil)
+	request("auth.refresh", fmt.Sprintf(`{"refresh_token":%q}`, login.RefreshToken), http.StatusUnauthorized, nil)
+}
+
+func TestLocalizedResponses(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   string
+		payload  string
+		locale   string
+		message  string
+		password string
+	}{
+		{"default locale", "", `{}`, "en", "request failed validation", "must be provided"},
+		{"header", "es-MX,es;q=0.9", `{}`, "es", "la solicitud no superó la validación", "es obligatorio"},
+		{"input field wins over header", "en", `{"locale":"es"}`, "es", "la solicitud no superó la validación", "es obligatorio"},
+		{"unsupported language falls back", "fr", `{"password":"short"}`, "en", "request failed validation", "must be at least 8 bytes long"},
+		{"malformed json", "es", `not json`, "es", "el cuerpo de la solicitud no es JSON válido", ""},
+	}
+
+	for _, ts := range tests {
+		t.Run(ts.name, func(t *testing.T) {
+			msg := nats.NewMsg("auth.v1.login")
+			msg.Data = []byte(ts.payload)
+			if ts.header != "" {
+				msg.Header.Set(localeHeader, ts.header)
+			}
+
+			reply, err := app.nc.RequestMsg(msg, 2*time.Second)
+			if err != nil {
+				t.Fatalf("failed to get response from auth.v1.login: %v", err)
+			}
+
+			var r data.Response
+			if err := json.Unmarshal(reply.Data, &r); err != nil {
+				t.Fatalf("failed to unmarshal auth.v1.login response: %v", err)
+			}
+			if got := reply.Header.Get("Content-Language"); got != ts.locale {
+				t.Errorf("got Content-Language %q want %q", got, ts.locale)
+			}
+			if r.Message != ts.message {
+				t.Errorf("got message %q want %q", r.Message, ts.message)
+			}
+			if r.Details["/password"] != ts.password {
+				t.Errorf("got password detail %q want %q", r.Details["/password"], ts.password)
+			}
+		})
+	}
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	testutils.ResetTestDB(t, dsn)
+
+	register := []byte(`{"email":"idem@mail.com","password":"Xq7#vLm2!rTz","username":"tester"}`)
+	other := []byte(`{"email":"other@mail.com","password":"Xq7#vLm2!rTz","username":"tester"}`)
+
+	tests := []struct {
+		name          string
+		key           string
+		authorization string
+		payload       []byte
+		want          int
+		code          string
+		replayed      bool
+	}{
+		{"first request", "key-1", "", register, http.StatusCreated, "", false},
+		{"retry is replayed", "key-1", "", register, http.StatusCreated, "", true},
+		{"key reused for another payload", "key-1", "", other, http.StatusUnprocessableEntity, data.CodeIdempotencyKeyReused, false},
+		{"key reused by another caller", "key-1", "Bearer someone-else", register, http.StatusUnprocessableEntity, data.CodeIdempotencyKeyReused, false},
+		{"retry without a key runs again", "", "", register, http.StatusConflict, data.CodeEmailInUse, false},
+		{"error responses are replayed too", "key-2", "", register, http.StatusConflict, data.CodeEmailInUse, false},
+		{"replayed error", "key-2", "", register, http.StatusConflict, data.CodeEmailInUse, true},
+		{"key too long", strings.Repeat("k", 256), "", register, http.StatusBadRequest, data.CodeIdempotencyKeyInvalid, false},
+	}
+
+	for _, ts := range tests {
+		t.Run(ts.name, func(t *testing.T) {
+			msg := nats.NewMsg("auth.v1.register")
+			msg.Data = ts.payload
+			if ts.key != "" {
+				msg.Header.Set(idempotencyKeyHeader, ts.key)
+			}
+			if ts.authorization != "" {
+				msg.Header.Set("Authorization", ts.authorization)
+			}
+
+			reply, err := app.nc.RequestMsg(msg, 2*time.Second)
+			if err != nil {
+				t.Fatalf("failed to get response from auth.v1.register: %v", err)
+			}
+
+			var r data.Response
+			if err := json.Unmarshal(reply.Data, &r); err != nil {
+				t.Fatalf("failed to unmarshal auth.v1.register response: %v", err)
+			}
+			if r.StatusCode != ts.want || r.Code != ts.code {
+				t.Errorf("got %d %q want %d %q", r.StatusCode, r.Code, ts.want, ts.code)
+			}
+			if replayed := reply.Header.Get(replayedHeader) == "true"; replayed != ts.replayed {
+				t.Errorf("got replayed %t want %t", replayed, ts.replayed)
+			}
+		})
+	}
+}
+
+func TestIdempotencyClaimLease(t *testing.T) {
+	testutils.ResetTestDB(t, dsn)
+
+	ctx := t.Context()
+	models := app.models.IdempotencyModel
+	newRecord := func(hash string) *data.IdempotencyRecord {
+		return &data.IdempotencyRecord{Subject: "auth.v1.register", Key: "lease-1", RequestHash: []byte(hash)}
+	}
+
+	first := newRecord("a")
+	if claimed, err := models.Claim(ctx, first, time.Hour, time.Hour); err != nil || !claimed {
+		t.Fatalf("got %v, %v for a new key want claimed", claimed, err)
+	}
+	if got := first.ExpiresAt.Sub(first.ClaimedAt); got != time.Hour {
+		t.Errorf("got a replay window of %s want %s counted by the database", got, time.Hour)
+	}
+	if claimed, err := models.Claim(ctx, newRecord("a"), time.Hour, time.Hour); err != nil || claimed {
+		t.Errorf("got %v, %v within the lease want not claimed", claimed, err)
+	}
+	if claimed, err := models.Claim(ctx, newRecord("b"), time.Hour, 0); err != nil || claimed {
+		t.Errorf("got %v, %v for another payload after the lease want not claimed", claimed, err)
+	}
+
+	// The first request crashed; a retry takes its claim over once the
+	// lease has run out.
+	retry := newRecord("a")
+	if claimed, err := models.Claim(ctx, retry, time.Hour, 0); err != nil || !claimed {
+		t.Fatalf("got %v, %v after the lease want claimed", claimed, err)
+	}
+	if !retry.ExpiresAt.Equal(first.ExpiresAt) {
+		t.Errorf("got expiry %s want the first request's %s", retry.ExpiresAt, first.ExpiresAt)
+	}
+
+	// The first request can no longer store or release anything.
+	first.Response = []byte("stale")
+	if err := models.Complete(ctx, first); err != nil {
+		t.Fatalf("failed to complete: %v", err)
+	}
+	if err := models.Release(ctx, first); err != nil {
+		t.Fatalf("failed to release: %v", err)
+	}
+	stored, err := models.Get(ctx, retry.Subject, retry.Key)
+	if err != nil {
+		t.Fatalf("failed to get record: %v", err)
+	}
+	if stored.Response != nil || !stored.ClaimedAt.Equal(retry.ClaimedAt) {
+		t.Errorf("got record %+v want the retry's claim without a response", stored)
+	}
+}
+
+func TestIdempotentLoginIsStoredEncrypted(t *testing.T) {
+	testutils.ResetTestDB(t, dsn)
+	_ = createTestUser(t)
+
+	login := func() v1.LoginResponse {
+		t.Helper()
+		msg := nats.NewMsg("auth.v1.login")
+		msg.Data = []byte(`{"email":"test@mail.com","password":"12345678"}`)
+		msg.Header.Set(idempotencyKeyHeader, "login-1")
+		reply, err := app.nc.RequestMsg(msg, 2*time.Second)
+		if err != nil {
+			t.Fatalf("failed to get login response: %v", err)
+		}
+		var r struct {
+			data.Response
+			Data v1.LoginResponse `json:"data"`
+		}
+		if err := json.Unmarshal(reply.Data, &r); err != nil {
+			t.Fatalf("failed to unmarshal login response: %v", err)
+		}
+		if r.StatusCode != http.StatusOK {
+			t.Fatalf("got %d want %d, %s %s", r.StatusCode, http.StatusOK, r.Code, r.Message)
+		}
+		return r.Data
+	}
+
+	first := login()
+	if replayed := login(); replayed.RefreshToken != first.RefreshToken {
+		t.Errorf("got refresh token %q on retry want %q", replayed.RefreshToken, first.RefreshToken)
+	}
+
+	var stored []byte
+	err := app.db.QueryRow(`SELECT response FROM idempotency_keys WHERE key = 'login-1'`).Scan(&stored)
+	if err != nil {
+		t.Fatalf("failed to read stored response: %v", err)
+	}
+	if bytes.Contains(stored, []byte(first.RefreshToken)) || bytes.Contains(stored, []byte(first.AccessToken)) {
+		t.Error("expected the stored response not to hold the tokens in plaintext")
+	}
+}
+
+func TestIdempotentResponseAfterDeadline(t *testing.T) {
+	testutils.ResetTestDB(t, dsn)
+
+	runs := 0
+	handler := app.idempotent(func(ctx context.Context, req micro.Request) {
+		runs++
+		// The work outlives the caller's deadline.
+		<-ctx.Done()
+		app.sendSuccessResponse(ctx, req, http.StatusCreated, "done")
+	})
+
+	newRequest := func() *fakeRequest {
+		return &fakeRequest{
+			subject: "auth.v1.slow",
+			data:    []byte(`{}`),
+			headers: micro.Headers{idempotencyKeyHeader: []string{"slow-1"}},
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	first := newRequest()
+	handler(ctx, first)
+	if first.response != nil {
+		t.Fatalf("got %s after the deadline want no response", first.response)
+	}
+
+	retry := newRequest()
+	handler(context.Background(), retry)
+	if runs != 1 {
+		t.Errorf("got %d runs want the retry to be replayed", runs)
+	}
+	var r data.Response
+	if err := json.Unmarshal(retry.response, &r); err != nil {
+		t.Fatalf("failed to unmarshal replayed response %q: %v", retry.response, err)
+	}
+	if r.StatusCode != http.StatusCreated || retry.header.Get(replayedHeader) != "true" {
+		t.Errorf("got %d replayed=%q want %d replayed", r.StatusCode, retry.header.Get(replayedHeader), http.StatusCreated)
+	}
+}
+
+func TestSealResponse(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	sealed, err := seal(key, []byte(`{"status":200}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := unseal(key, sealed); err != nil || string(got) != `{"status":200}` {
+		t.Errorf("got %q, %v", got, err)
+	}
+	if _, err := unseal(bytes.Repeat([]byte{2}, 32), sealed); err == nil {
+		t.Error("expected another key not to decrypt the response")
+	}
 }
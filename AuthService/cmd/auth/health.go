@@ -0,0 +1,160 @@
+package main
+
+import (
+	"auth/internal/data"
+	"auth/migrations"
+	"context"
+	"encoding/json"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// version is set at build time with -ldflags "-X main.version=...". It is
+// advertised through the micro framework, which requires a SemVer string.
+var version = "0.0.0-dev"
+
+const healthCheckTimeout = 2 * time.Second
+
+const (
+	statusUp        = "up"
+	statusDown      = "down"
+	statusMigrating = "migrating"
+	statusDirty     = "dirty"
+	statusDisabled  = "disabled"
+
+	healthOK          = "ok"
+	healthDegraded    = "degraded"
+	healthUnavailable = "unavailable"
+)
+
+// checkHealth probes every dependency. The service is ready when the
+// database is reachable and fully migrated and NATS is connected; JetStream
+// problems only degrade it, since requests do not depend on it.
+func (app *application) checkHealth(ctx context.Context) (data.HealthResponse, bool) {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	report := data.HealthResponse{
+		Database:  app.checkDatabase(ctx),
+		Migration: app.checkMigration(ctx),
+		NATS:      app.checkNATS(),
+		JetStream: app.checkJetStream(ctx),
+		Build:     buildInfo(),
+	}
+
+	ready := report.Database.Status == statusUp &&
+		report.Migration.Status == statusUp &&
+		report.NATS.Status == statusUp
+
+	switch {
+	case !ready:
+		report.Status = healthUnavailable
+	case report.JetStream.Status == statusDown:
+		report.Status = healthDegraded
+	default:
+		report.Status = healthOK
+	}
+
+	return report, ready
+}
+
+func (app *application) checkDatabase(ctx context.Context) data.DatabaseHealth {
+	start := time.Now()
+	err := app.db.PingContext(ctx)
+	health := data.DatabaseHealth{
+		Status:    statusUp,
+		LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		health.Status = statusDown
+		health.Error = err.Error()
+	}
+	return health
+}
+
+func (app *application) checkMigration(ctx context.Context) data.MigrationHealth {
+	if app.migrating.Load() {
+		return data.MigrationHealth{Status: statusMigrating}
+	}
+
+	v, dirty, err := migrations.Version(ctx, app.db)
+	health := data.MigrationHealth{
+		Status:  statusUp,
+		Version: v,
+		Dirty:   dirty,
+	}
+	switch {
+	case err != nil:
+		health.Status = statusDown
+		health.Error = err.Error()
+	case dirty:
+		health.Status = statusDirty
+	}
+	return health
+}
+
+func (app *application) checkNATS() data.NATSHealth {
+	health := data.NATSHealth{
+		Status:     statusDown,
+		Reconnects: app.nc.Stats().Reconnects,
+	}
+	if app.nc.Status() == nats.CONNECTED {
+		health.Status = statusUp
+		health.Server = app.nc.ConnectedUrlRedacted()
+	}
+	return health
+}
+
+func (app *application) checkJetStream(ctx context.Context) data.JetStreamHealth {
+	if app.js == nil {
+		return data.JetStreamHealth{Status: statusDisabled}
+	}
+
+	info, err := app.js.StreamInfo(eventsStream, nats.Context(ctx))
+	if err != nil {
+		return data.JetStreamHealth{Status: statusDown, Error: err.Error()}
+	}
+	return data.JetStreamHealth{Status: statusUp, OutboxLag: info.State.Msgs}
+}
+
+func buildInfo() data.BuildInfo {
+	info := data.BuildInfo{Version: version}
+
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	info.GoVersion = bi.GoVersion
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			info.Revision = s.Value
+		case "vcs.time":
+			info.BuildTime = s.Value
+		}
+	}
+	return info
+}
+
+func (app *application) livenessHandler(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "alive"})
+}
+
+func (app *application) readinessHandler(w http.ResponseWriter, r *http.Request) {
+	report, ready := app.checkHealth(r.Context())
+
+	status := http.StatusOK
+	if !ready {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, report)
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -1,77 +1,222 @@
 package main
 
 import (
+	"auth/internal/config"
 	"auth/internal/data"
 	"auth/internal/validator"
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strconv"
+	"time"
 
-	"github.com/nats-io/nats.go"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/nats-io/nats.go/micro"
 )
 
-func (app *application) readJSON(msg *nats.Msg, dst any, f func(v *validator.Validator)) bool {
-	err := json.Unmarshal(msg.Data, dst)
-	if err != nil {
-		app.sendUnprocessableEntityResponse(msg)
-		return false
+// authenticate checks that token is a valid access token of a live
+// session and returns its claims. Otherwise an error response is sent and
+// authenticate returns false.
+func (app *application) authenticate(ctx context.Context, req micro.Request, cfg *config.Config, token string) (*AccessToken, bool) {
+	claims, ok := app.verifyAccessToken(ctx, req, cfg, token)
+	if !ok {
+		return nil, false
+	}
+	if claims.Scope == scopePasswordChange {
+		app.sendErrorResponse(ctx, req, http.StatusForbidden, data.CodePasswordExpired)
+		return nil, false
+	}
+	if !app.checkTokenVersion(ctx, req, claims) || !app.checkSession(ctx, req, claims) {
+		return nil, false
 	}
+	return claims, true
+}
 
-	v := validator.New()
-	if f(v); !v.Valid() {
-		app.sendErrorResponse(msg, http.StatusUnprocessableEntity, v.Errors)
-		return false
+// authenticatePasswordChange is authenticate for change_password, which
+// also accepts the restricted token login hands out for an expired
+// password.
+func (app *application) authenticatePasswordChange(ctx context.Context, req micro.Request, cfg *config.Config, token string) (*AccessToken, bool) {
+	claims, ok := app.verifyAccessToken(ctx, req, cfg, token)
+	if !ok {
+		return nil, false
 	}
-	return true
+	if !app.checkTokenVersion(ctx, req, claims) {
+		return nil, false
+	}
+	if claims.Scope != scopePasswordChange && !app.checkSession(ctx, req, claims) {
+		return nil, false
+	}
+	return claims, true
 }
 
-func (app *application) sendErrorResponse(msg *nats.Msg, status int, message any) {
-	response := &data.Response{
-		StatusCode: status,
-		Data:       message,
+// verifyAccessToken checks the signature and lifetime of token and returns
+// its claims, or sends an error response and returns false.
+func (app *application) verifyAccessToken(ctx context.Context, req micro.Request, cfg *config.Config, token string) (*AccessToken, bool) {
+	claims, err := app.validateAccessToken(cfg, token)
+	if err != nil {
+		switch {
+		case errors.Is(err, jwt.ErrTokenMalformed), errors.Is(err, jwt.ErrTokenNotValidYet):
+			app.sendErrorResponse(ctx, req, http.StatusUnauthorized, data.CodeTokenInvalid)
+		case errors.Is(err, jwt.ErrTokenExpired):
+			app.sendErrorResponse(ctx, req, http.StatusUnauthorized, data.CodeTokenExpired)
+		case errors.Is(err, errTokenRevoked):
+			app.sendErrorResponse(ctx, req, http.StatusUnauthorized, data.CodeTokenRevoked)
+		case errors.Is(err, jwt.ErrTokenUnverifiable), errors.Is(err, jwt.ErrTokenSignatureInvalid):
+			// Signed with a key that is no longer known, such as a dropped
+			// previous secret, or not signed by us at all.
+			app.sendErrorResponse(ctx, req, http.StatusUnauthorized, data.CodeTokenInvalid)
+		default:
+			app.sendInternalServerErrorResponse(ctx, req)
+		}
+		return nil, false
 	}
+	return claims, true
+}
 
-	responseData, err := json.Marshal(response)
+// checkTokenVersion checks that claims carry the current token version of
+// their user, or sends an error response and returns false.
+func (app *application) checkTokenVersion(ctx context.Context, req micro.Request, claims *AccessToken) bool {
+	version, err := app.models.UserModel.GetTokenVersion(ctx, claims.UserID)
 	if err != nil {
-		app.logger.Error("failed to marshal error response", "error", err, "original", message)
+		if errors.Is(err, data.ErrNoRecord) {
+			app.sendErrorResponse(ctx, req, http.StatusUnauthorized, data.CodeTokenInvalid)
+			return false
+		}
+		app.sendInternalServerErrorResponse(ctx, req)
+		return false
+	}
+	if claims.TokenVersion != version {
+		app.sendErrorResponse(ctx, req, http.StatusUnauthorized, data.CodeTokenRevoked)
+		return false
+	}
+	return true
+}
 
-		err := msg.Respond([]byte(`{"status":500,"error":"internal server error"}`))
-		if err != nil {
-			app.logger.Error("failed to send fallback response", "error", err)
-			return
+// checkSession checks that the session claims were issued for is still
+// live, or sends an error response saying why it is not and returns false.
+func (app *application) checkSession(ctx context.Context, req micro.Request, claims *AccessToken) bool {
+	session, err := app.models.SessionModel.GetByID(ctx, claims.SessionID)
+	if err != nil {
+		if errors.Is(err, data.ErrNoRecord) {
+			app.sendErrorResponse(ctx, req, http.StatusUnauthorized, data.CodeSessionNotFound)
+			return false
 		}
+		app.sendInternalServerErrorResponse(ctx, req)
+		return false
 	}
 
-	err = msg.Respond(responseData)
+	switch {
+	case session.RevokedAt != nil, app.revokedByBreakGlass(session):
+		app.sendErrorResponse(ctx, req, http.StatusUnauthorized, data.CodeSessionRevoked)
+		return false
+	case time.Now().After(session.ExpiresAt):
+		app.sendErrorResponse(ctx, req, http.StatusUnauthorized, data.CodeSessionExpired)
+		return false
+	}
+	return true
+}
+
+// readJSON decodes the request payload into dst and checks it against the
+// rules in the validate tags of dst, replying with an error when either
+// fails.
+func (app *application) readJSON(ctx context.Context, req micro.Request, dst any) bool {
+	err := json.Unmarshal(req.Data(), dst)
 	if err != nil {
-		app.logger.Error("failed to send error response", "error", err)
+		app.sendUnprocessableEntityResponse(ctx, req)
+		return false
 	}
+
+	v := validator.New()
+	if v.Struct(dst); !v.Valid() {
+		app.sendFailedValidationResponse(ctx, req, v.Errors)
+		return false
+	}
+	return true
+}
+
+func (app *application) sendErrorResponse(ctx context.Context, req micro.Request, status int, code string) {
+	app.respond(ctx, req, data.Response{
+		StatusCode: status,
+		Code:       code,
+	})
 }
 
-func (app *application) sendInternalServerErrorResponse(msg *nats.Msg) {
-	app.sendErrorResponse(msg, http.StatusInternalServerError, "internal server error")
+func (app *application) sendInternalServerErrorResponse(ctx context.Context, req micro.Request) {
+	app.sendErrorResponse(ctx, req, http.StatusInternalServerError, data.CodeInternal)
 }
 
-func (app *application) sendUnprocessableEntityResponse(msg *nats.Msg) {
-	app.sendErrorResponse(msg, http.StatusUnprocessableEntity, "unprocessable entity")
+func (app *application) sendUnprocessableEntityResponse(ctx context.Context, req micro.Request) {
+	app.sendErrorResponse(ctx, req, http.StatusUnprocessableEntity, data.CodeMalformedRequest)
 }
 
-func (app *application) sendSuccessResponse(msg *nats.Msg, status int, body any) {
-	response := &data.Response{
+func (app *application) sendFailedValidationResponse(ctx context.Context, req micro.Request, errors map[string]validator.Error) {
+	messages := localizerFromContext(ctx)
+
+	details := make(map[string]string, len(errors))
+	for field, e := range errors {
+		details[field] = messages.T("validation."+e.Rule, e.Args...)
+	}
+
+	app.respond(ctx, req, data.Response{
+		StatusCode: http.StatusUnprocessableEntity,
+		Code:       data.CodeValidationFailed,
+		Details:    details,
+	})
+}
+
+func (app *application) sendSuccessResponse(ctx context.Context, req micro.Request, status int, body any) {
+	app.respond(ctx, req, data.Response{
 		StatusCode: status,
 		Data:       body,
+	})
+}
+
+// respond sends response as the reply to req. The message of an error
+// response is looked up from its code in the language of the request.
+// Nothing is sent once the caller's deadline has passed, but the response
+// is still counted and, for an idempotent request, recorded, so that a
+// retry replays it instead of running the request again.
+func (app *application) respond(ctx context.Context, req micro.Request, response data.Response) {
+	messages := localizerFromContext(ctx)
+	if response.Code != "" && response.Message == "" {
+		response.Message = messages.T(response.Code)
 	}
 
-	responseData, err := json.Marshal(response)
+	status := response.StatusCode
+	app.metrics.requests.WithLabelValues(req.Subject(), strconv.Itoa(status)).Inc()
+	recordResponseStatus(ctx, status)
+
+	responseData, err := json.Marshal(&response)
 	if err != nil {
-		err := msg.Respond([]byte(`{"status":500,"error":"internal server error"}`))
-		if err != nil {
-			app.logger.Error("failed to send fallback response", "error", err)
-			return
+		app.logger.Error("failed to marshal response", "error", err, "original", response.Data)
+		status = http.StatusInternalServerError
+		response = data.Response{StatusCode: status, Code: data.CodeInternal, Message: messages.T(data.CodeInternal)}
+		responseData, _ = json.Marshal(&response)
+	}
+
+	header := traceHeaders(ctx)
+	header.Set("Content-Language", messages.Locale())
+	if version := apiVersionFromContext(ctx); version != "" {
+		header.Set(versionHeader, version)
+	}
+	headers := micro.WithHeaders(micro.Headers(header))
+
+	if app.expired(ctx, req) {
+		if rec, ok := req.(*recordingRequest); ok {
+			rec.capture(status, response.Code, responseData)
 		}
+		return
 	}
 
-	err = msg.Respond(responseData)
+	// Errors go through req.Error so that the micro framework counts them
+	// in the endpoint stats. The body is the same envelope either way.
+	if status >= http.StatusBadRequest {
+		err = req.Error(strconv.Itoa(status), response.Code, responseData, headers)
+	} else {
+		err = req.Respond(responseData, headers)
+	}
 	if err != nil {
-		app.logger.Error("failed to send success response", "error", err)
+		app.logger.Error("failed to send response", "error", err)
 	}
 }
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+// listenHTTP binds the operational HTTP server and serves it in the
+// background until shutdown. It does nothing when no address is configured.
+func (app *application) listenHTTP() error {
+	addr := app.config.Load().HTTP.Addr
+	if addr == "" {
+		return nil
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	srv := &http.Server{
+		Handler:           app.httpRoutes(),
+		ReadHeaderTimeout: 5 * time.Second,
+		ErrorLog:          slog.NewLogLogger(app.logger.Handler(), slog.LevelError),
+	}
+
+	app.background(func(ctx context.Context) {
+		serveErr := make(chan error, 1)
+		go func() {
+			serveErr <- srv.Serve(ln)
+		}()
+
+		select {
+		case err := <-serveErr:
+			app.logger.Error("http server stopped", slog.Any("err", err.Error()))
+		case <-ctx.Done():
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			if err := srv.Shutdown(shutdownCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				app.logger.Error("failed to shut down http server", slog.Any("err", err.Error()))
+			}
+		}
+	})
+
+	app.logger.Info("http server listening", slog.String("addr", ln.Addr().String()))
+	return nil
+}
+
+func (app *application) httpRoutes() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", app.metrics.handler())
+	mux.HandleFunc("GET /livez", app.livenessHandler)
+	mux.HandleFunc("GET /readyz", app.readinessHandler)
+	return mux
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsEndpoint(t *testing.T) {
+	if _, err := app.nc.Request("auth.v1.healthcheck", nil, 2*time.Second); err != nil {
+		t.Fatalf("failed to get response from auth.v1.healthcheck: %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+	app.httpRoutes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got %d want %d", rr.Code, http.StatusOK)
+	}
+
+	body, _ := io.ReadAll(rr.Body)
+	for _, want := range []string{
+		`auth_requests_total{status="200",subject="auth.v1.healthcheck"}`,
+		`auth_request_duration_seconds_count{subject="auth.v1.healthcheck"}`,
+		`auth_nats_connected 1`,
+		`go_sql_open_connections{db_name="auth"}`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("expected metrics to contain %s", want)
+		}
+	}
+}
+
+func TestHealthEndpoints(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+		want int
+	}{
+		{name: "liveness", path: "/livez", want: http.StatusOK},
+		{name: "readiness", path: "/readyz", want: http.StatusOK},
+	}
+
+	for _, ts := range tests {
+		t.Run(ts.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			app.httpRoutes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, ts.path, nil))
+
+			if rr.Code != ts.want {
+				t.Errorf("got %d want %d: %s", rr.Code, ts.want, rr.Body.String())
+			}
+		})
+	}
+
+	t.Run("not ready while migrating", func(t *testing.T) {
+		app.migrating.Store(true)
+		defer app.migrating.Store(false)
+
+		rr := httptest.NewRecorder()
+		app.httpRoutes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+		if rr.Code != http.StatusServiceUnavailable {
+			t.Errorf("got %d want %d", rr.Code, http.StatusServiceUnavailable)
+		}
+	})
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"auth/internal/config"
+	"auth/internal/data"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/nats-io/nats.go/micro"
+)
+
+// idempotencyKeyHeader lets a caller retry a mutating request safely: every
+// request sent with the same key on the same subject within the configured
+// window gets the response of the first one.
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// replayedHeader marks a response that was replayed from an earlier request.
+const replayedHeader = "Idempotent-Replayed"
+
+const (
+	maxIdempotencyKeyLength  = 255
+	idempotencyPruneInterval = time.Hour
+)
+
+// recordingRequest captures the response a handler sends so that it can be
+// stored for replay.
+type recordingRequest struct {
+	micro.Request
+	record *data.IdempotencyRecord
+	status int
+}
+
+func (r *recordingRequest) Respond(response []byte, opts ...micro.RespondOpt) error {
+	r.capture(http.StatusOK, "", response)
+	return r.Request.Respond(response, opts...)
+}
+
+func (r *recordingRequest) Error(code, description string, response []byte, opts ...micro.RespondOpt) error {
+	status, _ := strconv.Atoi(code)
+	r.capture(status, description, response)
+	return r.Request.Error(code, description, response, opts...)
+}
+
+// capture records response, with status and, for an error, its code, the
+// way respond sends it. respond calls it directly when the caller's
+// deadline has passed and nothing is sent.
+func (r *recordingRequest) capture(status int, code string, response []byte) {
+	r.record.Response = response
+	r.status = status
+	if status >= http.StatusBadRequest {
+		r.record.ErrorCode = strconv.Itoa(status)
+		r.record.ErrorDescription = code
+	}
+}
+
+// idempotent makes handler replay its first response to requests carrying
+// an idempotency key it has already seen. Reusing a key with a different
+// payload or from a different caller is rejected, as is a retry that
+// arrives while the first request is still being handled. Server errors are
+// not stored, so the request can be retried with the same key.
+//
+// Responses such as those of login and refresh carry credentials, so they
+// are stored encrypted with a key only the same request can derive; see
+// requestKeys.
+func (app *application) idempotent(handler func(context.Context, micro.Request)) func(context.Context, micro.Request) {
+	return func(ctx context.Context, req micro.Request) {
+		key := req.Headers().Get(idempotencyKeyHeader)
+		if key == "" {
+			handler(ctx, req)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			app.sendErrorResponse(ctx, req, http.StatusBadRequest, data.CodeIdempotencyKeyInvalid)
+			return
+		}
+
+		cfg := app.config.Load()
+		hash, responseKey := requestKeys(ctx, cfg.JWT.AccessSecret, req)
+		record := &data.IdempotencyRecord{Subject: req.Subject(), Key: key, RequestHash: hash}
+		claimed, err := app.models.IdempotencyModel.Claim(ctx, record, cfg.Idempotency.TTL, idempotencyLease(cfg))
+		if err != nil {
+			app.logger.Error("failed to claim idempotency key", slog.Any("err", err.Error()))
+			app.sendInternalServerErrorResponse(ctx, req)
+			return
+		}
+
+		if !claimed {
+			app.replay(ctx, req, key, hash, responseKey)
+			return
+		}
+
+		rec := &recordingRequest{Request: req, record: record}
+		handler(ctx, rec)
+
+		// The outcome is stored even if the request's deadline has passed,
+		// so a retry does not run the request a second time.
+		ctx = context.WithoutCancel(ctx)
+		if rec.status == 0 || rec.status >= http.StatusInternalServerError {
+			err = app.models.IdempotencyModel.Release(ctx, record)
+		} else {
+			rec.record.Response, err = seal(responseKey, rec.record.Response)
+			if err == nil {
+				err = app.models.IdempotencyModel.Complete(ctx, rec.record)
+			}
+		}
+		if err != nil {
+			app.logger.Error("failed to store idempotent response", slog.String("subject", req.Subject()), slog.Any("err", err.Error()))
+		}
+	}
+}
+
+// idempotencyLease is how long a claimed key waits for its response under
+// cfg. No request is handled for longer than the request timeout, so a
+// claim still without a response after twice that belongs to a request
+// that crashed, and a retry takes it over instead of being told the request
+// is in progress until the key expires.
+func idempotencyLease(cfg *config.Config) time.Duration {
+	return 2 * cfg.RequestTimeout
+}
+
+// replay answers req with the response stored for key, which responseKey
+// decrypts.
+func (app *application) replay(ctx context.Context, req micro.Request, key string, hash, responseKey []byte) {
+	record, err := app.models.IdempotencyModel.Get(ctx, req.Subject(), key)
+	if err != nil {
+		if errors.Is(err, data.ErrNoRecord) {
+			// The first request failed and released the key, or the record
+			// expired, between Claim and Get.
+			app.sendErrorResponse(ctx, req, http.StatusConflict, data.CodeRequestInProgress)
+			return
+		}
+		app.sendInternalServerErrorResponse(ctx, req)
+		return
+	}
+
+	switch {
+	case !bytes.Equal(record.RequestHash, hash):
+		app.sendErrorResponse(ctx, req, http.StatusUnprocessableEntity, data.CodeIdempotencyKeyReused)
+		return
+	case record.Response == nil:
+		app.sendErrorResponse(ctx, req, http.StatusConflict, data.CodeRequestInProgress)
+		return
+	}
+
+	response, err := unseal(responseKey, record.Response)
+	if err != nil {
+		app.logger.Error("failed to decrypt idempotent response", slog.String("subject", req.Subject()), slog.Any("err", err.Error()))
+		app.sendInternalServerErrorResponse(ctx, req)
+		return
+	}
+
+	if app.expired(ctx, req) {
+		return
+	}
+	app.metrics.idempotentReplays.WithLabelValues(req.Subject()).Inc()
+
+	header := traceHeaders(ctx)
+	header.Set(replayedHeader, "true")
+	headers := micro.WithHeaders(micro.Headers(header))
+
+	if record.ErrorCode != "" {
+		err = req.Error(record.ErrorCode, record.ErrorDescription, response, headers)
+	} else {
+		err = req.Respond(response, headers)
+	}
+	if err != nil {
+		app.logger.Error("failed to send response", "error", err)
+	}
+}
+
+// requestKeys derives from req, including the Authorization header that
+// identifies some callers, the hash that tells a retry apart from another
+// request or another caller, and the key its response is encrypted with.
+// Both are keyed with secret, so neither the response nor the payload,
+// which may hold a password or a token, can be recovered from the database
+// alone. Retries that straddle a change of secret are rejected as reusing
+// the key.
+func requestKeys(ctx context.Context, secret string, req micro.Request) (hash, responseKey []byte) {
+	derive := func(purpose string) []byte {
+		h := hmac.New(sha256.New, []byte(secret))
+		for _, part := range []string{purpose, apiVersionFromContext(ctx), req.Headers().Get("Authorization")} {
+			h.Write([]byte(part))
+			h.Write([]byte{0})
+		}
+		h.Write(req.Data())
+		return h.Sum(nil)
+	}
+	return derive("request"), derive("response")
+}
+
+// seal encrypts response with key, which must be 32 bytes long.
+func seal(key, response []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(response)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, response, nil), nil
+}
+
+// unseal decrypts a response encrypted by seal.
+func unseal(key, sealed []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("sealed response is too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, nil)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// pruneIdempotencyKeys periodically deletes the responses whose replay
+// window has passed.
+func (app *application) pruneIdempotencyKeys(ctx context.Context) {
+	ticker := time.NewTicker(idempotencyPruneInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		n, err := app.models.IdempotencyModel.DeleteExpired(ctx)
+		if err != nil {
+			app.logger.Error("failed to prune idempotency keys", slog.Any("err", err.Error()))
+			continue
+		}
+		if n > 0 {
+			app.logger.Info("pruned idempotency keys", slog.Int64("count", n))
+		}
+	}
+}
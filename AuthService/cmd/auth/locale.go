@@ -0,0 +1,46 @@
+package main
+
+import (
+	"auth/internal/i18n"
+	"context"
+	"encoding/json"
+
+	"github.com/nats-io/nats.go/micro"
+)
+
+// localeHeader lists the languages the caller prefers, in the format of the
+// HTTP Accept-Language header, so the Gateway can forward it unchanged.
+const localeHeader = "Accept-Language"
+
+const localizerContextKey = contextKey("localizer")
+
+func contextWithLocalizer(ctx context.Context, l i18n.Localizer) context.Context {
+	return context.WithValue(ctx, localizerContextKey, l)
+}
+
+// localizerFromContext returns the Localizer of the request in ctx, or one
+// for the fallback language outside of a request.
+func localizerFromContext(ctx context.Context) i18n.Localizer {
+	l, ok := ctx.Value(localizerContextKey).(i18n.Localizer)
+	if !ok {
+		return i18n.New(nil, i18n.Fallback)
+	}
+	return l
+}
+
+// localizer picks the languages of the reply to req. A "locale" field in the
+// payload names the language the user chose and comes first; the languages
+// of the locale header follow, then the configured default.
+func (app *application) localizer(req micro.Request) i18n.Localizer {
+	var preferred []string
+
+	var input struct {
+		Locale string `json:"locale"`
+	}
+	if json.Unmarshal(req.Data(), &input) == nil && input.Locale != "" {
+		preferred = append(preferred, input.Locale)
+	}
+	preferred = append(preferred, i18n.ParseAcceptLanguage(req.Headers().Get(localeHeader))...)
+
+	return i18n.New(preferred, app.config.Load().DefaultLocale)
+}
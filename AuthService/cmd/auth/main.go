@@ -1,25 +1,74 @@
 package main
 
 import (
+	"auth/internal/config"
 	"auth/internal/data"
+	"auth/internal/geoip"
 	"auth/migrations"
 	"context"
 	"database/sql"
+	"flag"
+	"fmt"
 	"log/slog"
 	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
 	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
 )
 
+// eventsStream is the JetStream stream auth events are published to.
+const eventsStream = "auth_events"
+
 type application struct {
-	nc              *nats.Conn
-	js              nats.JetStreamContext
-	logger          *slog.Logger
-	models          *data.Models
-	jwtAccessSecret string
+	db      *sql.DB
+	nc      *nats.Conn
+	js      nats.JetStreamContext
+	logger  *slog.Logger
+	models  *data.Models
+	metrics *metrics
+
+	// service is the NATS micro service the auth endpoints are registered
+	// with; it is set by start.
+	service micro.Service
+
+	// config is swapped atomically on reload. Handlers load it once so that
+	// a message is processed with a single consistent snapshot.
+	config     atomic.Pointer[config.Config]
+	configFile string
+
+	// migrating is set while the schema migrations run at startup.
+	migrating atomic.Bool
+
+	// dummyUser has a password hash made with the configured hasher and
+	// pepper; see checkPassword.
+	dummyUser atomic.Pointer[data.User]
+
+	// breakGlass is the emergency revocation in force, if any; see
+	// watchBreakGlass.
+	breakGlass atomic.Pointer[data.BreakGlass]
+
+	// geoip locates the addresses sessions are created from; it is nil
+	// while no database is configured. See openGeoIP.
+	geoip atomic.Pointer[geoip.Reader]
+
+	// heavyLane and lightLane are the worker pools requests are handled
+	// by; they are started by start.
+	heavyLane *lane
+	lightLane *lane
+
+	// wg tracks the handlers that are currently processing a message.
+	wg sync.WaitGroup
+
+	// bgWG tracks the jobs started with background. bgCtx is cancelled by
+	// stopBackground during shutdown.
+	bgWG           sync.WaitGroup
+	bgCtx          context.Context
+	stopBackground context.CancelFunc
 }
 
 func main() {
@@ -29,19 +78,36 @@ func main() {
 		logger.Warn("no .env file found")
 	}
 
-	dbDSN := os.Getenv("AUTH_DB_DSN")
-	if dbDSN == "" {
-		logger.Error("failed to get db DSN")
+	configFile := flag.String("config", os.Getenv("AUTH_CONFIG_FILE"), "path to a YAML or TOML config file")
+	printConfig := flag.Bool("print-config", false, "print the effective config (secrets redacted) and exit")
+	flag.Parse()
+
+	cfg, err := config.Load(*configFile)
+	if err != nil {
+		logger.Error("failed to load configuration", slog.Any("err", err.Error()))
 		os.Exit(1)
 	}
 
-	accessSecret := os.Getenv("JWT_ACCESS_SECRET")
-	if accessSecret == "" {
-		logger.Error("failed to get jwt secret")
+	if *printConfig {
+		fmt.Print(cfg)
+		return
+	}
+
+	shutdownTracing, err := setupTracing(cfg.Tracing)
+	if err != nil {
+		logger.Error("failed to set up tracing", slog.Any("err", err.Error()))
 		os.Exit(1)
 	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
 
-	db, err := openDB(dbDSN)
+		if err := shutdownTracing(ctx); err != nil {
+			logger.Error("failed to flush traces", slog.Any("err", err.Error()))
+		}
+	}()
+
+	db, err := openDB(cfg.DB.DSN)
 	if err != nil {
 		logger.Error("failed to connect to database", slog.Any("err", err.Error()))
 		os.Exit(1)
@@ -53,20 +119,13 @@ func main() {
 		}
 	}()
 
-	if err := migrations.RunUpMigrations(dbDSN); err != nil {
-		logger.Error("failed to run db migrations", slog.Any("err", err.Error()))
-		os.Exit(1)
-	}
-
 	opts := nats.Options{
+		Url:            cfg.NATS.URL,
 		AllowReconnect: true,
-		MaxReconnect:   5,
-		ReconnectWait:  5 * time.Second,
-		Timeout:        time.Second,
-	}
-
-	if url := os.Getenv("NATS_URL"); url != "" {
-		opts.Url = url
+		MaxReconnect:   cfg.NATS.MaxReconnect,
+		ReconnectWait:  cfg.NATS.ReconnectWait,
+		Timeout:        cfg.NATS.Timeout,
+		DrainTimeout:   cfg.ShutdownTimeout,
 	}
 
 	nc, err := opts.Connect()
@@ -83,7 +142,7 @@ func main() {
 	}
 
 	_, err = js.AddStream(&nats.StreamConfig{
-		Name:        "auth_events",
+		Name:        eventsStream,
 		Description: "stream for authentication events",
 		Subjects:    []string{"auth.events.>"},
 		Retention:   nats.WorkQueuePolicy,
@@ -92,22 +151,43 @@ func main() {
 		Discard:     nats.DiscardOld,
 	})
 
+	bgCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+
 	app := &application{
-		nc:              nc,
-		js:              js,
-		logger:          logger,
-		models:          data.NewModels(db),
-		jwtAccessSecret: accessSecret,
+		db:             db,
+		nc:             nc,
+		js:             js,
+		logger:         logger,
+		models:         data.NewModels(db),
+		metrics:        newMetrics(db, nc),
+		configFile:     *configFile,
+		bgCtx:          bgCtx,
+		stopBackground: stopBackground,
+	}
+
+	app.config.Store(&cfg)
+	app.migrating.Store(true)
+
+	// Serve the health endpoints before migrating so that readiness reports
+	// the migration in progress.
+	if err := app.listenHTTP(); err != nil {
+		logger.Error("failed to start http server", slog.Any("err", err.Error()))
+		os.Exit(1)
 	}
 
-	err = app.start()
+	err = migrations.RunUpMigrations(cfg.DB.DSN)
+	app.migrating.Store(false)
 	if err != nil {
-		app.logger.Error("failed to start application", slog.Any("err", err.Error()))
+		logger.Error("failed to run db migrations", slog.Any("err", err.Error()))
 		os.Exit(1)
 	}
 
-	logger.Info("auth service started")
-	select {}
+	err = app.serve()
+	if err != nil {
+		app.logger.Error("failed to run application", slog.Any("err", err.Error()))
+		os.Exit(1)
+	}
 }
 
 func openDB(dsn string) (*sql.DB, error) {
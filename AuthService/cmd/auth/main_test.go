@@ -1,6 +1,7 @@
 package main
 
 import (
+	"auth/internal/config"
 	"auth/internal/data"
 	"auth/migrations"
 	"database/sql"
@@ -38,12 +39,22 @@ func TestMain(m *testing.M) {
 		log.Fatal("failed to connect to NATS", slog.Any("err", err))
 	}
 
+	cfg := config.Default()
+	cfg.DB.DSN = dsn
+	cfg.JWT.AccessSecret = "test-secret-ensure-32-bytes-long-string!"
+
+	if _, err := setupTracing(cfg.Tracing); err != nil {
+		log.Fatal("failed to set up tracing", slog.Any("err", err))
+	}
+
 	app = &application{
-		nc:              nc,
-		logger:          logger,
-		models:          data.NewModels(db),
-		jwtAccessSecret: "test-secret-ensure-32-bytes-long-string!",
+		db:      db,
+		nc:      nc,
+		logger:  logger,
+		models:  data.NewModels(db),
+		metrics: newMetrics(db, nc),
 	}
+	app.config.Store(&cfg)
 
 	err = app.start()
 	if err != nil {
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const sessionMetricsInterval = 15 * time.Second
+
+type metrics struct {
+	registry *prometheus.Registry
+
+	requests           *prometheus.CounterVec
+	requestDuration    *prometheus.HistogramVec
+	deprecatedRequests *prometheus.CounterVec
+	idempotentReplays  *prometheus.CounterVec
+	deadlineExceeded   *prometheus.CounterVec
+	queuedRequests     *prometheus.GaugeVec
+	shedRequests       *prometheus.CounterVec
+	passwordHashing    *prometheus.HistogramVec
+	loginFailures      *prometheus.CounterVec
+	loginAlerts        *prometheus.CounterVec
+	activeSessions     prometheus.Gauge
+}
+
+func newMetrics(db *sql.DB, nc *nats.Conn) *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "auth_requests_total",
+			Help: "Requests handled, by subject and response status code.",
+		}, []string{"subject", "status"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "auth_request_duration_seconds",
+			Help:    "Time spent handling a request, by subject.",
+			Buckets: []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+		}, []string{"subject"}),
+		deprecatedRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "auth_deprecated_requests_total",
+			Help: "Requests received on deprecated unversioned subjects, by subject.",
+		}, []string{"subject"}),
+		idempotentReplays: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "auth_idempotent_replays_total",
+			Help: "Responses replayed for retried requests with an idempotency key, by subject.",
+		}, []string{"subject"}),
+		deadlineExceeded: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "auth_deadline_exceeded_total",
+			Help: "Requests left unanswered because the caller's deadline had passed, by subject.",
+		}, []string{"subject"}),
+		queuedRequests: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "auth_queued_requests",
+			Help: "Requests waiting for a free worker, by lane.",
+		}, []string{"lane"}),
+		shedRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "auth_shed_requests_total",
+			Help: "Requests rejected because their lane's queue was full, by lane.",
+		}, []string{"lane"}),
+		passwordHashing: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "auth_password_hash_duration_seconds",
+			Help:    "Time spent hashing, comparing, rehashing or checking the history of passwords.",
+			Buckets: []float64{.01, .025, .05, .1, .25, .5, 1, 2.5},
+		}, []string{"operation"}),
+		loginFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "auth_login_failures_total",
+			Help: "Rejected login attempts, by reason.",
+		}, []string{"reason"}),
+		loginAlerts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "auth_login_alerts_total",
+			Help: "Logins flagged as suspicious, by flag.",
+		}, []string{"flag"}),
+		activeSessions: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "auth_active_sessions",
+			Help: "Sessions that are neither revoked nor expired.",
+		}),
+	}
+
+	m.registry.MustRegister(
+		m.requests,
+		m.requestDuration,
+		m.deprecatedRequests,
+		m.idempotentReplays,
+		m.deadlineExceeded,
+		m.queuedRequests,
+		m.shedRequests,
+		m.passwordHashing,
+		m.loginFailures,
+		m.loginAlerts,
+		m.activeSessions,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		collectors.NewDBStatsCollector(db, "auth"),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "auth_nats_connected",
+			Help: "Whether the NATS connection is established (1) or not (0).",
+		}, func() float64 {
+			if nc.IsConnected() {
+				return 1
+			}
+			return 0
+		}),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "auth_nats_reconnects_total",
+			Help: "Times the NATS connection has been re-established.",
+		}, func() float64 {
+			return float64(nc.Stats().Reconnects)
+		}),
+	)
+
+	return m
+}
+
+// observePasswordHashing records how long a password operation started at
+// start took.
+func (m *metrics) observePasswordHashing(operation string, start time.Time) {
+	m.passwordHashing.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
+
+// collectSessionMetrics periodically refreshes the gauges that need a
+// database query.
+func (app *application) collectSessionMetrics(ctx context.Context) {
+	ticker := time.NewTicker(sessionMetricsInterval)
+	defer ticker.Stop()
+
+	for {
+		n, err := app.models.SessionModel.CountActive(ctx)
+		if err != nil {
+			app.logger.Error("failed to count active sessions", slog.Any("err", err.Error()))
+		} else {
+			app.metrics.activeSessions.Set(float64(n))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"auth/internal/config"
+	"auth/internal/data"
+	"auth/internal/passhash"
+	"auth/internal/passpolicy"
+	"auth/internal/validator"
+	"context"
+	"crypto/rand"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/nats-io/nats.go/micro"
+)
+
+var errUnknownPepper = errors.New("password hash made with an unknown pepper")
+
+// newHasher returns the hasher new passwords are hashed with under cfg.
+func newHasher(cfg config.Password) passhash.Hasher {
+	if cfg.Algorithm == "bcrypt" {
+		return passhash.Bcrypt{Cost: cfg.BcryptCost}
+	}
+	return passhash.Argon2id{
+		Memory:      uint32(cfg.Argon2.Memory),
+		Iterations:  uint32(cfg.Argon2.Iterations),
+		Parallelism: uint8(cfg.Argon2.Parallelism),
+	}
+}
+
+// findPepper returns the pepper with key ID id among the current and
+// previous ones of cfg. The empty ID names the zero pepper, which is always
+// accepted, so hashes made before peppering was enabled keep verifying.
+func findPepper(cfg config.Password, id string) (passhash.Pepper, bool) {
+	if id == "" {
+		return passhash.Pepper{}, true
+	}
+	for _, key := range append([]string{cfg.Pepper}, cfg.PreviousPeppers...) {
+		if pepper := passhash.NewPepper(key); pepper.ID == id {
+			return pepper, true
+		}
+	}
+	return passhash.Pepper{}, false
+}
+
+// newPasswordPolicy returns the policy new passwords are checked against
+// under cfg.
+func newPasswordPolicy(cfg config.PasswordPolicy) passpolicy.Policy {
+	return passpolicy.Policy{
+		Breached:       passpolicy.Corpus(cfg.BreachedDir),
+		MinBreachCount: cfg.MinBreachCount,
+		MinStrength:    cfg.MinStrength,
+		MaxSimilarity:  cfg.MaxSimilarity,
+	}
+}
+
+// checkNewPassword checks a password a user is choosing, sent in the field
+// at JSON pointer key, against the password policy. When it is rejected an
+// error response is sent and checkNewPassword returns false.
+func (app *application) checkNewPassword(ctx context.Context, req micro.Request, cfg config.Password, key, password string, userInputs ...string) bool {
+	v := validator.New()
+	if err := newPasswordPolicy(cfg.Policy).Validate(v, key, password, userInputs...); err != nil {
+		app.logger.Error("failed to check password policy", slog.Any("err", err.Error()))
+		app.sendInternalServerErrorResponse(ctx, req)
+		return false
+	}
+	if !v.Valid() {
+		app.sendFailedValidationResponse(ctx, req, v.Errors)
+		return false
+	}
+	return true
+}
+
+// setPassword hashes the new password of user, sent in the field at JSON
+// pointer key. When it cannot be hashed an error response is sent and
+// setPassword returns false.
+func (app *application) setPassword(ctx context.Context, req micro.Request, cfg config.Password, user *data.User, key, password string) bool {
+	start := time.Now()
+	err := user.Password.Set(password, newHasher(cfg), passhash.NewPepper(cfg.Pepper))
+	app.metrics.observePasswordHashing("hash", start)
+	if err != nil {
+		if errors.Is(err, passhash.ErrPasswordTooLong) {
+			app.sendFailedValidationResponse(ctx, req, map[string]validator.Error{
+				key: {Rule: "max_bytes", Args: []any{passhash.MaxBcryptLength}},
+			})
+			return false
+		}
+		app.sendInternalServerErrorResponse(ctx, req)
+		return false
+	}
+	return true
+}
+
+// newDummyUser hashes a random password with hasher and pepper, for
+// checkPassword to compare against when a login names an unknown email. It
+// runs at startup and whenever the password settings are reloaded, never on
+// the request path.
+func newDummyUser(hasher passhash.Hasher, pepper passhash.Pepper) (*data.User, error) {
+	user := &data.User{}
+	if err := user.Password.Set(rand.Text(), hasher, pepper); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// checkPassword reports whether plaintext is the password of user. A nil
+// user is compared against the dummy hash instead, so that a login for an
+// unknown email costs the same single hash comparison as a wrong password
+// and its timing does not reveal which emails are registered. The dummy
+// hash is made with the current settings, so a user whose hash predates
+// them still compares at another cost until their next login replaces it.
+func (app *application) checkPassword(cfg config.Password, user *data.User, plaintext string) (bool, error) {
+	if user == nil {
+		_, err := app.dummyUser.Load().Password.Matches(plaintext, passhash.NewPepper(cfg.Pepper))
+		return false, err
+	}
+
+	pepper, ok := findPepper(cfg, user.Password.PepperID())
+	if !ok {
+		return false, errUnknownPepper
+	}
+	return user.Password.Matches(plaintext, pepper)
+}
+
+// checkPasswordReuse rejects a new password of user, sent in the field at
+// JSON pointer key, that repeats one of the last cfg.Policy.History
+// passwords, the current one included. Each comparison costs a full hash,
+// so the history should stay short. When it is rejected an error response
+// is sent and checkPasswordReuse returns false.
+func (app *application) checkPasswordReuse(ctx context.Context, req micro.Request, cfg config.Password, user *data.User, key, password string) bool {
+	n := cfg.Policy.History
+	if n == 0 {
+		return true
+	}
+
+	history, err := app.models.UserModel.PasswordHistory(ctx, user.ID, n-1)
+	if err != nil {
+		app.sendInternalServerErrorResponse(ctx, req)
+		return false
+	}
+
+	start := time.Now()
+	defer app.metrics.observePasswordHashing("history", start)
+
+	for _, p := range append(history, user.Password) {
+		// A hash made with a retired pepper can no longer be compared.
+		pepper, ok := findPepper(cfg, p.PepperID())
+		if !ok {
+			continue
+		}
+		match, err := p.Matches(password, pepper)
+		if err != nil {
+			app.logger.Error("failed to compare password history", slog.String("user_id", user.ID), slog.Any("err", err.Error()))
+			app.sendInternalServerErrorResponse(ctx, req)
+			return false
+		}
+		if match {
+			app.sendFailedValidationResponse(ctx, req, map[string]validator.Error{
+				key: {Rule: "password_reused", Args: []any{n}},
+			})
+			return false
+		}
+	}
+	return true
+}
+
+// passwordExpired reports whether the password of user is older than
+// cfg.Policy.MaxAge allows.
+func passwordExpired(cfg config.Password, user *data.User) bool {
+	return cfg.Policy.MaxAge > 0 && time.Since(user.PasswordChangedAt) > cfg.Policy.MaxAge
+}
+
+// rehashPassword replaces the stored hash of user, which was made with an
+// older algorithm, older parameters or another pepper, with one made by
+// hasher and pepper. It is only possible right after a successful login,
+// while the plaintext is known. A failure is logged and leaves the old hash,
+// which keeps working, in place.
+func (app *application) rehashPassword(ctx context.Context, user *data.User, plaintext string, hasher passhash.Hasher, pepper passhash.Pepper) {
+	start := time.Now()
+	err := user.Password.Set(plaintext, hasher, pepper)
+	app.metrics.observePasswordHashing("rehash", start)
+	if err != nil {
+		app.logger.Error("failed to rehash password", slog.String("user_id", user.ID), slog.Any("err", err.Error()))
+		return
+	}
+
+	if err := app.models.UserModel.UpdatePasswordHash(ctx, user); err != nil {
+		app.logger.Error("failed to store rehashed password", slog.String("user_id", user.ID), slog.Any("err", err.Error()))
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"auth/internal/data"
+	v1 "auth/internal/data/v1"
+	"auth/internal/passhash"
+	"auth/internal/testutils"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+const (
+	oldPepper = "old-pepper-ensure-32-bytes-long-string!"
+	newPepper = "new-pepper-ensure-32-bytes-long-string!"
+)
+
+func TestCheckPassword(t *testing.T) {
+	cfg := app.config.Load().Password
+	cfg.Pepper = newPepper
+	cfg.PreviousPeppers = []string{oldPepper}
+
+	newUser := func(pepper string) *data.User {
+		user := &data.User{}
+		if err := user.Password.Set("correct horse", passhash.Bcrypt{Cost: 4}, passhash.NewPepper(pepper)); err != nil {
+			t.Fatal(err)
+		}
+		return user
+	}
+
+	tests := []struct {
+		name      string
+		user      *data.User
+		plaintext string
+		want      bool
+		wantErr   error
+	}{
+		{"right password", newUser(newPepper), "correct horse", true, nil},
+		{"wrong password", newUser(newPepper), "battery staple", false, nil},
+		{"previous pepper", newUser(oldPepper), "correct horse", true, nil},
+		{"no pepper", newUser(""), "correct horse", true, nil},
+		{"retired pepper", newUser("retired-pepper-ensure-32-bytes-long!"), "correct horse", false, errUnknownPepper},
+		{"unknown user", nil, "correct horse", false, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := app.checkPassword(cfg, tt.user, tt.plaintext)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %v want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestDummyUserFollowsHasher checks that unknown emails are timed like
+// users whose hash is up to date. Users with an older hash are told apart
+// by timing until their next login replaces it, see TestLoginRehashesPassword.
+func TestDummyUserFollowsHasher(t *testing.T) {
+	cfg := app.config.Load().Password
+	if app.dummyUser.Load().Password.NeedsRehash(newHasher(cfg), passhash.NewPepper(cfg.Pepper)) {
+		t.Error("expected the dummy hash to be made with the current settings")
+	}
+}
+
+func TestLoginRehashesPassword(t *testing.T) {
+	testutils.ResetTestDB(t, dsn)
+
+	user := &data.User{Email: "test@mail.com", Username: "tester"}
+	if err := user.Password.Set("12345678", passhash.Bcrypt{Cost: 4}, passhash.Pepper{}); err != nil {
+		t.Fatalf("failed to set user password: %v", err)
+	}
+	if err := app.models.UserModel.Insert(context.Background(), user); err != nil {
+		t.Fatalf("failed to insert user in db: %v", err)
+	}
+
+	login := Test{
+		name:    "success - valid login",
+		payload: []byte(`{"email":"test@mail.com","password":"12345678"}`),
+		want:    http.StatusOK,
+	}
+	runTests(t, "auth.v1.login", []Test{login})
+
+	stored, err := app.models.UserModel.GetByEmail(context.Background(), user.Email)
+	if err != nil {
+		t.Fatalf("failed to get user: %v", err)
+	}
+	cfg := app.config.Load().Password
+	if stored.Password.NeedsRehash(newHasher(cfg), passhash.NewPepper(cfg.Pepper)) {
+		t.Error("expected the outdated hash to be replaced on login")
+	}
+
+	login.name = "success - login with the new hash"
+	runTests(t, "auth.v1.login", []Test{login})
+}
+
+func TestLoginRotatesPepper(t *testing.T) {
+	testutils.ResetTestDB(t, dsn)
+
+	previous := app.config.Load()
+	t.Cleanup(func() { app.config.Store(previous) })
+
+	cfg := *previous
+	cfg.Password.Pepper = newPepper
+	cfg.Password.PreviousPeppers = []string{oldPepper}
+	app.config.Store(&cfg)
+
+	user := &data.User{Email: "test@mail.com", Username: "tester"}
+	if err := user.Password.Set("12345678", newHasher(cfg.Password), passhash.NewPepper(oldPepper)); err != nil {
+		t.Fatalf("failed to set user password: %v", err)
+	}
+	if err := app.models.UserModel.Insert(context.Background(), user); err != nil {
+		t.Fatalf("failed to insert user in db: %v", err)
+	}
+
+	runTests(t, "auth.v1.login", []Test{{
+		name:    "success - login with the previous pepper",
+		payload: []byte(`{"email":"test@mail.com","password":"12345678"}`),
+		want:    http.StatusOK,
+	}})
+
+	stored, err := app.models.UserModel.GetByEmail(context.Background(), user.Email)
+	if err != nil {
+		t.Fatalf("failed to get user: %v", err)
+	}
+	if got, want := stored.Password.PepperID(), passhash.NewPepper(newPepper).ID; got != want {
+		t.Errorf("got pepper ID %q want %q", got, want)
+	}
+
+	// Once every user has logged in, the previous pepper can be retired.
+	cfg.Password.PreviousPeppers = nil
+	runTests(t, "auth.v1.login", []Test{{
+		name:    "success - login after retiring the previous pepper",
+		payload: []byte(`{"email":"test@mail.com","password":"12345678"}`),
+		want:    http.StatusOK,
+	}})
+}
+
+func TestChangePasswordHistory(t *testing.T) {
+	testutils.ResetTestDB(t, dsn)
+
+	previous := app.config.Load()
+	t.Cleanup(func() { app.config.Store(previous) })
+
+	cfg := *previous
+	cfg.Password.Policy.History = 3
+	app.config.Store(&cfg)
+
+	passwords := []string{"Xq7#vLm2!rTz", "Bn4$kWp8@sYe", "Hd9%cRj3&uQo", "Mf6^tGz1*wNa"}
+
+	user := &data.User{Email: "test@mail.com", Username: "tester"}
+	if err := user.Password.Set(passwords[0], newHasher(cfg.Password), passhash.NewPepper(cfg.Password.Pepper)); err != nil {
+		t.Fatalf("failed to set user password: %v", err)
+	}
+	if err := app.models.UserModel.Insert(context.Background(), user); err != nil {
+		t.Fatalf("failed to insert user in db: %v", err)
+	}
+	hash := sha256.Sum256([]byte(generateOpaqueTokenForTest(t)))
+	session := createTestSession(t, user.ID, hash[:], time.Now().Add(24*time.Hour))
+
+	// Every change makes the previous access token stale, so each one is
+	// sent with a fresh token.
+	change := func(name string, from, to int, want int) {
+		t.Helper()
+
+		version, err := app.models.UserModel.GetTokenVersion(context.Background(), user.ID)
+		if err != nil {
+			t.Fatalf("failed to get token version: %v", err)
+		}
+		user.TokenVersion = version
+		token, err := app.generateAccessToken(&cfg, user, session.SessionID)
+		if err != nil {
+			t.Fatalf("failed to generate access token: %v", err)
+		}
+
+		test := Test{
+			name:    name,
+			payload: []byte(fmt.Sprintf(`{"access_token": "%s", "current_password": "%s", "new_password": "%s"}`, token, passwords[from], passwords[to])),
+			want:    want,
+		}
+		if want != http.StatusOK {
+			test.code = data.CodeValidationFailed
+		}
+		runTests(t, "auth.v1.change_password", []Test{test})
+	}
+
+	change("success - first change", 0, 1, http.StatusOK)
+	change("success - second change", 1, 2, http.StatusOK)
+	change("fail - current password", 2, 2, http.StatusUnprocessableEntity)
+	change("fail - password from the history", 2, 0, http.StatusUnprocessableEntity)
+	change("success - third change", 2, 3, http.StatusOK)
+	change("success - password older than the history", 3, 0, http.StatusOK)
+}
+
+func TestLoginExpiredPassword(t *testing.T) {
+	testutils.ResetTestDB(t, dsn)
+
+	previous := app.config.Load()
+	t.Cleanup(func() { app.config.Store(previous) })
+
+	cfg := *previous
+	cfg.Password.Policy.MaxAge = 90 * 24 * time.Hour
+	app.config.Store(&cfg)
+
+	user := createTestUser(t)
+	_, err := app.models.UserModel.DB.Exec(`UPDATE users SET password_changed_at = NOW() - INTERVAL '91 days' WHERE id = $1`, user.ID)
+	if err != nil {
+		t.Fatalf("failed to age password: %v", err)
+	}
+
+	msg, err := app.nc.Request("auth.v1.login", []byte(`{"email":"test@mail.com","password":"12345678"}`), 2*time.Second)
+	if err != nil {
+		t.Fatalf("failed to get login response: %v", err)
+	}
+	var r struct {
+		data.Response
+		Data v1.PasswordExpiredResponse `json:"data"`
+	}
+	if err := json.Unmarshal(msg.Data, &r); err != nil {
+		t.Fatalf("failed to unmarshal login response: %v", err)
+	}
+	if r.StatusCode != http.StatusForbidden || r.Code != data.CodePasswordExpired {
+		t.Fatalf("got %d %s want %d %s", r.StatusCode, r.Code, http.StatusForbidden, data.CodePasswordExpired)
+	}
+	token := r.Data.PasswordChangeToken
+	if token == "" {
+		t.Fatal("expected a password change token")
+	}
+
+	runTests(t, "auth.v1.validate", []Test{{
+		name:    "fail - password change token is not an access token",
+		payload: []byte(fmt.Sprintf(`{"access_token": "%s"}`, token)),
+		want:    http.StatusForbidden,
+		code:    data.CodePasswordExpired,
+	}})
+	runTests(t, "auth.v1.change_password", []Test{{
+		name:    "success - change with the password change token",
+		payload: []byte(fmt.Sprintf(`{"access_token": "%s", "current_password": "12345678", "new_password": "Xq7#vLm2!rTz"}`, token)),
+		want:    http.StatusOK,
+	}})
+	runTests(t, "auth.v1.login", []Test{{
+		name:    "success - login with the new password",
+		payload: []byte(`{"email":"test@mail.com","password":"Xq7#vLm2!rTz"}`),
+		want:    http.StatusOK,
+	}})
+}
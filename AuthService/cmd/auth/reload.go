@@ -0,0 +1,204 @@
+package main
+
+import (
+	"auth/internal/config"
+	"auth/internal/passhash"
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// reloadDebounce groups the burst of events editors and Kubernetes produce
+// when a config file is replaced into a single reload.
+const reloadDebounce = 500 * time.Millisecond
+
+// watchConfig reloads the configuration on SIGHUP and, when watch_config is
+// enabled, whenever the config file or the GeoIP database changes.
+func (app *application) watchConfig(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var (
+		watch  *configWatch
+		events <-chan fsnotify.Event
+	)
+	if app.configFile != "" && app.config.Load().WatchConfig {
+		watcher, err := fsnotify.NewWatcher()
+		if err != nil {
+			app.logger.Error("failed to watch config file", slog.Any("err", err.Error()))
+		} else {
+			defer func() {
+				_ = watcher.Close()
+			}()
+
+			watch = &configWatch{watcher: watcher, configFile: app.configFile}
+			if err := watch.follow(app.config.Load().GeoIP); err != nil {
+				app.logger.Error("failed to watch config file", slog.Any("err", err.Error()))
+			}
+			events = watcher.Events
+		}
+	}
+
+	debounce := time.NewTimer(reloadDebounce)
+	debounce.Stop()
+	reopenGeoIP := false
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			app.logger.Info("reloading configuration", slog.String("trigger", "SIGHUP"))
+			app.reloadConfig(true)
+		case event := <-events:
+			changed, database := watch.changed(event.Name)
+			if !changed {
+				continue
+			}
+			reopenGeoIP = reopenGeoIP || database
+			debounce.Reset(reloadDebounce)
+			continue
+		case <-debounce.C:
+			app.logger.Info("reloading configuration", slog.String("trigger", "file change"))
+			app.reloadConfig(reopenGeoIP)
+			reopenGeoIP = false
+		}
+
+		// The reload may have pointed the GeoIP database elsewhere.
+		if watch != nil {
+			if err := watch.follow(app.config.Load().GeoIP); err != nil {
+				app.logger.Error("failed to watch config file", slog.Any("err", err.Error()))
+			}
+		}
+	}
+}
+
+// configWatch picks the events about the config file and the GeoIP database
+// out of those fsnotify reports for the directories holding them. The
+// directories are watched rather than the files, which are usually replaced
+// instead of written in place.
+type configWatch struct {
+	watcher    *fsnotify.Watcher
+	configFile string
+	database   string
+	// targets holds what each file resolved to when last seen, so that a
+	// symlink swapped to point elsewhere, as Kubernetes does with mounted
+	// ConfigMaps, counts as a change to the file.
+	targets map[string]string
+}
+
+// follow watches the config file and the GeoIP database cfg names.
+func (w *configWatch) follow(cfg config.GeoIP) error {
+	w.database = ""
+	if cfg.Database != "" {
+		w.database = filepath.Clean(cfg.Database)
+	}
+
+	w.targets = make(map[string]string)
+	var errs []error
+	for _, path := range w.files() {
+		w.targets[path] = resolve(path)
+		if w.watcher != nil {
+			if err := w.watcher.Add(filepath.Dir(path)); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// changed reports whether the event about name changed the config file or
+// the GeoIP database, and whether it was the database.
+func (w *configWatch) changed(name string) (changed, database bool) {
+	name = filepath.Clean(name)
+	for _, path := range w.files() {
+		target := resolve(path)
+		if name == path || target != w.targets[path] {
+			w.targets[path] = target
+			changed = true
+			database = database || path == w.database
+		}
+	}
+	return changed, database
+}
+
+func (w *configWatch) files() []string {
+	files := []string{filepath.Clean(w.configFile)}
+	if w.database != "" {
+		files = append(files, w.database)
+	}
+	return files
+}
+
+// resolve returns the file path ends up at after following symlinks, or
+// the empty string while it does not exist.
+func resolve(path string) string {
+	target, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return ""
+	}
+	return target
+}
+
+// reloadConfig loads the configuration again and swaps it in. A config that
+// fails to load or validate is rejected and the current one is kept. With
+// reopenGeoIP the GeoIP database is read again even if its path is the same,
+// for when the file has been replaced.
+func (app *application) reloadConfig(reopenGeoIP bool) {
+	next, err := config.Load(app.configFile)
+	if err != nil {
+		app.logger.Error("configuration reload rejected", slog.Any("err", err.Error()))
+		return
+	}
+
+	reopenGeoIP = reopenGeoIP && next.GeoIP.Database != ""
+
+	current := app.config.Load()
+	next, kept := config.ApplyReload(*current, next)
+	if len(kept) > 0 {
+		app.logger.Warn("some settings only take effect after a restart", slog.Any("settings", kept))
+	}
+
+	if reflect.DeepEqual(*current, next) && !reopenGeoIP {
+		app.logger.Info("configuration unchanged")
+		return
+	}
+
+	// Everything the new settings need is built before any of it is swapped
+	// in, so that a reload rejected half way leaves the old settings whole.
+	dummyUser := app.dummyUser.Load()
+	if !reflect.DeepEqual(next.Password, current.Password) {
+		dummyUser, err = newDummyUser(newHasher(next.Password), passhash.NewPepper(next.Password.Pepper))
+		if err != nil {
+			app.logger.Error("configuration reload rejected", slog.Any("err", err.Error()))
+			return
+		}
+	}
+
+	previousLocator := app.geoip.Load()
+	locator := previousLocator
+	if next.GeoIP != current.GeoIP || reopenGeoIP {
+		locator, err = openGeoIP(next.GeoIP)
+		if err != nil {
+			app.logger.Error("configuration reload rejected", slog.Any("err", err.Error()))
+			return
+		}
+	}
+
+	app.dummyUser.Store(dummyUser)
+	app.geoip.Store(locator)
+	app.config.Store(&next)
+	if previousLocator != nil && previousLocator != locator {
+		_ = previousLocator.Close()
+	}
+	app.logger.Info("configuration reloaded")
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"auth/internal/config"
+	"auth/internal/geoip"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReloadConfigRejectsWholly(t *testing.T) {
+	current := app.config.Load()
+	dummyUser := app.dummyUser.Load()
+	locator := app.geoip.Load()
+
+	// The password settings are valid and apply before the GeoIP database
+	// fails to open, which must undo them too.
+	path := filepath.Join(t.TempDir(), "auth.yaml")
+	contents := fmt.Sprintf(`
+db:
+  dsn: %q
+jwt:
+  access_secret: %q
+password:
+  algorithm: bcrypt
+  bcrypt_cost: 4
+geoip:
+  database: %q
+`, current.DB.DSN, current.JWT.AccessSecret, filepath.Join(t.TempDir(), "missing.mmdb"))
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	previousFile := app.configFile
+	app.configFile = path
+	t.Cleanup(func() { app.configFile = previousFile })
+
+	app.reloadConfig(false)
+
+	if app.config.Load() != current {
+		t.Error("expected the configuration to be kept")
+	}
+	if app.dummyUser.Load() != dummyUser {
+		t.Error("expected the dummy user to be kept")
+	}
+	if app.geoip.Load() != locator {
+		t.Error("expected the GeoIP database to be kept")
+	}
+}
+
+// oneCountryDB returns a GeoIP database that places every IPv4 address in
+// country.
+func oneCountryDB(t *testing.T, country string) *geoip.Reader {
+	t.Helper()
+
+	// A single node whose records both point at the first data record.
+	buf := []byte{0, 0, 17, 0, 0, 17}
+	buf = append(buf, make([]byte, 16)...)
+	buf = append(buf, 0xe1, 0x47)
+	buf = append(buf, "country"...)
+	buf = append(buf, 0xe1, 0x48)
+	buf = append(buf, "iso_code"...)
+	buf = append(buf, 0x40|byte(len(country)))
+	buf = append(buf, country...)
+	buf = append(buf, "\xab\xcd\xefMaxMind.com"...)
+	buf = append(buf, 0xe3, 0x4a)
+	buf = append(buf, "node_count"...)
+	buf = append(buf, 0xa1, 1, 0x4b)
+	buf = append(buf, "record_size"...)
+	buf = append(buf, 0xa1, 24, 0x4a)
+	buf = append(buf, "ip_version"...)
+	buf = append(buf, 0xa1, 4)
+
+	r, err := geoip.New(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestLocateAfterReload(t *testing.T) {
+	previous := app.geoip.Load()
+	t.Cleanup(func() { app.geoip.Store(previous) })
+
+	// A lookup that loaded the old database before the reload closed it
+	// goes on with the new one.
+	replaced, current := oneCountryDB(t, "AR"), oneCountryDB(t, "UY")
+	app.geoip.Store(current)
+	if err := replaced.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	loc, err := app.locate(replaced, netip.MustParseAddr("203.0.113.7"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loc.Country != "UY" {
+		t.Errorf("got country %q want %q", loc.Country, "UY")
+	}
+
+	// Once GeoIP is turned off there is nothing to locate with.
+	app.geoip.Store(nil)
+	loc, err = app.locate(replaced, netip.MustParseAddr("203.0.113.7"))
+	if err != nil || loc != (geoip.Location{}) {
+		t.Errorf("got %+v, %v want an empty location", loc, err)
+	}
+}
+
+func TestConfigWatchChanged(t *testing.T) {
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "auth.yaml")
+	database := filepath.Join(dir, "GeoLite2-City.mmdb")
+
+	// Kubernetes mounts a ConfigMap as a symlink into a directory it swaps
+	// on every update.
+	for _, name := range []string{"v1", "v2"} {
+		if err := os.Mkdir(filepath.Join(dir, name), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name, "auth.yaml"), nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("v1", filepath.Join(dir, "data")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join("data", "auth.yaml"), configFile); err != nil {
+		t.Fatal(err)
+	}
+
+	watch := &configWatch{configFile: configFile}
+	if err := watch.follow(config.GeoIP{Database: database}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		event    string
+		setup    func(t *testing.T)
+		changed  bool
+		database bool
+	}{
+		{name: "unrelated file", event: filepath.Join(dir, "notes.txt")},
+		{name: "config file", event: configFile, changed: true},
+		{name: "geoip database", event: database, changed: true, database: true},
+		{
+			name:  "swapped symlink",
+			event: filepath.Join(dir, "data"),
+			setup: func(t *testing.T) {
+				link := filepath.Join(dir, "data")
+				if err := os.Remove(link); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Symlink("v2", link); err != nil {
+					t.Fatal(err)
+				}
+			},
+			changed: true,
+		},
+		{name: "unrelated file after the swap", event: filepath.Join(dir, "notes.txt")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.setup != nil {
+				tt.setup(t)
+			}
+			changed, database := watch.changed(tt.event)
+			if changed != tt.changed || database != tt.database {
+				t.Errorf("got changed=%v database=%v want changed=%v database=%v", changed, database, tt.changed, tt.database)
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/nats-io/nats.go"
+)
+
+// serve starts consuming auth subjects and blocks until a termination
+// signal has been received and the service has been shut down.
+func (app *application) serve() error {
+	shutdownError := make(chan error)
+
+	go func() {
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+		s := <-quit
+
+		app.logger.Info("shutting down auth service", slog.String("signal", s.String()))
+
+		ctx, cancel := context.WithTimeout(context.Background(), app.config.Load().ShutdownTimeout)
+		defer cancel()
+
+		shutdownError <- app.shutdown(ctx)
+	}()
+
+	if err := app.start(); err != nil {
+		return err
+	}
+
+	app.background(app.watchConfig)
+	app.background(app.collectSessionMetrics)
+	app.background(app.pruneIdempotencyKeys)
+	app.background(app.watchBreakGlass)
+
+	app.logger.Info("auth service started")
+
+	if err := <-shutdownError; err != nil {
+		return err
+	}
+
+	app.logger.Info("auth service stopped")
+	return nil
+}
+
+// shutdown stops accepting new messages, lets the in-flight handlers finish
+// and stops the background jobs. It gives up once ctx is done.
+func (app *application) shutdown(ctx context.Context) error {
+	closed := make(chan struct{})
+	app.nc.SetClosedHandler(func(_ *nats.Conn) {
+		close(closed)
+	})
+
+	// Stopping the service drains the endpoint subscriptions, so no new
+	// requests are accepted. The handlers already running, and the jobs
+	// queued in the lanes, still have to reply, so the connection is only
+	// drained, which flushes those replies and closes it, once they are
+	// done. A message delivered after the lanes have stopped is turned away.
+	if err := app.service.Stop(); err != nil {
+		return fmt.Errorf("stop micro service: %w", err)
+	}
+	if err := wait(ctx, app.wg.Wait); err != nil {
+		return fmt.Errorf("wait for in-flight handlers: %w", err)
+	}
+	app.heavyLane.stop()
+	app.lightLane.stop()
+
+	if err := app.nc.Drain(); err != nil {
+		return fmt.Errorf("drain nats connection: %w", err)
+	}
+	if err := wait(ctx, func() { <-closed }); err != nil {
+		return fmt.Errorf("wait for nats connection to close: %w", err)
+	}
+
+	app.stopBackground()
+	if err := wait(ctx, app.bgWG.Wait); err != nil {
+		return fmt.Errorf("wait for background jobs: %w", err)
+	}
+
+	return nil
+}
+
+// background runs fn in its own goroutine. fn should return once ctx is
+// cancelled, which happens during shutdown.
+func (app *application) background(fn func(ctx context.Context)) {
+	app.bgWG.Add(1)
+
+	go func() {
+		defer app.bgWG.Done()
+		defer func() {
+			if err := recover(); err != nil {
+				app.logger.Error("background job panicked", slog.Any("err", err))
+			}
+		}()
+
+		fn(app.bgCtx)
+	}()
+}
+
+// wait runs fn and returns once it does, or with ctx's error if ctx is done
+// first.
+func wait(ctx context.Context, fn func()) error {
+	done := make(chan struct{})
+	go func() {
+		fn()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
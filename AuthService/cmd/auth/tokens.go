@@ -1,59 +1,173 @@
 package main
 
 import (
+	"auth/internal/config"
+	"auth/internal/data"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
+	"slices"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// errTokenRevoked is returned for a token that was issued before the break
+// glass in force, or signed with a key it revoked.
+var errTokenRevoked = errors.New("token revoked by break glass")
+
+// errNoSigningKey is returned when a break glass has revoked the configured
+// secret and JWT_BREAK_GLASS_SECRET, which its key is derived from, is not
+// set.
+var errNoSigningKey = errors.New("no signing key: the access secret is revoked and no break glass secret is configured")
+
+// scopePasswordChange marks the restricted token handed out by login when
+// the user's password has expired. It has no session and is only accepted
+// by change_password.
+const scopePasswordChange = "password_change"
+
 type AccessToken struct {
 	UserID    string `json:"user_id"`
 	Email     string `json:"email"`
 	Username  string `json:"username"`
 	SessionID string `json:"session_id"`
+	// Scope restricts what the token may be used for. It is empty for the
+	// tokens of a session, which may be used for anything.
+	Scope string `json:"scope,omitempty"`
+	// TokenVersion is the user's token version when the token was issued;
+	// the token is stale once the user's version has moved on.
+	TokenVersion int `json:"token_version"`
 	jwt.RegisteredClaims
 }
 
-func (app *application) generateAccessToken(userID string, email string, username string, sessionID string) (string, error) {
-	claims := &AccessToken{
-		UserID:    userID,
-		Email:     email,
-		Username:  username,
-		SessionID: sessionID,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(10 * time.Minute)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			NotBefore: jwt.NewNumericDate(time.Now()),
-			Issuer:    "auth-service",
-			Subject:   userID,
-			Audience:  []string{"task-flow"},
-		},
+func (app *application) generateAccessToken(cfg *config.Config, user *data.User, sessionID string) (string, error) {
+	return app.signAccessToken(cfg, &AccessToken{
+		UserID:       user.ID,
+		Email:        user.Email,
+		Username:     user.Username,
+		SessionID:    sessionID,
+		TokenVersion: user.TokenVersion,
+	})
+}
+
+// generatePasswordChangeToken returns a token that lets the user, whose
+// password has expired, change it and nothing else.
+func (app *application) generatePasswordChangeToken(cfg *config.Config, user *data.User) (string, error) {
+	return app.signAccessToken(cfg, &AccessToken{
+		UserID:       user.ID,
+		Email:        user.Email,
+		Username:     user.Username,
+		Scope:        scopePasswordChange,
+		TokenVersion: user.TokenVersion,
+	})
+}
+
+// signAccessToken fills in the registered claims of claims and signs it
+// with the current secret.
+func (app *application) signAccessToken(cfg *config.Config, claims *AccessToken) (string, error) {
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(cfg.JWT.AccessTTL)),
+		IssuedAt:  jwt.NewNumericDate(time.Now()),
+		NotBefore: jwt.NewNumericDate(time.Now()),
+		Issuer:    "auth-service",
+		Subject:   claims.UserID,
+		Audience:  []string{"task-flow"},
 	}
 
+	secret := app.signingSecret(cfg)
+	if secret == "" {
+		return "", errNoSigningKey
+	}
 	token := jwt.NewWithClaims(jwt.SigningMethodHS512, claims)
+	token.Header["kid"] = keyID(secret)
 
-	return token.SignedString([]byte(app.jwtAccessSecret))
+	return token.SignedString([]byte(secret))
 }
 
-func (app *application) validateAccessToken(tokenString string) (*AccessToken, error) {
+func (app *application) validateAccessToken(cfg *config.Config, tokenString string) (*AccessToken, error) {
+	bg := app.breakGlass.Load()
+
 	token, err := jwt.ParseWithClaims(tokenString, &AccessToken{}, func(token *jwt.Token) (any, error) {
-		return []byte(app.jwtAccessSecret), nil
-	})
+		kid, ok := token.Header["kid"].(string)
+		if !ok {
+			kid = keyID(cfg.JWT.AccessSecret)
+		}
+		if bg != nil && slices.Contains(bg.RevokedKeyIDs, kid) {
+			return nil, errTokenRevoked
+		}
+
+		for _, secret := range app.verificationSecrets(cfg) {
+			if keyID(secret) == kid {
+				return []byte(secret), nil
+			}
+		}
+		return nil, errors.New("unknown signing key")
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS512.Alg()}))
 
 	if err != nil {
 		return nil, err
 	}
 
 	if claims, ok := token.Claims.(*AccessToken); ok && token.Valid {
+		// Token timestamps are whole seconds, so a token issued in the
+		// second the break glass happened is let through; it can only have
+		// been signed with the new key.
+		if bg != nil && (claims.IssuedAt == nil || claims.IssuedAt.Before(bg.NotBefore.Truncate(time.Second))) {
+			return nil, errTokenRevoked
+		}
 		return claims, nil
 	}
 
 	return nil, errors.New("invalid token")
 }
 
+// signingSecret returns the secret new tokens are signed with: the
+// configured one, unless a break glass has revoked it and no new one has
+// been configured since, in which case the key of that break glass.
+func (app *application) signingSecret(cfg *config.Config) string {
+	if bg := app.breakGlass.Load(); bg != nil && slices.Contains(bg.RevokedKeyIDs, keyID(cfg.JWT.AccessSecret)) {
+		return breakGlassKey(cfg, bg)
+	}
+	return cfg.JWT.AccessSecret
+}
+
+// verificationSecrets returns every secret a token may have been signed
+// with, including ones revoked by a break glass.
+func (app *application) verificationSecrets(cfg *config.Config) []string {
+	secrets := append([]string{cfg.JWT.AccessSecret}, cfg.JWT.PreviousSecrets...)
+	if bg := app.breakGlass.Load(); bg != nil {
+		if key := breakGlassKey(cfg, bg); key != "" {
+			secrets = append(secrets, key)
+		}
+	}
+	return secrets
+}
+
+// breakGlassKey derives the signing key of bg from the break glass secret
+// of cfg and the salt stored with bg, so that reading the break_glass
+// table is not enough to sign tokens. It is empty while no break glass
+// secret is configured.
+func breakGlassKey(cfg *config.Config, bg *data.BreakGlass) string {
+	if cfg.JWT.BreakGlassSecret == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, []byte(cfg.JWT.BreakGlassSecret))
+	mac.Write([]byte("break_glass"))
+	mac.Write([]byte{0})
+	mac.Write([]byte(bg.SigningKeySalt))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// keyID identifies a signing secret in the token header without revealing
+// it, so that tokens signed before a rotation can still be verified.
+func keyID(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:8])
+}
+
 func (app *application) generateOpaqueToken() (string, error) {
 	bytes := make([]byte, 32)
 	if _, err := rand.Read(bytes); err != nil {
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"auth/internal/data"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestAccessTokenSecretRotation(t *testing.T) {
+	old := *app.config.Load()
+
+	token, err := app.generateAccessToken(&old, &data.User{ID: "user-id", Email: "test@mail.com", Username: "tester"}, "session-id")
+	if err != nil {
+		t.Fatalf("failed to generate access token: %v", err)
+	}
+
+	rotated := old
+	rotated.JWT.AccessSecret = strings.ToUpper(old.JWT.AccessSecret)
+	rotated.JWT.PreviousSecrets = []string{old.JWT.AccessSecret}
+
+	if _, err := app.validateAccessToken(&rotated, token); err != nil {
+		t.Errorf("expected token signed with the previous secret to be valid: %v", err)
+	}
+
+	rotated.JWT.PreviousSecrets = nil
+	if _, err := app.validateAccessToken(&rotated, token); err == nil {
+		t.Error("expected token signed with a retired secret to be rejected")
+	}
+}
+
+func TestUnknownSigningKey(t *testing.T) {
+	cfg := app.config.Load()
+	sign := func(kid, secret string) string {
+		t.Helper()
+		token := jwt.NewWithClaims(jwt.SigningMethodHS512, &AccessToken{
+			UserID: "user-id",
+			RegisteredClaims: jwt.RegisteredClaims{
+				IssuedAt:  jwt.NewNumericDate(time.Now()),
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			},
+		})
+		token.Header["kid"] = kid
+		signed, err := token.SignedString([]byte(secret))
+		if err != nil {
+			t.Fatalf("failed to sign token: %v", err)
+		}
+		return signed
+	}
+
+	retired := "retired-secret-ensure-32-bytes-long-string!"
+	runTests(t, "auth.v1.validate", []Test{
+		{
+			name:    "fail - signed with a secret that is no longer known",
+			payload: []byte(fmt.Sprintf(`{"access_token": "%s"}`, sign(keyID(retired), retired))),
+			want:    http.StatusUnauthorized,
+			code:    data.CodeTokenInvalid,
+		},
+		{
+			name:    "fail - known key id with a forged signature",
+			payload: []byte(fmt.Sprintf(`{"access_token": "%s"}`, sign(keyID(cfg.JWT.AccessSecret), retired))),
+			want:    http.StatusUnauthorized,
+			code:    data.CodeTokenInvalid,
+		},
+	})
+}
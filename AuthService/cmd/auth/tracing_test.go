@@ -0,0 +1,26 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+func TestTraceContextPropagation(t *testing.T) {
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+
+	msg := nats.NewMsg("auth.v1.healthcheck")
+	msg.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+
+	reply, err := app.nc.RequestMsg(msg, 2*time.Second)
+	if err != nil {
+		t.Fatalf("failed to get response from auth.v1.healthcheck: %v", err)
+	}
+
+	got := reply.Header.Get("traceparent")
+	if !strings.Contains(got, traceID) {
+		t.Errorf("got traceparent %q, want it to continue trace %s", got, traceID)
+	}
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"auth/internal/data"
+	"auth/internal/passhash"
+	"context"
 	"encoding/json"
 	"net/http"
 	"testing"
@@ -9,24 +11,29 @@ import (
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
 )
 
 type Test struct {
 	name    string
 	payload []byte
 	want    int
+	code    string
 }
 
 var emptyJSON = Test{
 	name:    "fail - empty json",
 	payload: []byte(`{}`),
 	want:    http.StatusUnprocessableEntity,
+	code:    data.CodeValidationFailed,
 }
 
 var malformedJSON = Test{
 	name:    "fail - malformed json",
 	payload: []byte(`not json`),
 	want:    http.StatusUnprocessableEntity,
+	code:    data.CodeMalformedRequest,
 }
 
 func runTests(t *testing.T, subj string, tests []Test) {
@@ -46,7 +53,10 @@ func runTests(t *testing.T, subj string, tests []Test) {
 			}
 
 			if r.StatusCode != ts.want {
-				t.Errorf("got %d want %d, %v", r.StatusCode, ts.want, r.Data)
+				t.Errorf("got %d want %d, %s %s %v", r.StatusCode, ts.want, r.Code, r.Message, r.Details)
+			}
+			if ts.code != "" && r.Code != ts.code {
+				t.Errorf("got code %q want %q", r.Code, ts.code)
 			}
 		})
 	}
@@ -59,11 +69,11 @@ func createTestUser(t *testing.T) *data.User {
 		Email:    "test@mail.com",
 		Username: "tester",
 	}
-	err := user.Password.Set("12345678")
+	err := user.Password.Set("12345678", newHasher(app.config.Load().Password), passhash.NewPepper(app.config.Load().Password.Pepper))
 	if err != nil {
 		t.Fatalf("failed to set user password: %v", err)
 	}
-	err = app.models.UserModel.Insert(user)
+	err = app.models.UserModel.Insert(context.Background(), user)
 	if err != nil {
 		t.Fatalf("failed to insert user in db: %v", err)
 	}
@@ -81,7 +91,7 @@ func createTestSession(t *testing.T, userID string, hash []byte, expiresAt time.
 		CreatedAt: time.Now().Add(-2 * time.Hour),
 		ExpiresAt: expiresAt,
 	}
-	err := app.models.SessionModel.Insert(session)
+	err := app.models.SessionModel.Insert(context.Background(), session)
 	if err != nil {
 		t.Fatalf("failed to insert session in db:, %v", err)
 	}
@@ -101,7 +111,7 @@ func generateExpiredTokenForTest(userID string, email string, username string, s
 		},
 	}
 	token := jwt.NewWithClaims(jwt.SigningMethodHS512, claims)
-	return token.SignedString([]byte(app.jwtAccessSecret))
+	return token.SignedString([]byte(app.config.Load().JWT.AccessSecret))
 }
 
 func generateOpaqueTokenForTest(t *testing.T) string {
@@ -112,3 +122,39 @@ func generateOpaqueTokenForTest(t *testing.T) string {
 	}
 	return token
 }
+
+// fakeRequest is a micro.Request for calling handlers directly. It keeps
+// the response sent to it and its headers.
+type fakeRequest struct {
+	subject  string
+	data     []byte
+	headers  micro.Headers
+	response []byte
+	header   nats.Header
+}
+
+func (r *fakeRequest) Respond(response []byte, opts ...micro.RespondOpt) error {
+	msg := nats.NewMsg(r.subject)
+	for _, opt := range opts {
+		opt(msg)
+	}
+	r.response, r.header = response, msg.Header
+	return nil
+}
+
+func (r *fakeRequest) RespondJSON(v any, opts ...micro.RespondOpt) error {
+	response, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return r.Respond(response, opts...)
+}
+
+func (r *fakeRequest) Error(_, _ string, response []byte, opts ...micro.RespondOpt) error {
+	return r.Respond(response, opts...)
+}
+
+func (r *fakeRequest) Data() []byte           { return r.data }
+func (r *fakeRequest) Headers() micro.Headers { return r.headers }
+func (r *fakeRequest) Subject() string        { return r.subject }
+func (r *fakeRequest) Reply() string          { return "" }
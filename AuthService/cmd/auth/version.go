@@ -0,0 +1,92 @@
+package main
+
+import (
+	"auth/internal/data"
+	"context"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats.go/micro"
+)
+
+// versionHeader carries the API version of a request and of its response.
+// Requests on versioned subjects (auth.v1.*) need not set it; requests on the
+// legacy auth.* subjects use it to pick the version they were written for.
+const versionHeader = "Auth-Version"
+
+// legacyVersion is the API version the legacy subjects speak when the
+// request does not ask for one: the payloads they have always accepted.
+const legacyVersion = "v1"
+
+// legacyEndpoints are the endpoints that were served on unversioned auth.*
+// subjects before versioning. Only they keep an unversioned alias; later
+// endpoints are only served on versioned subjects.
+var legacyEndpoints = []string{"healthcheck", "register", "login", "validate", "refresh", "logout"}
+
+// deprecationLogInterval limits how often a legacy subject is reported, so a
+// busy client does not flood the logs.
+const deprecationLogInterval = time.Minute
+
+type contextKey string
+
+const apiVersionContextKey = contextKey("apiVersion")
+
+func contextWithAPIVersion(ctx context.Context, version string) context.Context {
+	return context.WithValue(ctx, apiVersionContextKey, version)
+}
+
+func apiVersionFromContext(ctx context.Context) string {
+	version, _ := ctx.Value(apiVersionContextKey).(string)
+	return version
+}
+
+// parseAPIVersion normalises the value of the version header; "1" and "v1"
+// are the same version, and an empty header means legacyVersion.
+func parseAPIVersion(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	switch {
+	case s == "":
+		return legacyVersion
+	case !strings.HasPrefix(s, "v"):
+		return "v" + s
+	}
+	return s
+}
+
+// versioned serves handler as the given API version.
+func versioned(version string, handler func(context.Context, micro.Request)) func(context.Context, micro.Request) {
+	return func(ctx context.Context, req micro.Request) {
+		handler(contextWithAPIVersion(ctx, version), req)
+	}
+}
+
+// legacy serves a deprecated unversioned subject with the handler of the
+// version named in the request header. Every use of the subject is counted
+// and logged so the remaining callers can be found and migrated to
+// replacedBy.
+func (app *application) legacy(replacedBy string, handlers map[string]func(context.Context, micro.Request)) func(context.Context, micro.Request) {
+	var lastLogged atomic.Int64
+
+	return func(ctx context.Context, req micro.Request) {
+		app.metrics.deprecatedRequests.WithLabelValues(req.Subject()).Inc()
+
+		now := time.Now().UnixNano()
+		last := lastLogged.Load()
+		if (last == 0 || now-last >= int64(deprecationLogInterval)) && lastLogged.CompareAndSwap(last, now) {
+			app.logger.Warn("deprecated subject in use",
+				slog.String("subject", req.Subject()),
+				slog.String("replaced_by", replacedBy))
+		}
+
+		version := parseAPIVersion(req.Headers().Get(versionHeader))
+		handler, ok := handlers[version]
+		if !ok {
+			app.sendErrorResponse(ctx, req, http.StatusBadRequest, data.CodeUnsupportedVersion)
+			return
+		}
+		handler(contextWithAPIVersion(ctx, version), req)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import "sync"
+
+// lane is a fixed pool of workers fed by a bounded queue. Requests are
+// queued from the NATS subscription callbacks, which return straight away,
+// so a burst never backs up into the client's pending buffers where it would
+// surface as slow-consumer errors.
+type lane struct {
+	name string
+	jobs chan func()
+	wg   sync.WaitGroup
+
+	// mu guards stopped, so that a job submitted while the lane stops is
+	// turned away instead of sent on the closed channel.
+	mu      sync.RWMutex
+	stopped bool
+}
+
+// newLane starts workers goroutines that run the jobs submitted to the
+// lane. Up to queue jobs wait for a free worker; with a queue of 0 a job is
+// only accepted when a worker is idle.
+func newLane(name string, workers, queue int) *lane {
+	l := &lane{
+		name: name,
+		jobs: make(chan func(), queue),
+	}
+
+	l.wg.Add(workers)
+	for range workers {
+		go func() {
+			defer l.wg.Done()
+			for job := range l.jobs {
+				job()
+			}
+		}()
+	}
+	return l
+}
+
+// submit queues job and reports whether there was room for it. Nothing is
+// accepted once the lane has been stopped.
+func (l *lane) submit(job func()) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.stopped {
+		return false
+	}
+
+	select {
+	case l.jobs <- job:
+		return true
+	default:
+		return false
+	}
+}
+
+// stop lets the workers finish the queued jobs and waits for them to exit.
+// Jobs submitted afterwards are rejected.
+func (l *lane) stop() {
+	l.mu.Lock()
+	if !l.stopped {
+		l.stopped = true
+		close(l.jobs)
+	}
+	l.mu.Unlock()
+	l.wg.Wait()
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"sync/atomic"
+	"testing"
+)
+
+func TestLaneShedsWhenFull(t *testing.T) {
+	l := newLane("test", 1, 1)
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	var ran atomic.Int32
+
+	if !l.submit(func() { close(started); <-release; ran.Add(1) }) {
+		t.Fatal("expected the first job to be accepted")
+	}
+	<-started
+
+	if !l.submit(func() { ran.Add(1) }) {
+		t.Fatal("expected the second job to wait in the queue")
+	}
+	if l.submit(func() { ran.Add(1) }) {
+		t.Error("expected the third job to be rejected while the queue is full")
+	}
+
+	close(release)
+	l.stop()
+
+	if got := ran.Load(); got != 2 {
+		t.Errorf("got %d jobs run want 2", got)
+	}
+}
+
+func TestLaneRejectsAfterStop(t *testing.T) {
+	l := newLane("test", 1, 1)
+	l.stop()
+
+	if l.submit(func() { t.Error("expected the job not to run") }) {
+		t.Error("expected a job submitted after stop to be rejected")
+	}
+	// Stopping twice must not close the queue twice.
+	l.stop()
+}
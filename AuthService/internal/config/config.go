@@ -0,0 +1,633 @@
+package config
+
+import (
+	"auth/internal/i18n"
+	"auth/internal/validator"
+	"cmp"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds every setting of the auth service. Values are resolved in
+// this order, later sources winning: Default, the config file and the
+// environment, where every variable may instead be given as a <VAR>_FILE
+// secret (as mounted by Docker).
+type Config struct {
+	DB              DB            `yaml:"db" toml:"db"`
+	NATS            NATS          `yaml:"nats" toml:"nats"`
+	HTTP            HTTP          `yaml:"http" toml:"http"`
+	JWT             JWT           `yaml:"jwt" toml:"jwt"`
+	Session         Session       `yaml:"session" toml:"session"`
+	Password        Password      `yaml:"password" toml:"password"`
+	Idempotency     Idempotency   `yaml:"idempotency" toml:"idempotency"`
+	Workers         Workers       `yaml:"workers" toml:"workers"`
+	Admin           Admin         `yaml:"admin" toml:"admin"`
+	GeoIP           GeoIP         `yaml:"geoip" toml:"geoip"`
+	LoginAlerts     LoginAlerts   `yaml:"login_alerts" toml:"login_alerts"`
+	Tracing         Tracing       `yaml:"tracing" toml:"tracing"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout" env:"AUTH_SHUTDOWN_TIMEOUT"`
+	// RequestTimeout bounds the handling of every request. Callers may ask
+	// for less with the Request-Deadline and Request-Timeout headers.
+	RequestTimeout time.Duration `yaml:"request_timeout" toml:"request_timeout" env:"AUTH_REQUEST_TIMEOUT"`
+	WatchConfig    bool          `yaml:"watch_config" toml:"watch_config" env:"AUTH_CONFIG_WATCH"`
+	// DefaultLocale is the language of replies to requests that do not ask
+	// for one, or ask only for unsupported ones.
+	DefaultLocale string `yaml:"default_locale" toml:"default_locale" env:"AUTH_DEFAULT_LOCALE"`
+}
+
+type DB struct {
+	DSN string `yaml:"dsn" toml:"dsn" env:"AUTH_DB_DSN" secret:"true"`
+}
+
+type NATS struct {
+	URL           string        `yaml:"url" toml:"url" env:"NATS_URL"`
+	MaxReconnect  int           `yaml:"max_reconnect" toml:"max_reconnect" env:"NATS_MAX_RECONNECT"`
+	ReconnectWait time.Duration `yaml:"reconnect_wait" toml:"reconnect_wait" env:"NATS_RECONNECT_WAIT"`
+	Timeout       time.Duration `yaml:"timeout" toml:"timeout" env:"NATS_TIMEOUT"`
+}
+
+// HTTP configures the operational HTTP server (metrics). An empty Addr
+// disables it.
+type HTTP struct {
+	Addr string `yaml:"addr" toml:"addr" env:"AUTH_HTTP_ADDR"`
+}
+
+type JWT struct {
+	AccessSecret string `yaml:"access_secret" toml:"access_secret" env:"JWT_ACCESS_SECRET" secret:"true"`
+	// PreviousSecrets are still accepted when verifying tokens, so that
+	// tokens issued before a secret rotation stay valid until they expire.
+	PreviousSecrets []string      `yaml:"previous_secrets" toml:"previous_secrets" env:"JWT_PREVIOUS_SECRETS" secret:"true"`
+	AccessTTL       time.Duration `yaml:"access_ttl" toml:"access_ttl" env:"JWT_ACCESS_TTL"`
+	// BreakGlassSecret derives the key tokens are signed with after a break
+	// glass has revoked AccessSecret, so that the key itself is never
+	// stored. It is required along with the admin token.
+	BreakGlassSecret string `yaml:"break_glass_secret" toml:"break_glass_secret" env:"JWT_BREAK_GLASS_SECRET" secret:"true"`
+}
+
+// Session bounds how long sessions last. TTL and RememberMeTTL are idle
+// timeouts: a session expires once it goes that long without a refresh,
+// and every refresh extends it. MaxLifetime and RememberMeMaxLifetime are
+// counted from login and never extended.
+type Session struct {
+	SessionLimits `yaml:",inline"`
+	// Devices overrides the limits above for a device type (desktop,
+	// mobile or tablet). Zero fields keep the defaults.
+	Devices map[string]SessionLimits `yaml:"devices" toml:"devices"`
+
+	// MaxSessions is how many live sessions a user may have at once, unless
+	// RoleMaxSessions has an entry for the user's role. DeviceMaxSessions
+	// additionally bounds the sessions of one device type.
+	MaxSessions       int            `yaml:"max_sessions" toml:"max_sessions" env:"AUTH_MAX_SESSIONS"`
+	RoleMaxSessions   map[string]int `yaml:"role_max_sessions" toml:"role_max_sessions"`
+	DeviceMaxSessions map[string]int `yaml:"device_max_sessions" toml:"device_max_sessions"`
+	// OnLimit says what a login does when the user has no session to
+	// spare: SessionLimitRevokeOldest or SessionLimitReject.
+	OnLimit string `yaml:"on_limit" toml:"on_limit" env:"AUTH_SESSION_ON_LIMIT"`
+}
+
+const (
+	// SessionLimitRevokeOldest makes room for a new session by revoking the
+	// least recently used ones.
+	SessionLimitRevokeOldest = "revoke_oldest"
+	// SessionLimitReject refuses the login instead.
+	SessionLimitReject = "reject"
+)
+
+// MaxSessionsFor returns how many live sessions a user with role may have,
+// and how many of them may be on deviceType, 0 meaning no limit of its own.
+func (s Session) MaxSessionsFor(role, deviceType string) (total, device int) {
+	total = s.MaxSessions
+	if n, ok := s.RoleMaxSessions[role]; ok {
+		total = n
+	}
+	return total, s.DeviceMaxSessions[deviceType]
+}
+
+type SessionLimits struct {
+	TTL                   time.Duration `yaml:"ttl" toml:"ttl" env:"AUTH_SESSION_TTL"`
+	RememberMeTTL         time.Duration `yaml:"remember_me_ttl" toml:"remember_me_ttl" env:"AUTH_SESSION_REMEMBER_ME_TTL"`
+	MaxLifetime           time.Duration `yaml:"max_lifetime" toml:"max_lifetime" env:"AUTH_SESSION_MAX_LIFETIME"`
+	RememberMeMaxLifetime time.Duration `yaml:"remember_me_max_lifetime" toml:"remember_me_max_lifetime" env:"AUTH_SESSION_REMEMBER_ME_MAX_LIFETIME"`
+}
+
+// Limits returns the idle timeout and the maximum lifetime of a session
+// on deviceType, with or without remember me.
+func (s Session) Limits(deviceType string, rememberMe bool) (idle, lifetime time.Duration) {
+	limits := s.SessionLimits
+	if d, ok := s.Devices[deviceType]; ok {
+		limits.TTL = cmp.Or(d.TTL, limits.TTL)
+		limits.RememberMeTTL = cmp.Or(d.RememberMeTTL, limits.RememberMeTTL)
+		limits.MaxLifetime = cmp.Or(d.MaxLifetime, limits.MaxLifetime)
+		limits.RememberMeMaxLifetime = cmp.Or(d.RememberMeMaxLifetime, limits.RememberMeMaxLifetime)
+	}
+	if rememberMe {
+		return limits.RememberMeTTL, limits.RememberMeMaxLifetime
+	}
+	return limits.TTL, limits.MaxLifetime
+}
+
+// Password selects how new passwords are hashed. Hashes made with the
+// other algorithm, or with other parameters, still verify and are replaced
+// on the user's next successful login.
+type Password struct {
+	// Algorithm is "argon2id" or "bcrypt".
+	Algorithm  string `yaml:"algorithm" toml:"algorithm" env:"AUTH_PASSWORD_ALGORITHM"`
+	BcryptCost int    `yaml:"bcrypt_cost" toml:"bcrypt_cost" env:"AUTH_BCRYPT_COST"`
+	Argon2     Argon2 `yaml:"argon2" toml:"argon2"`
+	// Pepper, when set, is mixed into every new hash and kept out of the
+	// database. PreviousPeppers still verify the hashes made with them,
+	// which are moved to Pepper on the user's next successful login.
+	Pepper          string         `yaml:"pepper" toml:"pepper" env:"AUTH_PASSWORD_PEPPER" secret:"true"`
+	PreviousPeppers []string       `yaml:"previous_peppers" toml:"previous_peppers" env:"AUTH_PASSWORD_PREVIOUS_PEPPERS" secret:"true"`
+	Policy          PasswordPolicy `yaml:"policy" toml:"policy"`
+}
+
+// PasswordPolicy sets what new passwords must pass; see passpolicy.Policy.
+type PasswordPolicy struct {
+	// BreachedDir holds breached password hashes as HIBP range files named
+	// <PREFIX>.txt. Empty disables the breach check.
+	BreachedDir    string `yaml:"breached_dir" toml:"breached_dir" env:"AUTH_PASSWORD_BREACHED_DIR"`
+	MinBreachCount int    `yaml:"min_breach_count" toml:"min_breach_count" env:"AUTH_PASSWORD_MIN_BREACH_COUNT"`
+	// MinStrength is the lowest accepted strength score, 0 to 4.
+	MinStrength int `yaml:"min_strength" toml:"min_strength" env:"AUTH_PASSWORD_MIN_STRENGTH"`
+	// MaxSimilarity is the highest accepted similarity to the user's email
+	// and username, 0 to 1.
+	MaxSimilarity float64 `yaml:"max_similarity" toml:"max_similarity" env:"AUTH_PASSWORD_MAX_SIMILARITY"`
+	// History is how many of a user's most recent passwords, the current
+	// one included, a new password may not repeat. 0 allows any.
+	History int `yaml:"history" toml:"history" env:"AUTH_PASSWORD_HISTORY"`
+	// MaxAge is how long a password may be used before it must be changed.
+	// 0 never expires passwords.
+	MaxAge time.Duration `yaml:"max_age" toml:"max_age" env:"AUTH_PASSWORD_MAX_AGE"`
+}
+
+// Argon2 holds the Argon2id parameters. Memory is in KiB and, like
+// Parallelism, is needed again for every concurrent login.
+type Argon2 struct {
+	Memory      int `yaml:"memory" toml:"memory" env:"AUTH_ARGON2_MEMORY"`
+	Iterations  int `yaml:"iterations" toml:"iterations" env:"AUTH_ARGON2_ITERATIONS"`
+	Parallelism int `yaml:"parallelism" toml:"parallelism" env:"AUTH_ARGON2_PARALLELISM"`
+}
+
+// Idempotency configures the replay of responses to retried requests that
+// carry an Idempotency-Key header.
+type Idempotency struct {
+	// TTL is how long a response is kept and replayed for.
+	TTL time.Duration `yaml:"ttl" toml:"ttl" env:"AUTH_IDEMPOTENCY_TTL"`
+}
+
+// Admin guards the administrative endpoints, such as break_glass. They
+// are refused to everyone while Token is empty.
+type Admin struct {
+	// Token must be sent as "Authorization: Bearer <token>".
+	Token string `yaml:"token" toml:"token" env:"AUTH_ADMIN_TOKEN" secret:"true"`
+}
+
+// GeoIP locates the addresses sessions are created from. Database is the
+// path of a MaxMind DB file, such as GeoLite2-City.mmdb; sessions are not
+// located while it is empty.
+type GeoIP struct {
+	Database string `yaml:"database" toml:"database" env:"AUTH_GEOIP_DATABASE"`
+}
+
+// LoginAlerts configures the notification users get when they log in from
+// a device they have not used before, from a country they have not logged
+// in from, or from too far away from their previous login to have travelled
+// there since.
+type LoginAlerts struct {
+	Enabled bool `yaml:"enabled" toml:"enabled" env:"AUTH_LOGIN_ALERTS"`
+	// History is how many logins of each user are kept to compare new ones
+	// against.
+	History int `yaml:"history" toml:"history" env:"AUTH_LOGIN_ALERTS_HISTORY"`
+	// MaxTravelSpeed, in km/h, is the fastest a user is believed to move
+	// between two logins. Faster is flagged as impossible travel.
+	MaxTravelSpeed float64 `yaml:"max_travel_speed" toml:"max_travel_speed" env:"AUTH_LOGIN_ALERTS_MAX_TRAVEL_SPEED"`
+}
+
+// Workers sizes the worker pools requests are handled by. Hashing passwords
+// makes register and login far more expensive than the other endpoints, so
+// they run in a lane of their own and cannot starve token validation. A
+// request that finds its lane's queue full is rejected straight away with a
+// 503 rather than left to pile up in the NATS client.
+type Workers struct {
+	// Heavy handles register, login, change_password and break_glass; one
+	// worker per CPU keeps them from competing with each other for cores.
+	Heavy      int `yaml:"heavy" toml:"heavy" env:"AUTH_WORKERS_HEAVY"`
+	HeavyQueue int `yaml:"heavy_queue" toml:"heavy_queue" env:"AUTH_WORKERS_HEAVY_QUEUE"`
+	// Light handles every other endpoint, which mostly waits on Postgres.
+	Light      int `yaml:"light" toml:"light" env:"AUTH_WORKERS_LIGHT"`
+	LightQueue int `yaml:"light_queue" toml:"light_queue" env:"AUTH_WORKERS_LIGHT_QUEUE"`
+}
+
+// Tracing selects where OpenTelemetry spans are exported: "none", "stdout",
+// "file" (JSON lines written to File) or "otlp" (OTLP over HTTP to
+// Endpoint, or to the standard OTEL_EXPORTER_OTLP_* variables).
+type Tracing struct {
+	Exporter    string  `yaml:"exporter" toml:"exporter" env:"AUTH_TRACING_EXPORTER"`
+	File        string  `yaml:"file" toml:"file" env:"AUTH_TRACING_FILE"`
+	Endpoint    string  `yaml:"endpoint" toml:"endpoint" env:"AUTH_TRACING_ENDPOINT"`
+	SampleRatio float64 `yaml:"sample_ratio" toml:"sample_ratio" env:"AUTH_TRACING_SAMPLE_RATIO"`
+}
+
+// Default returns the configuration used when nothing overrides it.
+func Default() Config {
+	return Config{
+		NATS: NATS{
+			URL:           "nats://127.0.0.1:4222",
+			MaxReconnect:  5,
+			ReconnectWait: 5 * time.Second,
+			Timeout:       time.Second,
+		},
+		HTTP: HTTP{
+			Addr: ":8000",
+		},
+		JWT: JWT{
+			AccessTTL: 10 * time.Minute,
+		},
+		Session: Session{
+			SessionLimits: SessionLimits{
+				TTL:                   24 * time.Hour,
+				RememberMeTTL:         30 * 24 * time.Hour,
+				MaxLifetime:           7 * 24 * time.Hour,
+				RememberMeMaxLifetime: 90 * 24 * time.Hour,
+			},
+			MaxSessions: 5,
+			OnLimit:     SessionLimitRevokeOldest,
+		},
+		Password: Password{
+			Algorithm:  "argon2id",
+			BcryptCost: 12,
+			Argon2: Argon2{
+				Memory:      64 * 1024,
+				Iterations:  3,
+				Parallelism: 4,
+			},
+			Policy: PasswordPolicy{
+				MinBreachCount: 1,
+				MinStrength:    2,
+				MaxSimilarity:  0.5,
+			},
+		},
+		Idempotency: Idempotency{
+			TTL: 24 * time.Hour,
+		},
+		LoginAlerts: LoginAlerts{
+			Enabled:        true,
+			History:        100,
+			MaxTravelSpeed: 1000,
+		},
+		Workers: Workers{
+			Heavy:      runtime.NumCPU(),
+			HeavyQueue: 64,
+			Light:      32,
+			LightQueue: 1024,
+		},
+		Tracing: Tracing{
+			Exporter:    "none",
+			SampleRatio: 1,
+		},
+		ShutdownTimeout: 30 * time.Second,
+		RequestTimeout:  10 * time.Second,
+		DefaultLocale:   i18n.Fallback,
+	}
+}
+
+// Load builds the configuration from the file at path (skipped when path is
+// empty) and the environment, and validates the result.
+func Load(path string) (Config, error) {
+	cfg := Default()
+
+	if path != "" {
+		if err := loadFile(path, &cfg); err != nil {
+			return Config{}, err
+		}
+	}
+
+	if err := loadEnv(reflect.ValueOf(&cfg).Elem()); err != nil {
+		return Config{}, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
+	}
+
+	return cfg, nil
+}
+
+// Validate reports every invalid setting at once.
+func (c Config) Validate() error {
+	v := validator.New()
+
+	v.Check(c.DB.DSN != "", "db.dsn", "required")
+	v.Check(c.NATS.URL != "", "nats.url", "required")
+	v.Check(c.NATS.MaxReconnect >= -1, "nats.max_reconnect", "min", -1)
+	v.Check(c.NATS.ReconnectWait > 0, "nats.reconnect_wait", "gt", 0)
+	v.Check(c.NATS.Timeout > 0, "nats.timeout", "gt", 0)
+	v.Check(len(c.JWT.AccessSecret) >= 32, "jwt.access_secret", "min_bytes", 32)
+	for _, secret := range c.JWT.PreviousSecrets {
+		v.Check(len(secret) >= 32, "jwt.previous_secrets", "min_bytes", 32)
+	}
+	v.Check(c.JWT.AccessTTL > 0, "jwt.access_ttl", "gt", 0)
+	v.Check(c.Session.TTL > 0, "session.ttl", "gt", 0)
+	v.Check(c.Session.RememberMeTTL >= c.Session.TTL, "session.remember_me_ttl", "not_less_than", "session.ttl")
+	for _, deviceType := range slices.Sorted(maps.Keys(c.Session.Devices)) {
+		key := "session.devices." + deviceType
+		v.Check(slices.Contains([]string{"desktop", "mobile", "tablet"}, deviceType), key, "oneof", "desktop, mobile, tablet")
+
+		d := c.Session.Devices[deviceType]
+		v.Check(d.TTL >= 0 && d.RememberMeTTL >= 0 && d.MaxLifetime >= 0 && d.RememberMeMaxLifetime >= 0, key, "min", 0)
+	}
+	for _, deviceType := range []string{"", "desktop", "mobile", "tablet"} {
+		key := "session"
+		if _, ok := c.Session.Devices[deviceType]; ok {
+			key += ".devices." + deviceType
+		}
+		idle, lifetime := c.Session.Limits(deviceType, false)
+		v.Check(lifetime >= idle, key+".max_lifetime", "not_less_than", "ttl")
+		idle, lifetime = c.Session.Limits(deviceType, true)
+		v.Check(lifetime >= idle, key+".remember_me_max_lifetime", "not_less_than", "remember_me_ttl")
+	}
+	v.Check(c.Session.MaxSessions >= 1, "session.max_sessions", "min", 1)
+	for _, role := range slices.Sorted(maps.Keys(c.Session.RoleMaxSessions)) {
+		v.Check(c.Session.RoleMaxSessions[role] >= 1, "session.role_max_sessions."+role, "min", 1)
+	}
+	for _, deviceType := range slices.Sorted(maps.Keys(c.Session.DeviceMaxSessions)) {
+		key := "session.device_max_sessions." + deviceType
+		v.Check(slices.Contains([]string{"desktop", "mobile", "tablet"}, deviceType), key, "oneof", "desktop, mobile, tablet")
+		v.Check(c.Session.DeviceMaxSessions[deviceType] >= 1, key, "min", 1)
+	}
+	v.Check(slices.Contains([]string{SessionLimitRevokeOldest, SessionLimitReject}, c.Session.OnLimit), "session.on_limit", "oneof", "revoke_oldest, reject")
+	v.Check(slices.Contains([]string{"argon2id", "bcrypt"}, c.Password.Algorithm), "password.algorithm", "oneof", "argon2id, bcrypt")
+	v.Check(c.Password.BcryptCost >= 4 && c.Password.BcryptCost <= 31, "password.bcrypt_cost", "between", 4, 31)
+	v.Check(c.Password.Argon2.Parallelism >= 1 && c.Password.Argon2.Parallelism <= 255, "password.argon2.parallelism", "between", 1, 255)
+	v.Check(c.Password.Argon2.Iterations >= 1, "password.argon2.iterations", "min", 1)
+	v.Check(c.Password.Argon2.Memory >= 8*c.Password.Argon2.Parallelism && c.Password.Argon2.Memory <= math.MaxUint32,
+		"password.argon2.memory", "between", 8*c.Password.Argon2.Parallelism, uint32(math.MaxUint32))
+	v.Check(c.Password.Pepper == "" || len(c.Password.Pepper) >= 32, "password.pepper", "min_bytes", 32)
+	for _, pepper := range c.Password.PreviousPeppers {
+		v.Check(len(pepper) >= 32, "password.previous_peppers", "min_bytes", 32)
+	}
+	v.Check(c.Password.Policy.BreachedDir == "" || isDir(c.Password.Policy.BreachedDir), "password.policy.breached_dir", "dir")
+	v.Check(c.Password.Policy.MinBreachCount >= 1, "password.policy.min_breach_count", "min", 1)
+	v.Check(c.Password.Policy.MinStrength >= 0 && c.Password.Policy.MinStrength <= 4, "password.policy.min_strength", "between", 0, 4)
+	v.Check(c.Password.Policy.MaxSimilarity >= 0 && c.Password.Policy.MaxSimilarity <= 1, "password.policy.max_similarity", "between", 0, 1)
+	v.Check(c.Password.Policy.History >= 0, "password.policy.history", "min", 0)
+	v.Check(c.Password.Policy.MaxAge >= 0, "password.policy.max_age", "min", 0)
+	v.Check(c.Idempotency.TTL > 0, "idempotency.ttl", "gt", 0)
+	v.Check(c.Admin.Token == "" || len(c.Admin.Token) >= 32, "admin.token", "min_bytes", 32)
+	v.Check(c.Admin.Token == "" || len(c.JWT.BreakGlassSecret) >= 32, "jwt.break_glass_secret", "min_bytes", 32)
+	v.Check(c.LoginAlerts.History >= 1, "login_alerts.history", "min", 1)
+	v.Check(c.LoginAlerts.MaxTravelSpeed > 0, "login_alerts.max_travel_speed", "gt", 0)
+	v.Check(c.Workers.Heavy >= 1, "workers.heavy", "min", 1)
+	v.Check(c.Workers.HeavyQueue >= 0, "workers.heavy_queue", "min", 0)
+	v.Check(c.Workers.Light >= 1, "workers.light", "min", 1)
+	v.Check(c.Workers.LightQueue >= 0, "workers.light_queue", "min", 0)
+	v.Check(slices.Contains([]string{"none", "stdout", "file", "otlp"}, c.Tracing.Exporter), "tracing.exporter", "oneof", "none, stdout, file, otlp")
+	v.Check(c.Tracing.Exporter != "file" || c.Tracing.File != "", "tracing.file", "required")
+	v.Check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1, "tracing.sample_ratio", "between", 0, 1)
+	v.Check(c.ShutdownTimeout > 0, "shutdown_timeout", "gt", 0)
+	v.Check(c.RequestTimeout > 0, "request_timeout", "gt", 0)
+	v.Check(i18n.IsSupported(c.DefaultLocale), "default_locale", "oneof", strings.Join(i18n.Supported(), ", "))
+
+	if v.Valid() {
+		return nil
+	}
+
+	keys := make([]string, 0, len(v.Errors))
+	for k := range v.Errors {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	messages := i18n.New(nil, i18n.Fallback)
+	errs := make([]error, 0, len(keys))
+	for _, k := range keys {
+		e := v.Errors[k]
+		errs = append(errs, fmt.Errorf("%s %s", k, messages.T("validation."+e.Rule, e.Args...)))
+	}
+	return fmt.Errorf("invalid configuration: %w", errors.Join(errs...))
+}
+
+// ApplyReload returns next with the settings that cannot change while the
+// service is running copied over from current, along with the names of the
+// settings that were kept for that reason.
+func ApplyReload(current, next Config) (Config, []string) {
+	var kept []string
+
+	if next.DB != current.DB {
+		kept = append(kept, "db")
+		next.DB = current.DB
+	}
+	if next.NATS != current.NATS {
+		kept = append(kept, "nats")
+		next.NATS = current.NATS
+	}
+	if next.HTTP != current.HTTP {
+		kept = append(kept, "http")
+		next.HTTP = current.HTTP
+	}
+	if next.Workers != current.Workers {
+		kept = append(kept, "workers")
+		next.Workers = current.Workers
+	}
+	if next.Tracing != current.Tracing {
+		kept = append(kept, "tracing")
+		next.Tracing = current.Tracing
+	}
+	if next.WatchConfig != current.WatchConfig {
+		kept = append(kept, "watch_config")
+		next.WatchConfig = current.WatchConfig
+	}
+
+	return next, kept
+}
+
+// Redacted returns a copy of c with every secret field masked, suitable for
+// printing or logging.
+func (c Config) Redacted() Config {
+	redact(reflect.ValueOf(&c).Elem())
+	return c
+}
+
+// String renders the redacted configuration as YAML.
+func (c Config) String() string {
+	out, err := yaml.Marshal(c.Redacted())
+	if err != nil {
+		return fmt.Sprintf("<unprintable config: %v>", err)
+	}
+	return string(out)
+}
+
+// LogValue makes sure loggers only ever see the redacted configuration.
+func (c Config) LogValue() slog.Value {
+	return slog.StringValue(c.String())
+}
+
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+func loadFile(path string, cfg *Config) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read config file: %w", err)
+	}
+
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, cfg)
+	case ".toml":
+		err = toml.Unmarshal(content, cfg)
+	default:
+		return fmt.Errorf("unsupported config file extension %q", ext)
+	}
+	if err != nil {
+		return fmt.Errorf("parse config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// loadEnv walks v and overrides every field carrying an env tag with the
+// value of that variable, or with the content of the file named by
+// <VAR>_FILE.
+func loadEnv(v reflect.Value) error {
+	t := v.Type()
+	for i := range t.NumField() {
+		field, value := t.Field(i), v.Field(i)
+
+		if field.Type.Kind() == reflect.Struct && field.Type != reflect.TypeFor[time.Duration]() {
+			if err := loadEnv(value); err != nil {
+				return err
+			}
+			continue
+		}
+
+		name := field.Tag.Get("env")
+		if name == "" {
+			continue
+		}
+
+		raw, ok, err := lookupEnv(name)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+
+		if err := setField(value, raw); err != nil {
+			return fmt.Errorf("env %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func lookupEnv(name string) (string, bool, error) {
+	value, ok := os.LookupEnv(name)
+	file, fileOK := os.LookupEnv(name + "_FILE")
+
+	switch {
+	case ok && fileOK:
+		return "", false, fmt.Errorf("both %s and %s_FILE are set", name, name)
+	case fileOK:
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return "", false, fmt.Errorf("read %s_FILE: %w", name, err)
+		}
+		return strings.TrimRight(string(content), "\r\n"), true, nil
+	default:
+		return value, ok, nil
+	}
+}
+
+func setField(v reflect.Value, raw string) error {
+	if v.Type() == reflect.TypeFor[time.Duration]() {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(n))
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported field type %s", v.Type())
+		}
+		var items []string
+		for item := range strings.SplitSeq(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported field type %s", v.Type())
+	}
+	return nil
+}
+
+func redact(v reflect.Value) {
+	t := v.Type()
+	for i := range t.NumField() {
+		field, value := t.Field(i), v.Field(i)
+
+		if field.Type.Kind() == reflect.Struct {
+			redact(value)
+			continue
+		}
+
+		if field.Tag.Get("secret") != "true" {
+			continue
+		}
+
+		switch value.Kind() {
+		case reflect.String:
+			if value.String() != "" {
+				value.SetString("[REDACTED]")
+			}
+		case reflect.Slice:
+			redacted := make([]string, value.Len())
+			for i := range redacted {
+				redacted[i] = "[REDACTED]"
+			}
+			value.Set(reflect.ValueOf(redacted))
+		}
+	}
+}
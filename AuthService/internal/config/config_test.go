@@ -0,0 +1,206 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSecret = "test-secret-ensure-32-bytes-long-string!"
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write %s: %v", name, err)
+	}
+	return path
+}
+
+func TestLoadSources(t *testing.T) {
+	yamlFile := writeFile(t, "auth.yaml", `
+db:
+  dsn: postgres://file
+jwt:
+  access_secret: `+testSecret+`
+  access_ttl: 5m
+session:
+  max_sessions: 3
+`)
+	tomlFile := writeFile(t, "auth.toml", `
+[db]
+dsn = "postgres://file"
+
+[jwt]
+access_secret = "`+testSecret+`"
+access_ttl = "5m"
+
+[session]
+max_sessions = 3
+`)
+
+	for _, path := range []string{yamlFile, tomlFile} {
+		t.Run(filepath.Ext(path), func(t *testing.T) {
+			t.Setenv("AUTH_BCRYPT_COST", "10")
+
+			cfg, err := Load(path)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if cfg.DB.DSN != "postgres://file" {
+				t.Errorf("got dsn %q want %q", cfg.DB.DSN, "postgres://file")
+			}
+			if cfg.JWT.AccessTTL != 5*time.Minute {
+				t.Errorf("got access ttl %s want %s", cfg.JWT.AccessTTL, 5*time.Minute)
+			}
+			if cfg.Session.MaxSessions != 3 {
+				t.Errorf("got max sessions %d want %d", cfg.Session.MaxSessions, 3)
+			}
+			if cfg.Session.TTL != Default().Session.TTL {
+				t.Errorf("got session ttl %s want default %s", cfg.Session.TTL, Default().Session.TTL)
+			}
+			if cfg.Password.BcryptCost != 10 {
+				t.Errorf("got bcrypt cost %d want env value %d", cfg.Password.BcryptCost, 10)
+			}
+		})
+	}
+}
+
+func TestLoadSecretFile(t *testing.T) {
+	t.Setenv("AUTH_DB_DSN", "postgres://env")
+	t.Setenv("JWT_ACCESS_SECRET_FILE", writeFile(t, "secret", testSecret+"\n"))
+
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.JWT.AccessSecret != testSecret {
+		t.Errorf("got secret %q want %q", cfg.JWT.AccessSecret, testSecret)
+	}
+
+	t.Setenv("JWT_ACCESS_SECRET", testSecret)
+	if _, err := Load(""); err == nil {
+		t.Error("expected an error when both the variable and its _FILE are set")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	t.Setenv("AUTH_DB_DSN", "postgres://env")
+	t.Setenv("JWT_ACCESS_SECRET", "too-short")
+	t.Setenv("AUTH_BCRYPT_COST", "40")
+	t.Setenv("AUTH_PASSWORD_HISTORY", "-1")
+	t.Setenv("AUTH_SESSION_ON_LIMIT", "wait")
+	t.Setenv("AUTH_LOGIN_ALERTS_MAX_TRAVEL_SPEED", "0")
+	t.Setenv("AUTH_ADMIN_TOKEN", "admin-token-ensure-32-bytes-long-string!")
+
+	_, err := Load("")
+	if err == nil {
+		t.Fatal("expected a validation error")
+	}
+
+	for _, key := range []string{"jwt.access_secret", "password.bcrypt_cost", "password.policy.history", "session.on_limit", "login_alerts.max_travel_speed", "jwt.break_glass_secret"} {
+		if !strings.Contains(err.Error(), key) {
+			t.Errorf("expected error to mention %s, got %v", key, err)
+		}
+	}
+}
+
+func TestSessionLimits(t *testing.T) {
+	cfg := Default()
+	cfg.Session.Devices = map[string]SessionLimits{
+		"mobile": {TTL: 72 * time.Hour, MaxLifetime: 30 * 24 * time.Hour},
+	}
+
+	tests := []struct {
+		deviceType     string
+		rememberMe     bool
+		idle, lifetime time.Duration
+	}{
+		{"desktop", false, cfg.Session.TTL, cfg.Session.MaxLifetime},
+		{"desktop", true, cfg.Session.RememberMeTTL, cfg.Session.RememberMeMaxLifetime},
+		{"mobile", false, 72 * time.Hour, 30 * 24 * time.Hour},
+		{"mobile", true, cfg.Session.RememberMeTTL, cfg.Session.RememberMeMaxLifetime},
+	}
+	for _, tt := range tests {
+		idle, lifetime := cfg.Session.Limits(tt.deviceType, tt.rememberMe)
+		if idle != tt.idle || lifetime != tt.lifetime {
+			t.Errorf("%s remember_me=%t: got %v, %v want %v, %v", tt.deviceType, tt.rememberMe, idle, lifetime, tt.idle, tt.lifetime)
+		}
+	}
+
+	cfg.Session.RoleMaxSessions = map[string]int{"admin": 2}
+	cfg.Session.DeviceMaxSessions = map[string]int{"mobile": 1}
+	if total, device := cfg.Session.MaxSessionsFor("admin", "mobile"); total != 2 || device != 1 {
+		t.Errorf("got max sessions %d, %d for an admin on mobile want 2, 1", total, device)
+	}
+	if total, device := cfg.Session.MaxSessionsFor("user", "desktop"); total != cfg.Session.MaxSessions || device != 0 {
+		t.Errorf("got max sessions %d, %d for a user on desktop want %d, 0", total, device, cfg.Session.MaxSessions)
+	}
+
+	cfg.Session.Devices["mobile"] = SessionLimits{TTL: 10 * 24 * time.Hour}
+	cfg.Session.Devices["toaster"] = SessionLimits{}
+	err := cfg.Validate()
+	for _, key := range []string{"session.devices.mobile.max_lifetime", "session.devices.toaster"} {
+		if err == nil || !strings.Contains(err.Error(), key) {
+			t.Errorf("expected error to mention %s, got %v", key, err)
+		}
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	cfg := Default()
+	cfg.DB.DSN = "postgres://user:password@db/auth"
+	cfg.JWT.AccessSecret = testSecret
+
+	out := cfg.String()
+	if strings.Contains(out, testSecret) || strings.Contains(out, "password@db") {
+		t.Errorf("secrets leaked into printed config:\n%s", out)
+	}
+	if cfg.JWT.AccessSecret != testSecret {
+		t.Error("redacting must not modify the original config")
+	}
+}
+
+func TestApplyReload(t *testing.T) {
+	current := Default()
+	current.DB.DSN = "postgres://current"
+	current.JWT.AccessSecret = testSecret
+
+	next := current
+	next.DB.DSN = "postgres://next"
+	next.JWT.AccessSecret = strings.ToUpper(testSecret)
+	next.JWT.PreviousSecrets = []string{testSecret}
+
+	got, kept := ApplyReload(current, next)
+
+	if got.DB.DSN != current.DB.DSN {
+		t.Errorf("got dsn %q want unchanged %q", got.DB.DSN, current.DB.DSN)
+	}
+	if got.JWT.AccessSecret != next.JWT.AccessSecret {
+		t.Error("expected the rotated secret to be applied")
+	}
+	if len(kept) != 1 || kept[0] != "db" {
+		t.Errorf("got kept settings %v want [db]", kept)
+	}
+}
+
+func TestPreviousSecretsEnv(t *testing.T) {
+	t.Setenv("AUTH_DB_DSN", "postgres://env")
+	t.Setenv("JWT_ACCESS_SECRET", testSecret)
+	t.Setenv("JWT_PREVIOUS_SECRETS", strings.ToUpper(testSecret)+", "+strings.ToLower(testSecret))
+
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cfg.JWT.PreviousSecrets) != 2 {
+		t.Fatalf("got %d previous secrets want 2", len(cfg.JWT.PreviousSecrets))
+	}
+	if strings.Contains(cfg.String(), strings.ToUpper(testSecret)) {
+		t.Error("previous secrets leaked into printed config")
+	}
+}
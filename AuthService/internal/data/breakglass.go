@@ -0,0 +1,83 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// BreakGlass is an emergency revocation of every session and access token.
+// Tokens issued before NotBefore or signed with a key in RevokedKeyIDs are
+// no longer accepted, and the key derived from SigningKeySalt signs new
+// tokens for as long as the configured secret is one of the revoked ones.
+// Actor identifies the credential the break glass was made with.
+type BreakGlass struct {
+	ID              int64
+	NotBefore       time.Time
+	Actor           string
+	Reason          string
+	SigningKeySalt  string
+	RevokedKeyIDs   []string
+	SessionsRevoked *int64
+	CompletedAt     *time.Time
+}
+
+type BreakGlassModel struct {
+	DB *sql.DB
+}
+
+// Insert records b, which takes effect from the current time.
+func (m *BreakGlassModel) Insert(ctx context.Context, b *BreakGlass) (err error) {
+	ctx, span := startSpan(ctx, "BreakGlassModel.Insert")
+	defer func() { endSpan(span, err) }()
+
+	const stmt = `
+		INSERT INTO break_glass (actor, reason, signing_key_salt, revoked_key_ids)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, not_before`
+
+	return m.DB.QueryRowContext(ctx, stmt, b.Actor, b.Reason, b.SigningKeySalt, pq.Array(b.RevokedKeyIDs)).Scan(&b.ID, &b.NotBefore)
+}
+
+// Latest returns the break glass in force.
+func (m *BreakGlassModel) Latest(ctx context.Context) (_ *BreakGlass, err error) {
+	ctx, span := startSpan(ctx, "BreakGlassModel.Latest")
+	defer func() { endSpan(span, err) }()
+
+	const stmt = `
+		SELECT id, not_before, actor, reason, signing_key_salt, revoked_key_ids, sessions_revoked, completed_at
+		FROM break_glass
+		ORDER BY id DESC
+		LIMIT 1`
+
+	var b BreakGlass
+	err = m.DB.QueryRowContext(ctx, stmt).Scan(
+		&b.ID,
+		&b.NotBefore,
+		&b.Actor,
+		&b.Reason,
+		&b.SigningKeySalt,
+		pq.Array(&b.RevokedKeyIDs),
+		&b.SessionsRevoked,
+		&b.CompletedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, err
+	}
+	return &b, nil
+}
+
+// Complete records that the sessions of break glass id have been revoked.
+func (m *BreakGlassModel) Complete(ctx context.Context, id int64, sessionsRevoked int64) (err error) {
+	ctx, span := startSpan(ctx, "BreakGlassModel.Complete")
+	defer func() { endSpan(span, err) }()
+
+	_, err = m.DB.ExecContext(ctx, `UPDATE break_glass SET sessions_revoked = $2, completed_at = NOW() WHERE id = $1`, id, sessionsRevoked)
+	return err
+}
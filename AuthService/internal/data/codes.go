@@ -0,0 +1,31 @@
+package data
+
+// Error codes sent in the code field of error responses. Unlike the
+// messages, which are meant for people and may be reworded, the codes are
+// part of the API contract: clients branch on them, so an existing code is
+// never renamed or reused for a different condition.
+const (
+	CodeMalformedRequest   = "AUTH_MALFORMED_REQUEST"
+	CodeValidationFailed   = "AUTH_VALIDATION_FAILED"
+	CodeUnsupportedVersion = "AUTH_UNSUPPORTED_VERSION"
+
+	CodeIdempotencyKeyInvalid = "AUTH_IDEMPOTENCY_KEY_INVALID"
+	CodeIdempotencyKeyReused  = "AUTH_IDEMPOTENCY_KEY_REUSED"
+	CodeRequestInProgress     = "AUTH_REQUEST_IN_PROGRESS"
+
+	CodeEmailInUse         = "AUTH_EMAIL_IN_USE"
+	CodeInvalidCredentials = "AUTH_INVALID_CREDENTIALS"
+	CodePasswordExpired    = "AUTH_PASSWORD_EXPIRED"
+	CodeTokenInvalid       = "AUTH_TOKEN_INVALID"
+	CodeTokenExpired       = "AUTH_TOKEN_EXPIRED"
+	CodeTokenRevoked       = "AUTH_TOKEN_REVOKED"
+	CodeSessionNotFound    = "AUTH_SESSION_NOT_FOUND"
+	CodeUserNotFound       = "AUTH_USER_NOT_FOUND"
+	CodeSessionRevoked     = "AUTH_SESSION_REVOKED"
+	CodeSessionExpired     = "AUTH_SESSION_EXPIRED"
+	CodeSessionLimit       = "AUTH_SESSION_LIMIT_REACHED"
+	CodeForbidden          = "AUTH_FORBIDDEN"
+	CodeServiceUnavailable = "AUTH_SERVICE_UNAVAILABLE"
+	CodeOverloaded         = "AUTH_OVERLOADED"
+	CodeInternal           = "AUTH_INTERNAL_ERROR"
+)
@@ -0,0 +1,71 @@
+package data
+
+import "time"
+
+// Subjects of the events the service publishes. They fall under
+// auth.events.>, so they are kept in the auth_events stream.
+const (
+	SubjectTokensRevoked = "auth.events.tokens_revoked"
+	SubjectBreakGlass    = "auth.events.break_glass"
+)
+
+// SubjectNotificationCreated is where notifications for users are
+// published. The realtime service pushes them to the user's open
+// connections.
+const SubjectNotificationCreated = "notificacion.creada"
+
+// Reasons given in a TokensRevokedEvent.
+const (
+	RevokeReasonPasswordChanged = "password_changed"
+	RevokeReasonLogoutAll       = "logout_all"
+	RevokeReasonRoleChanged     = "role_changed"
+)
+
+// TokensRevokedEvent announces that the access tokens issued to UserID with
+// a token version other than TokenVersion are no longer valid. Services
+// that verify tokens offline can keep the latest version of each user from
+// these events and reject older tokens without asking the auth service.
+type TokensRevokedEvent struct {
+	UserID       string    `json:"user_id"`
+	TokenVersion int       `json:"token_version"`
+	Reason       string    `json:"reason"`
+	OccurredAt   time.Time `json:"occurred_at"`
+}
+
+// BreakGlassEvent announces that every access token issued before
+// NotBefore, and every session created before it, has been revoked, and
+// that new tokens are signed with the key KeyID. It is published as soon
+// as the revocation is in force, before the sessions have been marked.
+type BreakGlassEvent struct {
+	ID        int64     `json:"id"`
+	NotBefore time.Time `json:"not_before"`
+	Actor     string    `json:"actor"`
+	Reason    string    `json:"reason"`
+	KeyID     string    `json:"key_id"`
+}
+
+// NestEvent wraps an event for the NestJS services, which read the pattern
+// and the data of a message from its body.
+type NestEvent struct {
+	Pattern string `json:"pattern"`
+	Data    any    `json:"data"`
+}
+
+// LoginAlertNotification tells UserID that a login to their account looked
+// suspicious for the reasons in Flags. Mensaje is the text shown to the
+// user, in the language the login asked for. Its fields are camel case, as
+// the NestJS services expect.
+type LoginAlertNotification struct {
+	UserID     string    `json:"userId"`
+	Mensaje    string    `json:"mensaje"`
+	Tipo       string    `json:"tipo"`
+	Flags      []string  `json:"flags"`
+	SessionID  string    `json:"sessionId"`
+	IPAddress  *string   `json:"ipAddress"`
+	Browser    string    `json:"browser"`
+	OS         string    `json:"os"`
+	Device     string    `json:"device"`
+	Country    string    `json:"country"`
+	City       string    `json:"city"`
+	OccurredAt time.Time `json:"occurredAt"`
+}
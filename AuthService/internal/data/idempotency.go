@@ -0,0 +1,143 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// IdempotencyRecord is the outcome of the first request sent with an
+// idempotency key. Response is nil while that request is still being
+// handled; it is stored as given, so callers encrypt responses that carry
+// credentials. ErrorCode and ErrorDescription are set when the response was an
+// error, so it can be replayed through the same micro error headers.
+// ClaimedAt identifies the claim of the request handling the key.
+type IdempotencyRecord struct {
+	Subject          string
+	Key              string
+	RequestHash      []byte
+	Response         []byte
+	ErrorCode        string
+	ErrorDescription string
+	CreatedAt        time.Time
+	ClaimedAt        time.Time
+	ExpiresAt        time.Time
+}
+
+type IdempotencyModel struct {
+	DB *sql.DB
+}
+
+// Claim records that a request for r.Key is being handled on r.Subject,
+// to be replayed for ttl, and sets the claim's ClaimedAt and ExpiresAt. It
+// reports false when the key is already taken by an unexpired record, in
+// which case the caller should look the record up instead of handling the
+// request. A claim that got no response within lease is taken over by a
+// request with the same hash, as the one that made it is presumed dead.
+func (m *IdempotencyModel) Claim(ctx context.Context, r *IdempotencyRecord, ttl, lease time.Duration) (_ bool, err error) {
+	ctx, span := startSpan(ctx, "IdempotencyModel.Claim")
+	defer func() { endSpan(span, err) }()
+
+	// An expired record is taken over as if it did not exist; the prune job
+	// may not have removed it yet. Taking over a lapsed claim keeps the
+	// replay window of the first request.
+	const stmt = `
+		INSERT INTO idempotency_keys (subject, key, request_hash, expires_at)
+		VALUES ($1, $2, $3, NOW() + make_interval(secs => $4))
+		ON CONFLICT (subject, key) DO UPDATE
+		SET request_hash = EXCLUDED.request_hash,
+		    response = NULL,
+		    error_code = NULL,
+		    error_description = NULL,
+		    created_at = CASE WHEN idempotency_keys.expires_at <= NOW() THEN NOW() ELSE idempotency_keys.created_at END,
+		    claimed_at = NOW(),
+		    expires_at = CASE WHEN idempotency_keys.expires_at <= NOW() THEN EXCLUDED.expires_at ELSE idempotency_keys.expires_at END
+		WHERE idempotency_keys.expires_at <= NOW()
+		   OR (idempotency_keys.response IS NULL
+		       AND idempotency_keys.request_hash = EXCLUDED.request_hash
+		       AND idempotency_keys.claimed_at <= NOW() - make_interval(secs => $5))
+		RETURNING created_at, claimed_at, expires_at`
+
+	err = m.DB.QueryRowContext(ctx, stmt, r.Subject, r.Key, r.RequestHash, ttl.Seconds(), lease.Seconds()).Scan(&r.CreatedAt, &r.ClaimedAt, &r.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (m *IdempotencyModel) Get(ctx context.Context, subject, key string) (_ *IdempotencyRecord, err error) {
+	ctx, span := startSpan(ctx, "IdempotencyModel.Get")
+	defer func() { endSpan(span, err) }()
+
+	const stmt = `
+		SELECT subject, key, request_hash, response, COALESCE(error_code, ''),
+		       COALESCE(error_description, ''), created_at, claimed_at, expires_at
+		FROM idempotency_keys
+		WHERE subject = $1 AND key = $2 AND expires_at > NOW()`
+
+	var r IdempotencyRecord
+	err = m.DB.QueryRowContext(ctx, stmt, subject, key).Scan(
+		&r.Subject,
+		&r.Key,
+		&r.RequestHash,
+		&r.Response,
+		&r.ErrorCode,
+		&r.ErrorDescription,
+		&r.CreatedAt,
+		&r.ClaimedAt,
+		&r.ExpiresAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, err
+	}
+	return &r, nil
+}
+
+// Complete stores the response of the request that made claim r. Nothing
+// is stored once another request has taken the claim over.
+func (m *IdempotencyModel) Complete(ctx context.Context, r *IdempotencyRecord) (err error) {
+	ctx, span := startSpan(ctx, "IdempotencyModel.Complete")
+	defer func() { endSpan(span, err) }()
+
+	const stmt = `
+		UPDATE idempotency_keys
+		SET response = $3, error_code = NULLIF($4, ''), error_description = NULLIF($5, '')
+		WHERE subject = $1 AND key = $2 AND claimed_at = $6 AND response IS NULL`
+
+	_, err = m.DB.ExecContext(ctx, stmt, r.Subject, r.Key, r.Response, r.ErrorCode, r.ErrorDescription, r.ClaimedAt)
+	return err
+}
+
+// Release frees claim r, whose request produced no response worth
+// replaying, so that a retry is handled afresh.
+func (m *IdempotencyModel) Release(ctx context.Context, r *IdempotencyRecord) (err error) {
+	ctx, span := startSpan(ctx, "IdempotencyModel.Release")
+	defer func() { endSpan(span, err) }()
+
+	const stmt = `
+		DELETE FROM idempotency_keys
+		WHERE subject = $1 AND key = $2 AND claimed_at = $3 AND response IS NULL`
+
+	_, err = m.DB.ExecContext(ctx, stmt, r.Subject, r.Key, r.ClaimedAt)
+	return err
+}
+
+// DeleteExpired removes the records whose replay window has passed and
+// returns how many there were.
+func (m *IdempotencyModel) DeleteExpired(ctx context.Context) (_ int64, err error) {
+	ctx, span := startSpan(ctx, "IdempotencyModel.DeleteExpired")
+	defer func() { endSpan(span, err) }()
+
+	r, err := m.DB.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE expires_at <= NOW()`)
+	if err != nil {
+		return 0, err
+	}
+	return r.RowsAffected()
+}
@@ -0,0 +1,154 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Reasons a login is flagged as suspicious.
+const (
+	LoginFlagNewDevice        = "new_device"
+	LoginFlagNewCountry       = "new_country"
+	LoginFlagImpossibleTravel = "impossible_travel"
+)
+
+// Login is an entry in the login history of a user.
+type Login struct {
+	ID        int64
+	UserID    string
+	SessionID string
+	// Device identifies the client independently of its version, so that
+	// browser updates do not make a device look new. It is empty when the
+	// client told nothing about itself.
+	Device    string
+	IPAddress *string
+	Country   string
+	City      string
+	// Latitude and Longitude are within AccuracyRadius kilometers of where
+	// the login came from. AccuracyRadius is 0 when it was not located.
+	Latitude       float64
+	Longitude      float64
+	AccuracyRadius int
+	Flags          []string
+	CreatedAt      time.Time
+}
+
+// PreviousLogins is what the login history of a user says about a new
+// login.
+type PreviousLogins struct {
+	// Any is whether the user has logged in before at all.
+	Any bool
+	// Device and Country are whether the user has logged in before from
+	// the device and the country of the new login.
+	Device  bool
+	Country bool
+	// Last is the latest located login, or nil when there is none.
+	Last *Login
+}
+
+type LoginModel struct {
+	DB *sql.DB
+}
+
+// Insert records l and forgets the logins of the user beyond the latest
+// keep.
+func (m *LoginModel) Insert(ctx context.Context, l *Login, keep int) (err error) {
+	ctx, span := startSpan(ctx, "LoginModel.Insert")
+	defer func() { endSpan(span, err) }()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	const stmt = `
+		INSERT INTO login_history (user_id, session_id, device, ip_address, country, city, latitude, longitude, accuracy_radius, flags)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, COALESCE($10::TEXT[], '{}'))
+		RETURNING id, created_at`
+
+	err = tx.QueryRowContext(ctx, stmt,
+		l.UserID,
+		l.SessionID,
+		l.Device,
+		l.IPAddress,
+		l.Country,
+		l.City,
+		l.Latitude,
+		l.Longitude,
+		l.AccuracyRadius,
+		pq.Array(l.Flags),
+	).Scan(&l.ID, &l.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	const prune = `
+		DELETE FROM login_history
+		WHERE user_id = $1
+		  AND id NOT IN (
+			SELECT id FROM login_history
+			WHERE user_id = $1
+			ORDER BY created_at DESC, id DESC
+			LIMIT $2
+		  )`
+
+	if _, err = tx.ExecContext(ctx, prune, l.UserID, keep); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Previous looks up the login history of userID for a new login from
+// device in country. An empty device or country is never found.
+func (m *LoginModel) Previous(ctx context.Context, userID, device, country string) (_ PreviousLogins, err error) {
+	ctx, span := startSpan(ctx, "LoginModel.Previous")
+	defer func() { endSpan(span, err) }()
+
+	const stmt = `
+		SELECT EXISTS (SELECT 1 FROM login_history WHERE user_id = $1),
+		       EXISTS (SELECT 1 FROM login_history WHERE user_id = $1 AND device = $2 AND $2 <> ''),
+		       EXISTS (SELECT 1 FROM login_history WHERE user_id = $1 AND country = $3 AND $3 <> '')`
+
+	var p PreviousLogins
+	if err = m.DB.QueryRowContext(ctx, stmt, userID, device, country).Scan(&p.Any, &p.Device, &p.Country); err != nil {
+		return PreviousLogins{}, err
+	}
+	if !p.Any {
+		return p, nil
+	}
+
+	const last = `
+		SELECT id, session_id, device, ip_address, country, city, latitude, longitude, accuracy_radius, flags, created_at
+		FROM login_history
+		WHERE user_id = $1 AND accuracy_radius > 0
+		ORDER BY created_at DESC, id DESC
+		LIMIT 1`
+
+	l := Login{UserID: userID}
+	err = m.DB.QueryRowContext(ctx, last, userID).Scan(
+		&l.ID,
+		&l.SessionID,
+		&l.Device,
+		&l.IPAddress,
+		&l.Country,
+		&l.City,
+		&l.Latitude,
+		&l.Longitude,
+		&l.AccuracyRadius,
+		pq.Array(&l.Flags),
+		&l.CreatedAt,
+	)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return p, nil
+	case err != nil:
+		return PreviousLogins{}, err
+	}
+	p.Last = &l
+	return p, nil
+}
@@ -1,81 +1,51 @@
 package data
 
-import (
-	"auth/internal/validator"
-	"time"
-)
-
-type RegisterInput struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
-	Username string `json:"username"`
-}
-type LoginInput struct {
-	Email      string `json:"email"`
-	Password   string `json:"password"`
-	DeviceName string `json:"device_name"`
-	DeviceType string `json:"device_type"`
-	RememberMe bool   `json:"remember_me"`
-	IPAddress  string `json:"ip_address"`
-	UserAgent  string `json:"user_agent"`
-}
-
-type LogoutInput struct {
-	SessionID string `json:"session_id"`
-}
-type AccessTokenInput struct {
-	TokenString string `json:"access_token"`
-}
-
-type RefreshTokenInput struct {
-	TokenString string `json:"refresh_token"`
-}
-
+// Response is the envelope of every reply. Successful replies carry their
+// payload in Data; error replies carry one of the Code* constants, a
+// human-readable Message and, for invalid input, the offending fields in
+// Details.
 type Response struct {
-	StatusCode int `json:"status"`
-	Data       any `json:"data"`
-}
-
-type SessionResponse struct {
-	SessionID  string    `json:"session_id"`
-	DeviceName string    `json:"device_name"`
-	DeviceType string    `json:"device_type"`
-	LastUsedAt time.Time `json:"last_used_at"`
-}
-type LoginResponse struct {
-	RefreshToken   string            `json:"refresh_token"`
-	AccessToken    string            `json:"access_token"`
-	CurrentSession SessionResponse   `json:"current_session"`
-	OtherSessions  []SessionResponse `json:"other_sessions"`
-}
-type TokenValidationResponse struct {
-	UserID   string `json:"id"`
-	Email    string `json:"email"`
-	Username string `json:"username"`
-}
-type TokenRefreshResponse struct {
-	AccessToken string `json:"access_token"`
-}
-
-func ValidateRegisterInput(v *validator.Validator, input RegisterInput) {
-	validateUserName(v, input.Username)
-	ValidateEmail(v, input.Email)
-	ValidatePasswordPlainText(v, input.Password)
-}
-
-func ValidateLoginInput(v *validator.Validator, input LoginInput) {
-	ValidateEmail(v, input.Email)
-	ValidatePasswordPlainText(v, input.Password)
-}
-
-func ValidateLogoutInput(v *validator.Validator, input LogoutInput) {
-	v.Check(input.SessionID != "", "session_id", "must be provided")
-}
-
-func ValidateAccessTokenInput(v *validator.Validator, input AccessTokenInput) {
-	v.Check(input.TokenString != "", "access_token", "must be provided")
-}
-
-func ValidateRefreshTokenInput(v *validator.Validator, input RefreshTokenInput) {
-	v.Check(input.TokenString != "", "refresh_token", "must be provided")
+	StatusCode int               `json:"status"`
+	Code       string            `json:"code,omitempty"`
+	Message    string            `json:"message,omitempty"`
+	Details    map[string]string `json:"details,omitempty"`
+	Data       any               `json:"data,omitempty"`
+}
+
+type HealthResponse struct {
+	Status    string          `json:"status"`
+	Database  DatabaseHealth  `json:"database"`
+	Migration MigrationHealth `json:"migration"`
+	NATS      NATSHealth      `json:"nats"`
+	JetStream JetStreamHealth `json:"jetstream"`
+	Build     BuildInfo       `json:"build"`
+}
+type DatabaseHealth struct {
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+type MigrationHealth struct {
+	Status  string `json:"status"`
+	Version int64  `json:"version"`
+	Dirty   bool   `json:"dirty"`
+	Error   string `json:"error,omitempty"`
+}
+type NATSHealth struct {
+	Status     string `json:"status"`
+	Server     string `json:"server,omitempty"`
+	Reconnects uint64 `json:"reconnects"`
+}
+type JetStreamHealth struct {
+	Status string `json:"status"`
+	// OutboxLag is the number of auth events published to the auth_events
+	// stream that no consumer has processed yet.
+	OutboxLag uint64 `json:"outbox_lag"`
+	Error     string `json:"error,omitempty"`
+}
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Revision  string `json:"revision,omitempty"`
+	BuildTime string `json:"build_time,omitempty"`
+	GoVersion string `json:"go_version"`
 }
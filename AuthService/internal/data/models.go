@@ -10,6 +10,9 @@ var ErrNoRecord = errors.New("no record found")
 type Models struct {
 	UserModel
 	SessionModel
+	IdempotencyModel
+	BreakGlassModel
+	LoginModel
 }
 
 func NewModels(db *sql.DB) *Models {
@@ -21,5 +24,17 @@ func NewModels(db *sql.DB) *Models {
 		SessionModel: SessionModel{
 			DB: db,
 		},
+
+		IdempotencyModel: IdempotencyModel{
+			DB: db,
+		},
+
+		BreakGlassModel: BreakGlassModel{
+			DB: db,
+		},
+
+		LoginModel: LoginModel{
+			DB: db,
+		},
 	}
 }
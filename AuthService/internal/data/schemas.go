@@ -0,0 +1,37 @@
+package data
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/fs"
+)
+
+// EndpointMetadata returns the micro endpoint metadata advertised for the
+// endpoint name: its description and, when schemas holds them, the JSON
+// schemas of its request and response (schemas/<name>.request.json and
+// schemas/<name>.response.json).
+func EndpointMetadata(schemas fs.FS, name, description string) (map[string]string, error) {
+	metadata := map[string]string{
+		"description": description,
+		"format":      "application/json",
+	}
+
+	for _, kind := range []string{"request", "response"} {
+		schema, err := fs.ReadFile(schemas, "schemas/"+name+"."+kind+".json")
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, schema); err != nil {
+			return nil, err
+		}
+		metadata[kind+"_schema"] = compact.String()
+	}
+
+	return metadata, nil
+}
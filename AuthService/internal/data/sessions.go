@@ -1,9 +1,12 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"time"
+
+	"github.com/lib/pq"
 )
 
 type Session struct {
@@ -15,27 +18,158 @@ type Session struct {
 	RememberMe bool
 	CreatedAt  time.Time
 	ExpiresAt  time.Time
-	LastUsedAt time.Time
-	RevokedAt  *time.Time
-	IPAddress  *string
-	UserAgent  string
+	// AbsoluteExpiresAt is when the session ends however recently it was
+	// used; ExpiresAt is never pushed past it. It defaults to ExpiresAt.
+	AbsoluteExpiresAt time.Time
+	LastUsedAt        time.Time
+	RevokedAt         *time.Time
+	IPAddress         *string
+	UserAgent         string
+	// Browser, OS and Device are parsed from UserAgent, and Country (an ISO
+	// 3166-1 alpha-2 code) and City located from IPAddress, at login. They
+	// are empty when unknown.
+	Browser string
+	OS      string
+	Device  string
+	Country string
+	City    string
 }
 type SessionModel struct {
 	DB *sql.DB
 }
 
-func (m *SessionModel) Insert(s *Session) error {
+// ErrSessionLimit is returned by Create when the user has no session to
+// spare and the quota says to reject new ones.
+var ErrSessionLimit = errors.New("session limit reached")
+
+// SessionQuota bounds the live sessions of a user.
+type SessionQuota struct {
+	// Max is how many sessions the user may have across device types.
+	Max int
+	// MaxForDevice is how many may have the device type of the new
+	// session; 0 means no limit of its own.
+	MaxForDevice int
+	// Reject makes a login over quota fail instead of revoking the least
+	// recently used sessions.
+	Reject bool
+}
+
+// Insert stores s without regard to the user's session quota.
+func (m *SessionModel) Insert(ctx context.Context, s *Session) (err error) {
+	ctx, span := startSpan(ctx, "SessionModel.Insert")
+	defer func() { endSpan(span, err) }()
+
+	return insertSession(ctx, m.DB, s)
+}
+
+// Create stores s, first revoking the least recently used sessions of the
+// user that do not fit in q alongside it, and returns those. With q.Reject
+// nothing is revoked or stored and ErrSessionLimit is returned instead.
+// Logins of the same user are serialized, so concurrent ones cannot both
+// take the last free session.
+func (m *SessionModel) Create(ctx context.Context, s *Session, q SessionQuota) (_ []Session, err error) {
+	ctx, span := startSpan(ctx, "SessionModel.Create")
+	defer func() { endSpan(span, err) }()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, `SELECT 1 FROM users WHERE id = $1 FOR UPDATE`, s.UserID); err != nil {
+		return nil, err
+	}
+
+	stmt := `SELECT session_id, device_name, device_type, expires_at, absolute_expires_at, last_used_at,
+	       ip_address, browser, os, device, country, city
+	FROM   sessions
+	WHERE  user_id      = $1
+	  AND  revoked_at   IS NULL
+	  AND  expires_at   > NOW()
+	ORDER  BY last_used_at DESC, created_at DESC, session_id DESC`
+
+	rows, err := tx.QueryContext(ctx, stmt, s.UserID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var live []Session
+	for rows.Next() {
+		var l Session
+		if err := rows.Scan(&l.SessionID, &l.DeviceName, &l.DeviceType, &l.ExpiresAt, &l.AbsoluteExpiresAt, &l.LastUsedAt,
+			&l.IPAddress, &l.Browser, &l.OS, &l.Device, &l.Country, &l.City); err != nil {
+			return nil, err
+		}
+		live = append(live, l)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	evicted := evictions(live, s.DeviceType, q)
+	if len(evicted) > 0 {
+		if q.Reject {
+			return nil, ErrSessionLimit
+		}
+		ids := make([]string, len(evicted))
+		for i, e := range evicted {
+			ids[i] = e.SessionID
+		}
+		if _, err = tx.ExecContext(ctx, `UPDATE sessions SET revoked_at = NOW() WHERE session_id = ANY($1)`, pq.Array(ids)); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = insertSession(ctx, tx, s); err != nil {
+		return nil, err
+	}
+	return evicted, tx.Commit()
+}
+
+// evictions returns the sessions in live, most recently used first, that
+// must go for a new session on deviceType to fit in q.
+func evictions(live []Session, deviceType string, q SessionQuota) []Session {
+	var evicted []Session
+	kept := live
+	if q.MaxForDevice > 0 {
+		kept = nil
+		n := 0
+		for _, s := range live {
+			if s.DeviceType == deviceType {
+				n++
+				if n >= q.MaxForDevice {
+					evicted = append(evicted, s)
+					continue
+				}
+			}
+			kept = append(kept, s)
+		}
+	}
+	if q.Max > 0 && len(kept) >= q.Max {
+		evicted = append(evicted, kept[q.Max-1:]...)
+	}
+	return evicted
+}
+
+func insertSession(ctx context.Context, db interface {
+	QueryRowContext(context.Context, string, ...any) *sql.Row
+}, s *Session) error {
 	const query = `
        INSERT INTO sessions
        (session_id, token_hash, user_id, device_name, device_type, 
-        remember_me, expires_at, created_at, last_used_at, ip_address, user_agent)
+        remember_me, expires_at, created_at, last_used_at, ip_address, user_agent,
+        absolute_expires_at, browser, os, device, country, city)
        VALUES ($1, $2, $3, $4, $5, $6, $7, 
                CASE WHEN $8 = '0001-01-01 00:00:00+00'::timestamptz THEN NOW() ELSE $8 END, 
                CASE WHEN $9 = '0001-01-01 00:00:00+00'::timestamptz THEN NOW() ELSE $9 END, 
-               $10, $11)
-       RETURNING session_id, created_at, last_used_at`
+               $10, $11,
+               CASE WHEN $12 = '0001-01-01 00:00:00+00'::timestamptz THEN $7 ELSE $12 END,
+               $13, $14, $15, $16, $17)
+       RETURNING session_id, created_at, last_used_at, absolute_expires_at`
 
-	err := m.DB.QueryRow(query,
+	return db.QueryRowContext(ctx, query,
 		s.SessionID,
 		s.TokenHash,
 		s.UserID,
@@ -47,22 +181,29 @@ func (m *SessionModel) Insert(s *Session) error {
 		s.LastUsedAt,
 		s.IPAddress,
 		s.UserAgent,
-	).Scan(&s.SessionID, &s.CreatedAt, &s.LastUsedAt)
-
-	if err != nil {
-		return err
-	}
-
-	return nil
+		s.AbsoluteExpiresAt,
+		s.Browser,
+		s.OS,
+		s.Device,
+		s.Country,
+		s.City,
+	).Scan(&s.SessionID, &s.CreatedAt, &s.LastUsedAt, &s.AbsoluteExpiresAt)
 }
 
-func (m *SessionModel) GetByID(id string) (*Session, error) {
+// GetByID returns the session id, whether or not it is still live, so that
+// callers can tell a revoked or expired session from one that never
+// existed.
+func (m *SessionModel) GetByID(ctx context.Context, id string) (_ *Session, err error) {
+	ctx, span := startSpan(ctx, "SessionModel.GetByID")
+	defer func() { endSpan(span, err) }()
+
 	const stmt = `SELECT session_id, token_hash, user_id, device_name, device_type, remember_me, 
-       created_at, expires_at, last_used_at, revoked_at, ip_address, user_agent FROM   sessions
-		WHERE  session_id = $1 AND  revoked_at IS NULL AND  expires_at  > NOW()`
+       created_at, expires_at, absolute_expires_at, last_used_at, revoked_at, ip_address, user_agent,
+       browser, os, device, country, city FROM   sessions
+		WHERE  session_id = $1`
 
 	var s Session
-	err := m.DB.QueryRow(stmt, id).Scan(
+	err = m.DB.QueryRowContext(ctx, stmt, id).Scan(
 		&s.SessionID,
 		&s.TokenHash,
 		&s.UserID,
@@ -71,10 +212,16 @@ func (m *SessionModel) GetByID(id string) (*Session, error) {
 		&s.RememberMe,
 		&s.CreatedAt,
 		&s.ExpiresAt,
+		&s.AbsoluteExpiresAt,
 		&s.LastUsedAt,
 		&s.RevokedAt,
 		&s.IPAddress,
 		&s.UserAgent,
+		&s.Browser,
+		&s.OS,
+		&s.Device,
+		&s.Country,
+		&s.City,
 	)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -85,8 +232,12 @@ func (m *SessionModel) GetByID(id string) (*Session, error) {
 	return &s, nil
 }
 
-func (m *SessionModel) GetOtherSessions(userID string, currentSessionID string) ([]SessionResponse, error) {
-	stmt := `SELECT session_id, device_name, device_type, last_used_at
+func (m *SessionModel) GetOtherSessions(ctx context.Context, userID string, currentSessionID string) (_ []Session, err error) {
+	ctx, span := startSpan(ctx, "SessionModel.GetOtherSessions")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `SELECT session_id, device_name, device_type, expires_at, absolute_expires_at, last_used_at,
+	       ip_address, browser, os, device, country, city
 	FROM   sessions
 	WHERE  user_id      = $1
 	  AND  session_id  != $2
@@ -94,7 +245,7 @@ func (m *SessionModel) GetOtherSessions(userID string, currentSessionID string)
 	  AND  expires_at   > NOW()
 	ORDER  BY last_used_at DESC`
 
-	rows, err := m.DB.Query(stmt, userID, currentSessionID)
+	rows, err := m.DB.QueryContext(ctx, stmt, userID, currentSessionID)
 	if err != nil {
 		return nil, err
 	}
@@ -102,14 +253,22 @@ func (m *SessionModel) GetOtherSessions(userID string, currentSessionID string)
 		_ = rows.Close()
 	}(rows)
 
-	var sessions []SessionResponse
+	var sessions []Session
 	for rows.Next() {
-		var s SessionResponse
+		var s Session
 		if err := rows.Scan(
 			&s.SessionID,
 			&s.DeviceName,
 			&s.DeviceType,
+			&s.ExpiresAt,
+			&s.AbsoluteExpiresAt,
 			&s.LastUsedAt,
+			&s.IPAddress,
+			&s.Browser,
+			&s.OS,
+			&s.Device,
+			&s.Country,
+			&s.City,
 		); err != nil {
 			return nil, err
 		}
@@ -121,17 +280,32 @@ func (m *SessionModel) GetOtherSessions(userID string, currentSessionID string)
 	return sessions, nil
 }
 
-func (m *SessionModel) UpdateLastUsed(id string) error {
-	_, err := m.DB.Exec(`UPDATE sessions SET last_used_at = $1 WHERE session_id = $2`, time.Now(), id)
+// Touch records that s was used now and slides its idle expiry to idle
+// from now, capped by its absolute expiry. s is updated in place.
+func (m *SessionModel) Touch(ctx context.Context, s *Session, idle time.Duration) (err error) {
+	ctx, span := startSpan(ctx, "SessionModel.Touch")
+	defer func() { endSpan(span, err) }()
+
+	const stmt = `
+		UPDATE sessions
+		SET last_used_at = NOW(),
+		    expires_at = LEAST(NOW() + make_interval(secs => $2), absolute_expires_at)
+		WHERE session_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+		RETURNING expires_at, absolute_expires_at, last_used_at`
+
+	err = m.DB.QueryRowContext(ctx, stmt, s.SessionID, idle.Seconds()).Scan(&s.ExpiresAt, &s.AbsoluteExpiresAt, &s.LastUsedAt)
 	if errors.Is(err, sql.ErrNoRows) {
 		return ErrNoRecord
 	}
 	return err
 }
 
-func (m *SessionModel) Revoke(id string) error {
+func (m *SessionModel) Revoke(ctx context.Context, id string) (err error) {
+	ctx, span := startSpan(ctx, "SessionModel.Revoke")
+	defer func() { endSpan(span, err) }()
+
 	stmt := `UPDATE sessions SET revoked_at = NOW() WHERE session_id = $1 AND revoked_at IS NULL AND expires_at > NOW()`
-	r, err := m.DB.Exec(stmt, id)
+	r, err := m.DB.ExecContext(ctx, stmt, id)
 	if err != nil {
 		return err
 	}
@@ -145,18 +319,56 @@ func (m *SessionModel) Revoke(id string) error {
 	return nil
 }
 
-func (m *SessionModel) GetByTokenHash(hash []byte) (*Session, error) {
+// RevokeAllForUser revokes every live session of userID and returns how
+// many there were.
+func (m *SessionModel) RevokeAllForUser(ctx context.Context, userID string) (_ int64, err error) {
+	ctx, span := startSpan(ctx, "SessionModel.RevokeAllForUser")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `UPDATE sessions SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()`
+	r, err := m.DB.ExecContext(ctx, stmt, userID)
+	if err != nil {
+		return 0, err
+	}
+	return r.RowsAffected()
+}
+
+// RevokeBatch revokes up to limit of the live sessions created before
+// before and returns how many it revoked, so that revoking every session
+// does not lock the whole table at once.
+func (m *SessionModel) RevokeBatch(ctx context.Context, before time.Time, limit int) (_ int64, err error) {
+	ctx, span := startSpan(ctx, "SessionModel.RevokeBatch")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `UPDATE sessions SET revoked_at = NOW() WHERE session_id IN (
+		SELECT session_id FROM sessions
+		WHERE revoked_at IS NULL AND expires_at > NOW() AND created_at < $1
+		LIMIT $2
+		FOR UPDATE SKIP LOCKED
+	)`
+	r, err := m.DB.ExecContext(ctx, stmt, before, limit)
+	if err != nil {
+		return 0, err
+	}
+	return r.RowsAffected()
+}
+
+// GetByTokenHash returns the session whose refresh token hashes to hash,
+// whether or not it is still live, like GetByID.
+func (m *SessionModel) GetByTokenHash(ctx context.Context, hash []byte) (_ *Session, err error) {
+	ctx, span := startSpan(ctx, "SessionModel.GetByTokenHash")
+	defer func() { endSpan(span, err) }()
+
 	const q = `
 		SELECT session_id, user_id, device_name, device_type, remember_me,
-		       created_at, expires_at, last_used_at, revoked_at, ip_address, user_agent
+		       created_at, expires_at, absolute_expires_at, last_used_at, revoked_at, ip_address, user_agent,
+		       browser, os, device, country, city
 		FROM sessions
 		WHERE token_hash = $1
-		  AND revoked_at IS NULL
-		  AND expires_at > NOW()
 		LIMIT 1`
 
 	var s Session
-	err := m.DB.QueryRow(q, hash).Scan(
+	err = m.DB.QueryRowContext(ctx, q, hash).Scan(
 		&s.SessionID,
 		&s.UserID,
 		&s.DeviceName,
@@ -164,10 +376,16 @@ func (m *SessionModel) GetByTokenHash(hash []byte) (*Session, error) {
 		&s.RememberMe,
 		&s.CreatedAt,
 		&s.ExpiresAt,
+		&s.AbsoluteExpiresAt,
 		&s.LastUsedAt,
 		&s.RevokedAt,
 		&s.IPAddress,
 		&s.UserAgent,
+		&s.Browser,
+		&s.OS,
+		&s.Device,
+		&s.Country,
+		&s.City,
 	)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -177,3 +395,12 @@ func (m *SessionModel) GetByTokenHash(hash []byte) (*Session, error) {
 	}
 	return &s, nil
 }
+
+func (m *SessionModel) CountActive(ctx context.Context) (_ int, err error) {
+	ctx, span := startSpan(ctx, "SessionModel.CountActive")
+	defer func() { endSpan(span, err) }()
+
+	var n int
+	err = m.DB.QueryRowContext(ctx, `SELECT COUNT(*) FROM sessions WHERE revoked_at IS NULL AND expires_at > NOW()`).Scan(&n)
+	return n, err
+}
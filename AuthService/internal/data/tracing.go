@@ -0,0 +1,31 @@
+package data
+
+import (
+	"context"
+	"errors"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("auth/internal/data")
+
+// startSpan starts the span covering a single model query.
+func startSpan(ctx context.Context, name string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemNamePostgreSQL),
+	)
+}
+
+// endSpan ends span, marking it as failed when err is an unexpected error.
+// ErrNoRecord is an expected outcome and is not reported.
+func endSpan(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, ErrNoRecord) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
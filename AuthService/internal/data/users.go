@@ -1,11 +1,11 @@
 package data
 
 import (
-	"auth/internal/validator"
+	"auth/internal/passhash"
+	"context"
 	"database/sql"
 	"errors"
-
-	"golang.org/x/crypto/bcrypt"
+	"time"
 )
 
 var ErrDuplicateEmail = errors.New("duplicate email")
@@ -18,74 +18,67 @@ type User struct {
 	Username  string   `json:"username"`
 	CreatedAt string   `json:"created_at"`
 	UpdatedAt string   `json:"updated_at"`
-}
-
-func validateUserName(v *validator.Validator, username string) {
-	if username == "" {
-		v.AddError("username", "must be provided")
-		return
-	}
-	v.Check(len(username) <= 100, "username", "must not be more than 100 characters")
-	v.Check(len(username) >= 4, "username", "must not be less than 4 characters")
-}
-
-func ValidateEmail(v *validator.Validator, email string) {
-	if email == "" {
-		v.AddError("email", "must be provided")
-		return
-	}
-	v.Check(validator.Matches(email, validator.EmailRX), "email", "must be a valid address")
-}
-
-func ValidatePasswordPlainText(v *validator.Validator, p string) {
-	if p == "" {
-		v.AddError("password", "must be provided")
-		return
-	}
-	v.Check(len(p) >= 8, "password", "must be at least 8 bytes long")
-	v.Check(len(p) <= 72, "password", "must not be more than 72 bytes long")
+	// PasswordChangedAt is when the current password was set.
+	PasswordChangedAt time.Time `json:"password_changed_at"`
+	// TokenVersion is copied into the user's access tokens. Bumping it
+	// makes every token issued before stale.
+	TokenVersion int `json:"-"`
+	// Role selects the user's session limit. New users get "user"; the
+	// set_role endpoint changes it.
+	Role string `json:"role"`
 }
 
 type password struct {
 	plaintext string
 	hash      []byte
+	// pepperID names the pepper mixed into hash, if any.
+	pepperID string
 }
 
-func (p *password) Set(plaintext string) error {
-	hash, err := bcrypt.GenerateFromPassword([]byte(plaintext), 12)
+func (p *password) Set(plaintext string, hasher passhash.Hasher, pepper passhash.Pepper) error {
+	hash, err := hasher.Hash(pepper.Apply(plaintext))
 	if err != nil {
 		return err
 	}
 
 	p.plaintext = plaintext
-	p.hash = hash
+	p.hash = []byte(hash)
+	p.pepperID = pepper.ID
 
 	return nil
 }
 
-func (p *password) Matches(plaintext string) (bool, error) {
-	err := bcrypt.CompareHashAndPassword(p.hash, []byte(plaintext))
+// Matches reports whether plaintext is the password. pepper must be the one
+// named by PepperID.
+func (p *password) Matches(plaintext string, pepper passhash.Pepper) (bool, error) {
+	return passhash.Verify(pepper.Apply(plaintext), string(p.hash))
+}
 
-	if err != nil {
-		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
-			return false, nil
-		}
-		return false, err
-	}
+// PepperID returns the key ID of the pepper the hash was made with, or ""
+// if it was made without one.
+func (p *password) PepperID() string {
+	return p.pepperID
+}
 
-	return true, nil
+// NeedsRehash reports whether the stored hash was made with another
+// algorithm, other parameters or another pepper than the given ones.
+func (p *password) NeedsRehash(hasher passhash.Hasher, pepper passhash.Pepper) bool {
+	return p.pepperID != pepper.ID || hasher.NeedsRehash(string(p.hash))
 }
 
 type UserModel struct {
 	DB *sql.DB
 }
 
-func (u *UserModel) Insert(user *User) error {
-	query := `INSERT INTO users (email, password_hash, username) 
-	VALUES ($1, $2, $3)
-	RETURNING id, created_at, updated_at`
+func (u *UserModel) Insert(ctx context.Context, user *User) (err error) {
+	ctx, span := startSpan(ctx, "UserModel.Insert")
+	defer func() { endSpan(span, err) }()
 
-	err := u.DB.QueryRow(query, user.Email, user.Password.hash, user.Username).Scan(&user.ID, &user.CreatedAt, &user.UpdatedAt)
+	query := `INSERT INTO users (email, password_hash, password_pepper_id, username) 
+	VALUES ($1, $2, NULLIF($3, ''), $4)
+	RETURNING id, created_at, updated_at, password_changed_at, token_version, role`
+
+	err = u.DB.QueryRowContext(ctx, query, user.Email, user.Password.hash, user.Password.pepperID, user.Username).Scan(&user.ID, &user.CreatedAt, &user.UpdatedAt, &user.PasswordChangedAt, &user.TokenVersion, &user.Role)
 	if err != nil {
 		if err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"` {
 			return ErrDuplicateEmail
@@ -96,10 +89,13 @@ func (u *UserModel) Insert(user *User) error {
 	return nil
 }
 
-func (u *UserModel) Delete(id string) error {
+func (u *UserModel) Delete(ctx context.Context, id string) (err error) {
+	ctx, span := startSpan(ctx, "UserModel.Delete")
+	defer func() { endSpan(span, err) }()
+
 	query := `DELETE FROM users WHERE id = $1`
 
-	_, err := u.DB.Exec(query, id)
+	_, err = u.DB.ExecContext(ctx, query, id)
 	if err != nil {
 		return err
 	}
@@ -107,14 +103,18 @@ func (u *UserModel) Delete(id string) error {
 	return nil
 }
 
-func (u *UserModel) Update(user *User) error {
+func (u *UserModel) Update(ctx context.Context, user *User) (err error) {
+	ctx, span := startSpan(ctx, "UserModel.Update")
+	defer func() { endSpan(span, err) }()
+
 	query := `UPDATE users SET
 	username = $1,
 	activated = $2,
-	password_hash = $3
-	WHERE id = $4`
+	password_hash = $3,
+	password_pepper_id = NULLIF($4, '')
+	WHERE id = $5`
 
-	_, err := u.DB.Exec(query, user.Username, user.Activated, user.Password.hash, user.ID)
+	_, err = u.DB.ExecContext(ctx, query, user.Username, user.Activated, user.Password.hash, user.Password.pepperID, user.ID)
 	if err != nil {
 		return err
 	}
@@ -122,19 +122,170 @@ func (u *UserModel) Update(user *User) error {
 	return nil
 }
 
-func (u *UserModel) GetByEmail(email string) (*User, error) {
-	query := `SELECT id, email, username, password_hash, activated, created_at, updated_at 
-	FROM users WHERE email = $1`
+// BumpTokenVersion makes every access token issued to user id so far
+// stale and returns the new version. logout_all calls it; writes that
+// change what the tokens vouch for, such as a new password or role, bump
+// the version in the same statement instead.
+func (u *UserModel) BumpTokenVersion(ctx context.Context, id string) (_ int, err error) {
+	ctx, span := startSpan(ctx, "UserModel.BumpTokenVersion")
+	defer func() { endSpan(span, err) }()
+
+	var version int
+	err = u.DB.QueryRowContext(ctx, `UPDATE users SET token_version = token_version + 1 WHERE id = $1 RETURNING token_version`, id).Scan(&version)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrNoRecord
+		}
+		return 0, err
+	}
+	return version, nil
+}
+
+// SetRole gives user id role and returns their token version, which is
+// bumped when the role changed so that the tokens issued under the old one
+// go stale.
+func (u *UserModel) SetRole(ctx context.Context, id, role string) (_ int, changed bool, err error) {
+	ctx, span := startSpan(ctx, "UserModel.SetRole")
+	defer func() { endSpan(span, err) }()
+
+	query := `UPDATE users SET
+	role = $2,
+	token_version = users.token_version + CASE WHEN previous.role <> $2 THEN 1 ELSE 0 END
+	FROM (SELECT role FROM users WHERE id = $1 FOR UPDATE) previous
+	WHERE users.id = $1
+	RETURNING users.token_version, previous.role <> $2`
+
+	var version int
+	err = u.DB.QueryRowContext(ctx, query, id, role).Scan(&version, &changed)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, false, ErrNoRecord
+		}
+		return 0, false, err
+	}
+	return version, changed, nil
+}
+
+// GetTokenVersion returns the token version of user id.
+func (u *UserModel) GetTokenVersion(ctx context.Context, id string) (_ int, err error) {
+	ctx, span := startSpan(ctx, "UserModel.GetTokenVersion")
+	defer func() { endSpan(span, err) }()
+
+	var version int
+	err = u.DB.QueryRowContext(ctx, `SELECT token_version FROM users WHERE id = $1`, id).Scan(&version)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrNoRecord
+		}
+		return 0, err
+	}
+	return version, nil
+}
+
+// UpdatePasswordHash stores the current password hash of user and the key
+// ID of its pepper.
+func (u *UserModel) UpdatePasswordHash(ctx context.Context, user *User) (err error) {
+	ctx, span := startSpan(ctx, "UserModel.UpdatePasswordHash")
+	defer func() { endSpan(span, err) }()
+
+	query := `UPDATE users SET password_hash = $1, password_pepper_id = NULLIF($2, '') WHERE id = $3`
+
+	_, err = u.DB.ExecContext(ctx, query, user.Password.hash, user.Password.pepperID, user.ID)
+	return err
+}
+
+// ChangePassword stores the new password hash of user and moves the one it
+// replaces into the password history, of which the keep most recent entries
+// are kept. The token version is bumped.
+func (u *UserModel) ChangePassword(ctx context.Context, user *User, keep int) (err error) {
+	ctx, span := startSpan(ctx, "UserModel.ChangePassword")
+	defer func() { endSpan(span, err) }()
+
+	tx, err := u.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `INSERT INTO password_history (user_id, password_hash, password_pepper_id, created_at)
+	SELECT id, password_hash, password_pepper_id, password_changed_at FROM users WHERE id = $1`
+
+	if _, err = tx.ExecContext(ctx, query, user.ID); err != nil {
+		return err
+	}
+
+	query = `UPDATE users SET password_hash = $1, password_pepper_id = NULLIF($2, ''), password_changed_at = NOW(),
+	token_version = token_version + 1
+	WHERE id = $3
+	RETURNING password_changed_at, token_version`
+
+	err = tx.QueryRowContext(ctx, query, user.Password.hash, user.Password.pepperID, user.ID).Scan(&user.PasswordChangedAt, &user.TokenVersion)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNoRecord
+		}
+		return err
+	}
+
+	query = `DELETE FROM password_history WHERE user_id = $1 AND id NOT IN (
+		SELECT id FROM password_history WHERE user_id = $1 ORDER BY created_at DESC, id DESC LIMIT $2
+	)`
+
+	if _, err = tx.ExecContext(ctx, query, user.ID, keep); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// PasswordHistory returns up to n of the passwords userID had before the
+// current one, most recent first.
+func (u *UserModel) PasswordHistory(ctx context.Context, userID string, n int) (_ []password, err error) {
+	ctx, span := startSpan(ctx, "UserModel.PasswordHistory")
+	defer func() { endSpan(span, err) }()
+
+	query := `SELECT password_hash, COALESCE(password_pepper_id, '') FROM password_history
+	WHERE user_id = $1
+	ORDER BY created_at DESC, id DESC
+	LIMIT $2`
+
+	rows, err := u.DB.QueryContext(ctx, query, userID, n)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var history []password
+	for rows.Next() {
+		var p password
+		if err := rows.Scan(&p.hash, &p.pepperID); err != nil {
+			return nil, err
+		}
+		history = append(history, p)
+	}
+	return history, rows.Err()
+}
+
+func (u *UserModel) GetByEmail(ctx context.Context, email string) (_ *User, err error) {
+	ctx, span := startSpan(ctx, "UserModel.GetByEmail")
+	defer func() { endSpan(span, err) }()
+
+	query := `SELECT id, email, username, password_hash, COALESCE(password_pepper_id, ''), activated, created_at, updated_at, 
+	password_changed_at, token_version, role FROM users WHERE email = $1`
 	var user User
 
-	err := u.DB.QueryRow(query, email).Scan(
+	err = u.DB.QueryRowContext(ctx, query, email).Scan(
 		&user.ID,
 		&user.Email,
 		&user.Username,
 		&user.Password.hash,
+		&user.Password.pepperID,
 		&user.Activated,
 		&user.CreatedAt,
 		&user.UpdatedAt,
+		&user.PasswordChangedAt,
+		&user.TokenVersion,
+		&user.Role,
 	)
 
 	if err != nil {
@@ -147,19 +298,26 @@ func (u *UserModel) GetByEmail(email string) (*User, error) {
 	return &user, nil
 }
 
-func (u *UserModel) GetByID(id string) (*User, error) {
-	query := `SELECT id, email, username, password_hash, activated, created_at, updated_at 
-	FROM users WHERE id = $1`
+func (u *UserModel) GetByID(ctx context.Context, id string) (_ *User, err error) {
+	ctx, span := startSpan(ctx, "UserModel.GetByID")
+	defer func() { endSpan(span, err) }()
+
+	query := `SELECT id, email, username, password_hash, COALESCE(password_pepper_id, ''), activated, created_at, updated_at, 
+	password_changed_at, token_version, role FROM users WHERE id = $1`
 	var user User
 
-	err := u.DB.QueryRow(query, id).Scan(
+	err = u.DB.QueryRowContext(ctx, query, id).Scan(
 		&user.ID,
 		&user.Email,
 		&user.Username,
 		&user.Password.hash,
+		&user.Password.pepperID,
 		&user.Activated,
 		&user.CreatedAt,
 		&user.UpdatedAt,
+		&user.PasswordChangedAt,
+		&user.TokenVersion,
+		&user.Role,
 	)
 
 	if err != nil {
@@ -0,0 +1,157 @@
+// Package v1 holds the request and response messages of the auth.v1.*
+// subjects. Within v1 a message only ever gains optional fields, which
+// callers must ignore when they do not know them; no field is removed,
+// renamed or given another type or meaning. A change that needs any of
+// that is a new API version, with its own package next to this one.
+package v1
+
+import (
+	"auth/internal/data"
+	"embed"
+	"time"
+)
+
+// Schemas holds the JSON schema of every v1 request and response, named
+// <endpoint>.request.json and <endpoint>.response.json.
+//
+//go:embed schemas/*.json
+var Schemas embed.FS
+
+// Input messages declare their rules in validate tags; see
+// validator.Struct.
+
+type RegisterInput struct {
+	Email    string `json:"email" validate:"required,email,max_chars=255"`
+	Password string `json:"password" validate:"required,min_bytes=8,max_bytes=1024"`
+	Username string `json:"username" validate:"required,min_chars=4,max_chars=100"`
+}
+type LoginInput struct {
+	Email      string `json:"email" validate:"required,email,max_chars=255"`
+	Password   string `json:"password" validate:"required,min_bytes=8,max_bytes=1024"`
+	DeviceName string `json:"device_name" validate:"max_chars=200"`
+	DeviceType string `json:"device_type" validate:"oneof=desktop|mobile|tablet"`
+	RememberMe bool   `json:"remember_me"`
+	IPAddress  string `json:"ip_address" validate:"ip"`
+	UserAgent  string `json:"user_agent" validate:"max_bytes=1024"`
+}
+
+type LogoutInput struct {
+	SessionID string `json:"session_id" validate:"required,uuid"`
+}
+type AccessTokenInput struct {
+	TokenString string `json:"access_token" validate:"required"`
+}
+
+type RefreshTokenInput struct {
+	TokenString string `json:"refresh_token" validate:"required"`
+}
+
+type ChangePasswordInput struct {
+	TokenString     string `json:"access_token" validate:"required"`
+	CurrentPassword string `json:"current_password" validate:"required,max_bytes=1024"`
+	NewPassword     string `json:"new_password" validate:"required,min_bytes=8,max_bytes=1024"`
+}
+
+type SessionResponse struct {
+	SessionID         string    `json:"session_id"`
+	DeviceName        string    `json:"device_name"`
+	DeviceType        string    `json:"device_type"`
+	LastUsedAt        time.Time `json:"last_used_at"`
+	ExpiresAt         time.Time `json:"expires_at"`
+	AbsoluteExpiresAt time.Time `json:"absolute_expires_at"`
+	// The client details below are empty when unknown. Country is an ISO
+	// 3166-1 alpha-2 code.
+	IPAddress string `json:"ip_address"`
+	Browser   string `json:"browser"`
+	OS        string `json:"os"`
+	Device    string `json:"device"`
+	Country   string `json:"country"`
+	City      string `json:"city"`
+}
+type LoginResponse struct {
+	RefreshToken   string            `json:"refresh_token"`
+	AccessToken    string            `json:"access_token"`
+	CurrentSession SessionResponse   `json:"current_session"`
+	OtherSessions  []SessionResponse `json:"other_sessions"`
+	// EvictedSessions were revoked to make room for the new session.
+	EvictedSessions []SessionResponse `json:"evicted_sessions"`
+}
+
+// PasswordExpiredResponse is the data of the AUTH_PASSWORD_EXPIRED reply to
+// a login whose password is too old. PasswordChangeToken is only accepted
+// by change_password, as its access_token.
+type PasswordExpiredResponse struct {
+	PasswordChangeToken string `json:"password_change_token"`
+}
+
+// BreakGlassInput says why every session is revoked, for the audit trail.
+// Who revokes them is taken from the admin token; an actor sent along is
+// ignored.
+type BreakGlassInput struct {
+	Reason string `json:"reason" validate:"required,max_chars=1000"`
+}
+type BreakGlassResponse struct {
+	ID              int64     `json:"id"`
+	NotBefore       time.Time `json:"not_before"`
+	KeyID           string    `json:"key_id"`
+	SessionsRevoked int64     `json:"sessions_revoked"`
+}
+
+// SetRoleInput gives the user UserID the role Role, which selects their
+// entry in session.role_max_sessions.
+type SetRoleInput struct {
+	UserID string `json:"user_id" validate:"required,uuid"`
+	Role   string `json:"role" validate:"required,max_chars=100"`
+}
+type SetRoleResponse struct {
+	UserID string `json:"user_id"`
+	Role   string `json:"role"`
+	// TokensRevoked is whether the role changed, which makes the access
+	// tokens issued to the user stale.
+	TokensRevoked bool `json:"tokens_revoked"`
+}
+type TokenValidationResponse struct {
+	UserID   string `json:"id"`
+	Email    string `json:"email"`
+	Username string `json:"username"`
+}
+
+// TokenRefreshResponse carries the session's new idle expiry, which the
+// refresh pushed forward, and its absolute expiry, which no refresh can.
+type TokenRefreshResponse struct {
+	AccessToken       string    `json:"access_token"`
+	ExpiresAt         time.Time `json:"expires_at"`
+	AbsoluteExpiresAt time.Time `json:"absolute_expires_at"`
+}
+
+// NewSessionResponse returns the v1 representation of s.
+func NewSessionResponse(s data.Session) SessionResponse {
+	var ipAddress string
+	if s.IPAddress != nil {
+		ipAddress = *s.IPAddress
+	}
+	return SessionResponse{
+		SessionID:         s.SessionID,
+		DeviceName:        s.DeviceName,
+		DeviceType:        s.DeviceType,
+		LastUsedAt:        s.LastUsedAt,
+		ExpiresAt:         s.ExpiresAt,
+		AbsoluteExpiresAt: s.AbsoluteExpiresAt,
+		IPAddress:         ipAddress,
+		Browser:           s.Browser,
+		OS:                s.OS,
+		Device:            s.Device,
+		Country:           s.Country,
+		City:              s.City,
+	}
+}
+
+// NewSessionResponses returns the v1 representation of ss, which is empty
+// rather than nil when ss is.
+func NewSessionResponses(ss []data.Session) []SessionResponse {
+	responses := make([]SessionResponse, len(ss))
+	for i, s := range ss {
+		responses[i] = NewSessionResponse(s)
+	}
+	return responses
+}
@@ -0,0 +1,236 @@
+package geoip
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Data section types. Types above 7 are stored as an extended type byte.
+const (
+	typeExtended = iota
+	typePointer
+	typeString
+	typeDouble
+	typeBytes
+	typeUint16
+	typeUint32
+	typeMap
+	typeInt32
+	typeUint64
+	typeUint128
+	typeArray
+	typeContainer
+	typeEndMarker
+	typeBool
+	typeFloat
+)
+
+// maxDepth bounds the nesting of maps, arrays and pointers, so that a
+// corrupt file cannot exhaust the stack.
+const maxDepth = 32
+
+var (
+	errTruncated = errors.New("data section is truncated")
+	errTooDeep   = errors.New("data nested too deeply")
+)
+
+// decoder reads values from the data section of a database. Maps become
+// map[string]any, arrays []any, strings string, unsigned integers uint64,
+// int32 int64, floats and doubles float64 and booleans bool; uint128 and
+// bytes are returned as []byte.
+type decoder struct {
+	buf   []byte
+	depth int
+}
+
+// decode returns the value at offset and the offset just past it.
+func (d *decoder) decode(offset uint) (any, uint, error) {
+	typ, size, offset, err := d.control(offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if typ == typePointer {
+		target, next, err := d.pointer(size, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		// Pointers count towards the depth, so that a corrupt file whose
+		// pointers form a cycle cannot make this loop.
+		if d.depth++; d.depth > maxDepth {
+			return nil, 0, errTooDeep
+		}
+		v, _, err := d.decode(target)
+		d.depth--
+		return v, next, err
+	}
+	return d.value(typ, size, offset)
+}
+
+// control reads the control byte at offset and returns the type and size
+// of the value that follows, and where it starts.
+func (d *decoder) control(offset uint) (typ int, size, next uint, err error) {
+	if offset >= uint(len(d.buf)) {
+		return 0, 0, 0, errTruncated
+	}
+	ctrl := d.buf[offset]
+	offset++
+
+	typ = int(ctrl >> 5)
+	if typ == typeExtended {
+		if offset >= uint(len(d.buf)) {
+			return 0, 0, 0, errTruncated
+		}
+		typ = 7 + int(d.buf[offset])
+		offset++
+	}
+
+	size = uint(ctrl & 0x1f)
+	if typ == typePointer || size < 29 {
+		return typ, size, offset, nil
+	}
+
+	n := size - 28
+	b, err := d.bytes(offset, n)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	switch n {
+	case 1:
+		size = 29 + uint(b[0])
+	case 2:
+		size = 285 + (uint(b[0])<<8 | uint(b[1]))
+	case 3:
+		size = 65821 + (uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2]))
+	}
+	return typ, size, offset + n, nil
+}
+
+// pointer decodes a pointer whose control byte carried size bits.
+func (d *decoder) pointer(size, offset uint) (target, next uint, err error) {
+	n := (size>>3)&0x3 + 1
+	b, err := d.bytes(offset, n)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	high := size & 0x7
+	switch n {
+	case 1:
+		target = high<<8 | uint(b[0])
+	case 2:
+		target = (high<<16 | uint(b[0])<<8 | uint(b[1])) + 2048
+	case 3:
+		target = (high<<24 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])) + 526336
+	case 4:
+		target = uint(binary.BigEndian.Uint32(b))
+	}
+	return target, offset + n, nil
+}
+
+func (d *decoder) value(typ int, size, offset uint) (any, uint, error) {
+	switch typ {
+	case typeMap:
+		return d.decodeMap(size, offset)
+	case typeArray:
+		return d.decodeArray(size, offset)
+	case typeBool:
+		return size != 0, offset, nil
+	}
+
+	b, err := d.bytes(offset, size)
+	if err != nil {
+		return nil, 0, err
+	}
+	next := offset + size
+
+	switch typ {
+	case typeString:
+		return string(b), next, nil
+	case typeBytes, typeUint128:
+		return b, next, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, fmt.Errorf("double of %d bytes", size)
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), next, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, fmt.Errorf("float of %d bytes", size)
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), next, nil
+	case typeUint16, typeUint32, typeUint64:
+		if size > 8 {
+			return nil, 0, fmt.Errorf("unsigned integer of %d bytes", size)
+		}
+		var n uint64
+		for _, c := range b {
+			n = n<<8 | uint64(c)
+		}
+		return n, next, nil
+	case typeInt32:
+		if size > 4 {
+			return nil, 0, fmt.Errorf("int32 of %d bytes", size)
+		}
+		var n uint32
+		for _, c := range b {
+			n = n<<8 | uint32(c)
+		}
+		return int64(int32(n)), next, nil
+	default:
+		return nil, 0, fmt.Errorf("unexpected type %d", typ)
+	}
+}
+
+func (d *decoder) decodeMap(size, offset uint) (any, uint, error) {
+	if d.depth++; d.depth > maxDepth {
+		return nil, 0, errTooDeep
+	}
+	defer func() { d.depth-- }()
+
+	m := make(map[string]any, min(size, 64))
+	for range size {
+		k, next, err := d.decode(offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		key, ok := k.(string)
+		if !ok {
+			return nil, 0, errors.New("map key is not a string")
+		}
+		v, next, err := d.decode(next)
+		if err != nil {
+			return nil, 0, err
+		}
+		m[key] = v
+		offset = next
+	}
+	return m, offset, nil
+}
+
+func (d *decoder) decodeArray(size, offset uint) (any, uint, error) {
+	if d.depth++; d.depth > maxDepth {
+		return nil, 0, errTooDeep
+	}
+	defer func() { d.depth-- }()
+
+	a := make([]any, 0, min(size, 64))
+	for range size {
+		v, next, err := d.decode(offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		a = append(a, v)
+		offset = next
+	}
+	return a, offset, nil
+}
+
+func (d *decoder) bytes(offset, n uint) ([]byte, error) {
+	if offset+n > uint(len(d.buf)) || offset+n < offset {
+		return nil, errTruncated
+	}
+	return d.buf[offset : offset+n], nil
+}
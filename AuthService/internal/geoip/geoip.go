@@ -0,0 +1,215 @@
+// Package geoip resolves IP addresses to a country, city and approximate
+// coordinates with a local database in the MaxMind DB format, such as
+// GeoLite2-City or GeoIP2-City.
+// Lookups run offline; no address ever leaves the process.
+package geoip
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"sync/atomic"
+)
+
+// Location is where an address was placed. Fields the database does not
+// have for the address are empty.
+type Location struct {
+	// Country is the ISO 3166-1 alpha-2 code of the country, such as "AR".
+	Country string
+	// CountryName and City are the English names.
+	CountryName string
+	City        string
+	// Latitude and Longitude place the address within AccuracyRadius
+	// kilometers. AccuracyRadius is 0 when the database has no coordinates
+	// for it.
+	Latitude       float64
+	Longitude      float64
+	AccuracyRadius int
+}
+
+// Reader looks addresses up in a database loaded into memory. It is safe
+// for concurrent use.
+type Reader struct {
+	buf        []byte
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	// data is the data section, which pointers are relative to.
+	data []byte
+	// ipv4Start is the node IPv4 lookups start from in an IPv6 tree, where
+	// IPv4 addresses live under ::/96.
+	ipv4Start uint
+	closed    atomic.Bool
+}
+
+// ErrClosed is returned by lookups on a closed Reader.
+var ErrClosed = errors.New("geoip database is closed")
+
+var metadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// dataSectionSeparator is the number of zero bytes between the search tree
+// and the data section.
+const dataSectionSeparator = 16
+
+// Open loads the database at path.
+func Open(path string) (*Reader, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := New(buf)
+	if err != nil {
+		return nil, fmt.Errorf("geoip database %s: %w", path, err)
+	}
+	return r, nil
+}
+
+// New reads a database from buf, which it keeps.
+func New(buf []byte) (*Reader, error) {
+	i := bytes.LastIndex(buf, metadataMarker)
+	if i < 0 {
+		return nil, errors.New("not a MaxMind DB file: metadata not found")
+	}
+
+	metaSection := buf[i+len(metadataMarker):]
+	v, _, err := (&decoder{buf: metaSection}).decode(0)
+	if err != nil {
+		return nil, fmt.Errorf("metadata: %w", err)
+	}
+	meta, ok := v.(map[string]any)
+	if !ok {
+		return nil, errors.New("metadata is not a map")
+	}
+
+	r := &Reader{
+		buf:        buf,
+		nodeCount:  uintField(meta, "node_count"),
+		recordSize: uintField(meta, "record_size"),
+		ipVersion:  uintField(meta, "ip_version"),
+	}
+	switch r.recordSize {
+	case 24, 28, 32:
+	default:
+		return nil, fmt.Errorf("unsupported record size %d", r.recordSize)
+	}
+	if r.ipVersion != 4 && r.ipVersion != 6 {
+		return nil, fmt.Errorf("unsupported ip version %d", r.ipVersion)
+	}
+
+	treeSize := r.nodeCount * r.recordSize / 4
+	if treeSize+dataSectionSeparator > uint(i) {
+		return nil, errors.New("search tree is larger than the file")
+	}
+	r.data = buf[treeSize+dataSectionSeparator : i]
+
+	if r.ipVersion == 6 {
+		node := uint(0)
+		for range 96 {
+			if node >= r.nodeCount {
+				break
+			}
+			node = r.record(node, 0)
+		}
+		r.ipv4Start = node
+	}
+	return r, nil
+}
+
+// Lookup returns the location of addr. An address that is not in the
+// database, or is IPv6 in an IPv4 database, gets an empty Location.
+func (r *Reader) Lookup(addr netip.Addr) (Location, error) {
+	if r.closed.Load() {
+		return Location{}, ErrClosed
+	}
+	addr = addr.Unmap()
+
+	node := uint(0)
+	if addr.Is4() && r.ipVersion == 6 {
+		node = r.ipv4Start
+	} else if addr.Is6() && r.ipVersion == 4 {
+		return Location{}, nil
+	}
+
+	ip := addr.AsSlice()
+	for i := 0; i < len(ip)*8 && node < r.nodeCount; i++ {
+		bit := uint(ip[i/8]>>(7-i%8)) & 1
+		node = r.record(node, bit)
+	}
+
+	switch {
+	case node == r.nodeCount:
+		return Location{}, nil
+	case node < r.nodeCount:
+		return Location{}, errors.New("search tree is deeper than the address")
+	}
+
+	offset := node - r.nodeCount - dataSectionSeparator
+	v, _, err := (&decoder{buf: r.data}).decode(offset)
+	if err != nil {
+		return Location{}, err
+	}
+	return location(v), nil
+}
+
+// Close stops r from answering lookups, so that a database that has been
+// replaced is not used by mistake, and lets its memory go once the lookups
+// already running are done. The file itself was closed by Open.
+func (r *Reader) Close() error {
+	r.closed.Store(true)
+	return nil
+}
+
+// record returns the left (bit 0) or right (bit 1) record of node.
+func (r *Reader) record(node, bit uint) uint {
+	b := r.buf[node*r.recordSize/4:]
+	switch r.recordSize {
+	case 24:
+		b = b[bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		// The middle byte holds the high nibble of both records.
+		if bit == 0 {
+			return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		b = b[bit*4:]
+		return uint(b[0])<<24 | uint(b[1])<<16 | uint(b[2])<<8 | uint(b[3])
+	}
+}
+
+// location picks the fields of a City database record.
+func location(v any) Location {
+	record, _ := v.(map[string]any)
+	country, _ := record["country"].(map[string]any)
+	city, _ := record["city"].(map[string]any)
+	coordinates, _ := record["location"].(map[string]any)
+
+	code, _ := country["iso_code"].(string)
+	loc := Location{
+		Country:     code,
+		CountryName: englishName(country),
+		City:        englishName(city),
+	}
+
+	lat, latOK := coordinates["latitude"].(float64)
+	lon, lonOK := coordinates["longitude"].(float64)
+	if latOK && lonOK {
+		loc.Latitude, loc.Longitude = lat, lon
+		loc.AccuracyRadius = max(int(uintField(coordinates, "accuracy_radius")), 1)
+	}
+	return loc
+}
+
+func englishName(m map[string]any) string {
+	names, _ := m["names"].(map[string]any)
+	name, _ := names["en"].(string)
+	return name
+}
+
+func uintField(m map[string]any, key string) uint {
+	n, _ := m[key].(uint64)
+	return uint(n)
+}
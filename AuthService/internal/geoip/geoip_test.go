@@ -0,0 +1,246 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"net/netip"
+	"slices"
+	"sort"
+	"testing"
+)
+
+// pointer is encoded as a pointer to the given offset in the data section.
+type pointer uint
+
+// encode writes v in the data section format.
+func encode(v any) []byte {
+	control := func(typ int, size int) []byte {
+		var b []byte
+		if typ > 7 {
+			b = []byte{0, byte(typ - 7)}
+		} else {
+			b = []byte{byte(typ << 5)}
+		}
+		if size >= 29 {
+			b[0] |= 29
+			return append(b, byte(size-29))
+		}
+		b[0] |= byte(size)
+		return b
+	}
+
+	switch v := v.(type) {
+	case pointer:
+		return []byte{byte(typePointer<<5 | int(v>>8)&0x7), byte(v)}
+	case string:
+		return append(control(typeString, len(v)), v...)
+	case uint16:
+		return append(control(typeUint16, 2), byte(v>>8), byte(v))
+	case uint32:
+		return append(control(typeUint32, 4), binary.BigEndian.AppendUint32(nil, v)...)
+	case float64:
+		return append(control(typeDouble, 8), binary.BigEndian.AppendUint64(nil, math.Float64bits(v))...)
+	case []any:
+		b := control(typeArray, len(v))
+		for _, e := range v {
+			b = append(b, encode(e)...)
+		}
+		return b
+	case map[string]any:
+		b := control(typeMap, len(v))
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			b = append(b, encode(k)...)
+			b = append(b, encode(v[k])...)
+		}
+		return b
+	}
+	panic("cannot encode value")
+}
+
+// buildDB returns a database in which each network is mapped to the data
+// section offset in records, with data written after the search tree.
+func buildDB(ipVersion, recordSize int, data []byte, networks map[string]uint) []byte {
+	const empty = -1
+	type ref struct {
+		node int
+		data uint
+		leaf bool
+	}
+	nodes := [][2]ref{{{node: empty}, {node: empty}}}
+
+	for network, offset := range networks {
+		prefix := netip.MustParsePrefix(network)
+		ip, bits := prefix.Addr().AsSlice(), prefix.Bits()
+		if prefix.Addr().Is4() && ipVersion == 6 {
+			ip, bits = append(make([]byte, 12), ip...), bits+96
+		}
+
+		node := 0
+		for i := range bits {
+			bit := ip[i/8] >> (7 - i%8) & 1
+			if i == bits-1 {
+				nodes[node][bit] = ref{data: offset, leaf: true}
+				break
+			}
+			if nodes[node][bit].node == empty || nodes[node][bit].leaf {
+				nodes = append(nodes, [2]ref{{node: empty}, {node: empty}})
+				nodes[node][bit] = ref{node: len(nodes) - 1}
+			}
+			node = nodes[node][bit].node
+		}
+	}
+
+	nodeCount := uint(len(nodes))
+	value := func(r ref) uint {
+		switch {
+		case r.leaf:
+			return nodeCount + dataSectionSeparator + r.data
+		case r.node == empty:
+			return nodeCount
+		}
+		return uint(r.node)
+	}
+
+	var buf []byte
+	for _, n := range nodes {
+		left, right := value(n[0]), value(n[1])
+		switch recordSize {
+		case 24:
+			buf = append(buf, byte(left>>16), byte(left>>8), byte(left), byte(right>>16), byte(right>>8), byte(right))
+		case 28:
+			buf = append(buf, byte(left>>16), byte(left>>8), byte(left),
+				byte(left>>24&0x0f)<<4|byte(right>>24&0x0f),
+				byte(right>>16), byte(right>>8), byte(right))
+		case 32:
+			buf = binary.BigEndian.AppendUint32(buf, uint32(left))
+			buf = binary.BigEndian.AppendUint32(buf, uint32(right))
+		}
+	}
+	buf = append(buf, make([]byte, dataSectionSeparator)...)
+	buf = append(buf, data...)
+	buf = append(buf, metadataMarker...)
+	return append(buf, encode(map[string]any{
+		"node_count":    uint32(nodeCount),
+		"record_size":   uint16(recordSize),
+		"ip_version":    uint16(ipVersion),
+		"database_type": "Test-City",
+		"languages":     []any{"en"},
+	})...)
+}
+
+func TestLookup(t *testing.T) {
+	// Both records point at the same country map, as real databases do.
+	argentina := encode(map[string]any{"iso_code": "AR", "names": map[string]any{"en": "Argentina"}})
+	buenosAires := encode(map[string]any{
+		"country": pointer(0),
+		"city":    map[string]any{"names": map[string]any{"en": "Buenos Aires", "es": "Buenos Aires"}},
+		"location": map[string]any{
+			"latitude":        -34.6131,
+			"longitude":       -58.3772,
+			"accuracy_radius": uint16(50),
+		},
+	})
+	countryOnly := encode(map[string]any{"country": pointer(0)})
+	spain := encode(map[string]any{
+		"country": map[string]any{"iso_code": "ES", "names": map[string]any{"en": "Spain"}},
+		"city":    map[string]any{"names": map[string]any{"en": "Madrid"}},
+	})
+
+	data := slices.Concat(argentina, buenosAires, countryOnly, spain)
+	offsets := map[string]uint{
+		"181.0.0.0/12":   uint(len(argentina)),
+		"190.0.0.0/8":    uint(len(argentina) + len(buenosAires)),
+		"2a02:9000::/23": uint(len(argentina) + len(buenosAires) + len(countryOnly)),
+	}
+
+	buenosAiresLocation := Location{
+		Country:        "AR",
+		CountryName:    "Argentina",
+		City:           "Buenos Aires",
+		Latitude:       -34.6131,
+		Longitude:      -58.3772,
+		AccuracyRadius: 50,
+	}
+
+	tests := []struct {
+		addr string
+		want Location
+	}{
+		{"181.15.1.1", buenosAiresLocation},
+		{"::ffff:181.1.2.3", buenosAiresLocation},
+		{"190.210.1.1", Location{Country: "AR", CountryName: "Argentina"}},
+		{"2a02:9001::1", Location{Country: "ES", CountryName: "Spain", City: "Madrid"}},
+		{"181.16.0.1", Location{}},
+		{"8.8.8.8", Location{}},
+		{"2001:db8::1", Location{}},
+	}
+
+	for _, recordSize := range []int{24, 28, 32} {
+		r, err := New(buildDB(6, recordSize, data, offsets))
+		if err != nil {
+			t.Fatalf("record size %d: %v", recordSize, err)
+		}
+		for _, tt := range tests {
+			got, err := r.Lookup(netip.MustParseAddr(tt.addr))
+			if err != nil {
+				t.Errorf("record size %d, %s: unexpected error: %v", recordSize, tt.addr, err)
+				continue
+			}
+			if got != tt.want {
+				t.Errorf("record size %d, %s: got %+v want %+v", recordSize, tt.addr, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestLookupIPv4Database(t *testing.T) {
+	record := encode(map[string]any{"country": map[string]any{"iso_code": "AR"}})
+	r, err := New(buildDB(4, 24, record, map[string]uint{"181.0.0.0/12": 0}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, _ := r.Lookup(netip.MustParseAddr("181.1.1.1")); got.Country != "AR" {
+		t.Errorf("got %+v want country AR", got)
+	}
+	if got, err := r.Lookup(netip.MustParseAddr("2a02:9001::1")); err != nil || got != (Location{}) {
+		t.Errorf("got %+v, %v for an IPv6 address want nothing", got, err)
+	}
+}
+
+func TestNewRejectsCorruptFiles(t *testing.T) {
+	valid := buildDB(6, 24, encode(map[string]any{}), map[string]uint{"10.0.0.0/8": 0})
+
+	tests := map[string][]byte{
+		"empty":              nil,
+		"no metadata":        bytes.ReplaceAll(valid, metadataMarker, []byte("not the marker!")),
+		"truncated tree":     valid[bytes.Index(valid, metadataMarker)-8:],
+		"bad record size":    bytes.Replace(valid, encode(uint16(24)), encode(uint16(20)), 1),
+		"truncated metadata": valid[:len(valid)-1],
+	}
+	for name, buf := range tests {
+		if _, err := New(buf); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestLookupAfterClose(t *testing.T) {
+	r, err := New(buildDB(4, 24, encode(map[string]any{}), map[string]uint{"10.0.0.0/8": 0}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Lookup(netip.MustParseAddr("10.1.1.1")); !errors.Is(err, ErrClosed) {
+		t.Errorf("got %v want %v", err, ErrClosed)
+	}
+}
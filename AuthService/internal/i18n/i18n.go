@@ -0,0 +1,162 @@
+// Package i18n translates the messages the service sends to its callers.
+// Messages are identified by a stable ID, the error code for error replies
+// and validation.<rule> for field errors, and every supported language has
+// a catalog mapping those IDs to fmt templates.
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Fallback is the language of the reference catalog, which holds every
+// message. It ends every fallback chain.
+const Fallback = "en"
+
+//go:embed locales/*.json
+var files embed.FS
+
+var catalogs = mustLoad(files)
+
+func mustLoad(fsys fs.FS) map[string]map[string]string {
+	paths, err := fs.Glob(fsys, "locales/*.json")
+	if err != nil {
+		panic(err)
+	}
+
+	catalogs := make(map[string]map[string]string, len(paths))
+	for _, p := range paths {
+		content, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			panic(err)
+		}
+		var catalog map[string]string
+		if err := json.Unmarshal(content, &catalog); err != nil {
+			panic(fmt.Sprintf("i18n: parse %s: %v", p, err))
+		}
+		catalogs[strings.TrimSuffix(path.Base(p), ".json")] = catalog
+	}
+	return catalogs
+}
+
+// Supported returns the languages that have a catalog, sorted.
+func Supported() []string {
+	locales := make([]string, 0, len(catalogs))
+	for locale := range catalogs {
+		locales = append(locales, locale)
+	}
+	slices.Sort(locales)
+	return locales
+}
+
+// IsSupported reports whether locale has a catalog of its own.
+func IsSupported(locale string) bool {
+	_, ok := catalogs[normalize(locale)]
+	return ok
+}
+
+// Localizer renders messages in the first language of its chain whose
+// catalog holds them.
+type Localizer struct {
+	chain []string
+}
+
+// New returns a Localizer for the given languages, most preferred first.
+// Each language tag is followed by its base language (es-MX, then es), and
+// the chain ends with defaultLocale and then Fallback. Languages without a
+// catalog are skipped.
+func New(preferred []string, defaultLocale string) Localizer {
+	var chain []string
+	add := func(locale string) {
+		if _, ok := catalogs[locale]; ok && !slices.Contains(chain, locale) {
+			chain = append(chain, locale)
+		}
+	}
+
+	for _, tag := range preferred {
+		tag = normalize(tag)
+		add(tag)
+		if base, _, ok := strings.Cut(tag, "-"); ok {
+			add(base)
+		}
+	}
+	add(normalize(defaultLocale))
+	add(Fallback)
+
+	return Localizer{chain: chain}
+}
+
+// Locale returns the language messages are rendered in when they exist in
+// it, the head of the chain.
+func (l Localizer) Locale() string {
+	if len(l.chain) == 0 {
+		return Fallback
+	}
+	return l.chain[0]
+}
+
+// T renders the message id with args. A message missing from every catalog
+// in the chain is rendered as its ID, so a gap shows up instead of an empty
+// string.
+func (l Localizer) T(id string, args ...any) string {
+	for _, locale := range l.chain {
+		if tmpl, ok := catalogs[locale][id]; ok {
+			if len(args) == 0 {
+				return tmpl
+			}
+			return fmt.Sprintf(tmpl, args...)
+		}
+	}
+	return id
+}
+
+// ParseAcceptLanguage returns the language tags of an Accept-Language value
+// ("es-MX,es;q=0.9,en;q=0.5") ordered by preference. Wildcards and tags
+// with a zero weight are dropped.
+func ParseAcceptLanguage(header string) []string {
+	type weighted struct {
+		tag string
+		q   float64
+	}
+
+	var tags []weighted
+	for part := range strings.SplitSeq(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		tag = strings.TrimSpace(tag)
+		if tag == "" || tag == "*" {
+			continue
+		}
+
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if q <= 0 {
+			continue
+		}
+		tags = append(tags, weighted{tag, q})
+	}
+
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+
+	out := make([]string, len(tags))
+	for i, t := range tags {
+		out[i] = t.tag
+	}
+	return out
+}
+
+func normalize(tag string) string {
+	return strings.ReplaceAll(strings.ToLower(strings.TrimSpace(tag)), "_", "-")
+}
@@ -0,0 +1,89 @@
+package i18n
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseAcceptLanguage(t *testing.T) {
+	tests := []struct {
+		header string
+		want   []string
+	}{
+		{"", nil},
+		{"es", []string{"es"}},
+		{"es-MX,es;q=0.9,en;q=0.5", []string{"es-MX", "es", "en"}},
+		{"en;q=0.5, es-AR", []string{"es-AR", "en"}},
+		{"fr;q=0, *;q=0.1, es;q=0.2", []string{"es"}},
+		{"es;q=bogus, en", []string{"en"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			got := ParseAcceptLanguage(tt.header)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %v want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLocalizerChain(t *testing.T) {
+	tests := []struct {
+		name          string
+		preferred     []string
+		defaultLocale string
+		want          string
+	}{
+		{"exact match", []string{"es"}, "en", "es"},
+		{"base language of a regional tag", []string{"es-MX"}, "en", "es"},
+		{"underscore tag", []string{"es_AR"}, "en", "es"},
+		{"first supported preference wins", []string{"fr", "es", "en"}, "en", "es"},
+		{"default for unsupported preferences", []string{"fr"}, "es", "es"},
+		{"fallback for unsupported default", nil, "fr", Fallback},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := New(tt.preferred, tt.defaultLocale).Locale()
+			if got != tt.want {
+				t.Errorf("got %q want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTranslate(t *testing.T) {
+	es := New([]string{"es"}, Fallback)
+
+	if got := es.T("validation.min_bytes", 8); got != "debe tener al menos 8 bytes" {
+		t.Errorf("got %q", got)
+	}
+	if got := es.T("no.such.message"); got != "no.such.message" {
+		t.Errorf("got %q want the message ID", got)
+	}
+
+	catalogs[Fallback]["test.only"] = "only in %s"
+	defer delete(catalogs[Fallback], "test.only")
+	if got := es.T("test.only", "en"); got != "only in en" {
+		t.Errorf("got %q want the fallback message", got)
+	}
+}
+
+// TestCatalogsComplete keeps the shipped catalogs in step: every message of
+// the reference catalog is translated, with the same verbs.
+func TestCatalogsComplete(t *testing.T) {
+	for _, locale := range Supported() {
+		for id, want := range catalogs[Fallback] {
+			got, ok := catalogs[locale][id]
+			if !ok {
+				t.Errorf("%s: missing %s", locale, id)
+				continue
+			}
+			if strings.Count(got, "%") != strings.Count(want, "%") {
+				t.Errorf("%s: %s has different arguments than %s", locale, id, Fallback)
+			}
+		}
+	}
+}
@@ -0,0 +1,160 @@
+// Package passhash hashes passwords into self-describing strings: bcrypt's
+// modular crypt format ($2a$<cost>$...) and the PHC string format for
+// Argon2id ($argon2id$v=19$m=<KiB>,t=<passes>,p=<lanes>$<salt>$<key>).
+// Because every hash names its algorithm and parameters, hashes made under
+// an older configuration keep verifying and can be replaced when the
+// password is next known.
+package passhash
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	// ErrPasswordTooLong is returned by Bcrypt.Hash for passwords longer
+	// than MaxBcryptLength bytes, which bcrypt would silently truncate.
+	ErrPasswordTooLong = errors.New("passhash: password too long for bcrypt")
+	// ErrUnknownAlgorithm is returned by Verify for a hash it cannot
+	// identify.
+	ErrUnknownAlgorithm = errors.New("passhash: unknown hash algorithm")
+	// ErrMalformedHash is returned by Verify for a hash that names a known
+	// algorithm but cannot be decoded.
+	ErrMalformedHash = errors.New("passhash: malformed hash")
+)
+
+// MaxBcryptLength is the longest password bcrypt can hash, in bytes.
+const MaxBcryptLength = 72
+
+// Hasher hashes new passwords with one algorithm and set of parameters.
+type Hasher interface {
+	// Hash returns the encoded hash of plaintext with a fresh salt.
+	Hash(plaintext string) (string, error)
+	// NeedsRehash reports whether encoded was made with another algorithm
+	// or other parameters than the ones this hasher uses.
+	NeedsRehash(encoded string) bool
+}
+
+// Verify reports whether plaintext matches encoded, whichever supported
+// algorithm and parameters it was made with.
+func Verify(plaintext, encoded string) (bool, error) {
+	switch {
+	case isBcrypt(encoded):
+		err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(plaintext))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+		return err == nil, err
+	case strings.HasPrefix(encoded, argon2idPrefix):
+		params, salt, key, err := decodeArgon2id(encoded)
+		if err != nil {
+			return false, err
+		}
+		other := argon2.IDKey([]byte(plaintext), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+		return subtle.ConstantTimeCompare(key, other) == 1, nil
+	default:
+		return false, ErrUnknownAlgorithm
+	}
+}
+
+// Bcrypt hashes passwords with bcrypt at Cost.
+type Bcrypt struct {
+	Cost int
+}
+
+func (b Bcrypt) Hash(plaintext string) (string, error) {
+	if len(plaintext) > MaxBcryptLength {
+		return "", ErrPasswordTooLong
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(plaintext), b.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func (b Bcrypt) NeedsRehash(encoded string) bool {
+	if !isBcrypt(encoded) {
+		return true
+	}
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != b.Cost
+}
+
+func isBcrypt(encoded string) bool {
+	for _, prefix := range []string{"$2a$", "$2b$", "$2y$"} {
+		if strings.HasPrefix(encoded, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	argon2idPrefix = "$argon2id$"
+	saltLength     = 16
+	keyLength      = 32
+)
+
+// Argon2id hashes passwords with Argon2id (RFC 9106). Memory is in KiB.
+type Argon2id struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+}
+
+func (a Argon2id) Hash(plaintext string) (string, error) {
+	salt := make([]byte, saltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(plaintext), salt, a.Iterations, a.Memory, a.Parallelism, keyLength)
+
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2idPrefix, argon2.Version, a.Memory, a.Iterations, a.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (a Argon2id) NeedsRehash(encoded string) bool {
+	if !strings.HasPrefix(encoded, argon2idPrefix) {
+		return true
+	}
+	params, salt, key, err := decodeArgon2id(encoded)
+	return err != nil || params != a || len(salt) != saltLength || len(key) != keyLength
+}
+
+func decodeArgon2id(encoded string) (params Argon2id, salt, key []byte, err error) {
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", salt, key
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return params, nil, nil, ErrMalformedHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, ErrMalformedHash
+	}
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism)
+	if err != nil {
+		return params, nil, nil, ErrMalformedHash
+	}
+
+	salt, err = base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, ErrMalformedHash
+	}
+	key, err = base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, ErrMalformedHash
+	}
+	return params, salt, key, nil
+}
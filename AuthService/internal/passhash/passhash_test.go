@@ -0,0 +1,172 @@
+package passhash
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// Cheap parameters keep the tests fast; the algorithms are the same.
+var (
+	testBcrypt = Bcrypt{Cost: 4}
+	testArgon2 = Argon2id{Memory: 64, Iterations: 1, Parallelism: 1}
+)
+
+func TestHashVerify(t *testing.T) {
+	long := strings.Repeat("ñ", 100)
+
+	tests := []struct {
+		name      string
+		hasher    Hasher
+		plaintext string
+		prefix    string
+	}{
+		{"bcrypt", testBcrypt, "correct horse", "$2a$04$"},
+		{"argon2id", testArgon2, "correct horse", "$argon2id$v=19$m=64,t=1,p=1$"},
+		{"argon2id beyond bcrypt's limit", testArgon2, long, "$argon2id$"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded, err := tt.hasher.Hash(tt.plaintext)
+			if err != nil {
+				t.Fatalf("hash: %v", err)
+			}
+			if !strings.HasPrefix(encoded, tt.prefix) {
+				t.Errorf("got %q want prefix %q", encoded, tt.prefix)
+			}
+
+			if ok, err := Verify(tt.plaintext, encoded); err != nil || !ok {
+				t.Errorf("expected the password to verify, got %v %v", ok, err)
+			}
+			if ok, err := Verify(tt.plaintext+"!", encoded); err != nil || ok {
+				t.Errorf("expected another password not to verify, got %v %v", ok, err)
+			}
+			if again, _ := tt.hasher.Hash(tt.plaintext); again == encoded {
+				t.Error("expected every hash to get a fresh salt")
+			}
+		})
+	}
+}
+
+func TestBcryptTooLong(t *testing.T) {
+	_, err := testBcrypt.Hash(strings.Repeat("a", MaxBcryptLength+1))
+	if !errors.Is(err, ErrPasswordTooLong) {
+		t.Errorf("got %v want ErrPasswordTooLong", err)
+	}
+}
+
+func TestVerifyInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		encoded string
+		want    error
+	}{
+		{"unknown algorithm", "$scrypt$ln=15,r=8,p=1$c2FsdA$a2V5", ErrUnknownAlgorithm},
+		{"plain text", "correct horse", ErrUnknownAlgorithm},
+		{"missing key", "$argon2id$v=19$m=64,t=1,p=1$c2FsdA", ErrMalformedHash},
+		{"other version", "$argon2id$v=16$m=64,t=1,p=1$c2FsdA$a2V5", ErrMalformedHash},
+		{"bad parameters", "$argon2id$v=19$m=x,t=1,p=1$c2FsdA$a2V5", ErrMalformedHash},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Verify("correct horse", tt.encoded); !errors.Is(err, tt.want) {
+				t.Errorf("got %v want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	bcryptHash, _ := testBcrypt.Hash("correct horse")
+	argon2Hash, _ := testArgon2.Hash("correct horse")
+
+	tests := []struct {
+		name    string
+		hasher  Hasher
+		encoded string
+		want    bool
+	}{
+		{"same bcrypt cost", testBcrypt, bcryptHash, false},
+		{"other bcrypt cost", Bcrypt{Cost: 5}, bcryptHash, true},
+		{"bcrypt to argon2id", testArgon2, bcryptHash, true},
+		{"same argon2id parameters", testArgon2, argon2Hash, false},
+		{"more argon2id memory", Argon2id{Memory: 128, Iterations: 1, Parallelism: 1}, argon2Hash, true},
+		{"argon2id to bcrypt", testBcrypt, argon2Hash, true},
+		{"malformed", testArgon2, "$argon2id$v=19$", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.hasher.NeedsRehash(tt.encoded); got != tt.want {
+				t.Errorf("got %v want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPepper(t *testing.T) {
+	if got := NewPepper("").Apply("correct horse"); got != "correct horse" {
+		t.Errorf("expected no pepper to leave the password unchanged, got %q", got)
+	}
+
+	a, b := NewPepper("first pepper key"), NewPepper("second pepper key")
+	if a.ID == "" || a.ID == b.ID {
+		t.Errorf("expected distinct key IDs, got %q and %q", a.ID, b.ID)
+	}
+	if a.Apply("correct horse") == b.Apply("correct horse") {
+		t.Error("expected different peppers to give different inputs")
+	}
+
+	long := strings.Repeat("a", 2*MaxBcryptLength)
+	encoded, err := testBcrypt.Hash(a.Apply(long))
+	if err != nil {
+		t.Fatalf("expected a peppered password to fit bcrypt: %v", err)
+	}
+	if ok, _ := Verify(a.Apply(long[:MaxBcryptLength]+"b"), encoded); ok {
+		t.Error("expected peppering to keep bytes beyond bcrypt's limit significant")
+	}
+}
+
+// BenchmarkVerify measures a comparison under the default settings of the
+// service. For each algorithm "match" and "mismatch" must stay within noise
+// of each other, as a login for an unknown email is a mismatch against a
+// dummy hash; both should take about half of "hash then verify". The two
+// algorithms differ, which is what still tells a user whose hash predates
+// the current algorithm apart from an unknown email until they log in.
+func BenchmarkVerify(b *testing.B) {
+	hashers := []struct {
+		name   string
+		hasher Hasher
+	}{
+		{"bcrypt", Bcrypt{Cost: 12}},
+		{"argon2id", Argon2id{Memory: 64 * 1024, Iterations: 3, Parallelism: 4}},
+	}
+
+	for _, h := range hashers {
+		encoded, err := h.hasher.Hash("correct horse")
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		b.Run(h.name+"/match", func(b *testing.B) {
+			for b.Loop() {
+				_, _ = Verify("correct horse", encoded)
+			}
+		})
+
+		b.Run(h.name+"/mismatch", func(b *testing.B) {
+			for b.Loop() {
+				_, _ = Verify("battery staple", encoded)
+			}
+		})
+
+		b.Run(h.name+"/hash then verify", func(b *testing.B) {
+			for b.Loop() {
+				_, _ = h.hasher.Hash("correct horse")
+				_, _ = Verify("correct horse", encoded)
+			}
+		})
+	}
+}
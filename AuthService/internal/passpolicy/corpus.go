@@ -0,0 +1,52 @@
+package passpolicy
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Corpus is a directory of breached password hashes in the format of the
+// Have I Been Pwned range API: one file per 5 hex digit SHA-1 prefix, named
+// <PREFIX>.txt, holding a "<35 hex digit suffix>:<count>" line per hash.
+// The HIBP downloader writes this layout. Only the file for the password's
+// prefix is read, so the corpus may be far larger than memory.
+type Corpus string
+
+// Count returns how many times password appears in the corpus. A missing
+// prefix file counts as no appearance, so partial corpora work.
+func (c Corpus) Count(password string) (int, error) {
+	sum := sha1.Sum([]byte(password))
+	digest := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := digest[:5], digest[5:]
+
+	f, err := os.Open(filepath.Join(string(c), prefix+".txt"))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		hash, count, ok := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if !ok || !strings.EqualFold(hash, suffix) {
+			continue
+		}
+		n, err := strconv.Atoi(count)
+		if err != nil {
+			return 0, fmt.Errorf("corpus file %s.txt: %w", prefix, err)
+		}
+		return n, nil
+	}
+	return 0, scanner.Err()
+}
@@ -0,0 +1,48 @@
+// Package passpolicy decides whether a new password is acceptable: it must
+// not appear in a corpus of breached passwords, must be hard enough to guess
+// and must not be derived from the user's own email or username. Everything
+// runs offline; no password or hash prefix ever leaves the process.
+package passpolicy
+
+import "auth/internal/validator"
+
+// Policy holds the thresholds new passwords are checked against.
+type Policy struct {
+	// Breached is consulted when not empty.
+	Breached Corpus
+	// MinBreachCount is how many times a password must appear in Breached
+	// to be rejected.
+	MinBreachCount int
+	// MinStrength is the lowest acceptable Strength score, 0 to 4.
+	MinStrength int
+	// MaxSimilarity is the highest acceptable Similarity to the user's
+	// inputs, 0 to 1; 1 turns the check off.
+	MaxSimilarity float64
+}
+
+// Validate checks password and records the first rule it breaks in v under
+// key: password_breached, password_similar or password_weak. userInputs are
+// the other values the user supplied, such as their email and username. An
+// error is only returned when the breached corpus cannot be read.
+func (p Policy) Validate(v *validator.Validator, key, password string, userInputs ...string) error {
+	if p.Breached != "" {
+		n, err := p.Breached.Count(password)
+		if err != nil {
+			return err
+		}
+		if n >= p.MinBreachCount {
+			v.AddError(key, "password_breached")
+			return nil
+		}
+	}
+
+	if Similarity(password, userInputs...) > p.MaxSimilarity {
+		v.AddError(key, "password_similar")
+		return nil
+	}
+
+	if Strength(password, userInputs...) < p.MinStrength {
+		v.AddError(key, "password_weak")
+	}
+	return nil
+}
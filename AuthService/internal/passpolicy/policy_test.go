@@ -0,0 +1,136 @@
+package passpolicy
+
+import (
+	"auth/internal/validator"
+	"strings"
+	"testing"
+)
+
+func TestCorpusCount(t *testing.T) {
+	corpus := Corpus("testdata/breached")
+
+	tests := []struct {
+		password string
+		want     int
+	}{
+		{"hunter2hunter2", 42},
+		{"P@ssw0rd!2024", 3},
+		{"p@ssw0rd!2024", 0},
+		{"not in the corpus at all", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.password, func(t *testing.T) {
+			got, err := corpus.Count(tt.password)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %d want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStrength(t *testing.T) {
+	tests := []struct {
+		password   string
+		userInputs []string
+		atMost     int
+		atLeast    int
+	}{
+		{"12345678", nil, 0, 0},
+		{"password", nil, 0, 0},
+		{"password123", nil, 0, 0},
+		{"P4ssw0rd", nil, 0, 0},
+		{"qwertyuiop", nil, 1, 0},
+		{"aaaaaaaaaaaa", nil, 1, 0},
+		{"abcabcabcabc", nil, 1, 0},
+		{"tester2024", []string{"tester"}, 1, 0},
+		{"Dragon1987", nil, 2, 0},
+		{"Xq7#vLm2!rTz", nil, 4, 4},
+		{"river-lantern-quietly-93", nil, 4, 4},
+		{"Xq7#vLm2!rTz9@" + strings.Repeat("p", 86), nil, 4, 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.password, func(t *testing.T) {
+			got := Strength(tt.password, tt.userInputs...)
+			if got > tt.atMost || got < tt.atLeast {
+				t.Errorf("got score %d (%.3g guesses) want %d-%d", got, Guesses(tt.password, tt.userInputs...), tt.atLeast, tt.atMost)
+			}
+		})
+	}
+}
+
+func TestSimilarity(t *testing.T) {
+	inputs := []string{"john.smith@example.com", "jsmith"}
+
+	tests := []struct {
+		password string
+		similar  bool
+	}{
+		{"john.smith@example.com", true},
+		{"J0hn.Sm1th", true},
+		{"jsmith2024", true},
+		{"smith-river-lantern-quietly", false},
+		{"Xq7#vLm2!rTz", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.password, func(t *testing.T) {
+			got := Similarity(tt.password, inputs...)
+			if (got > 0.5) != tt.similar {
+				t.Errorf("got similarity %.2f want similar %v", got, tt.similar)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	policy := Policy{
+		Breached:       Corpus("testdata/breached"),
+		MinBreachCount: 1,
+		MinStrength:    3,
+		MaxSimilarity:  0.5,
+	}
+
+	tests := []struct {
+		name     string
+		password string
+		want     string
+	}{
+		{"accepted", "river-lantern-quietly-93", ""},
+		{"breached", "Xq7#vLm2!rTz", "password_breached"},
+		{"similar", "Tester-123", "password_similar"},
+		{"weak", "password123", "password_weak"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := validator.New()
+			if err := policy.Validate(v, "/password", tt.password, "tester@mail.com", "tester"); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := v.Errors["/password"].Rule; got != tt.want {
+				t.Errorf("got rule %q want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateThresholds(t *testing.T) {
+	lenient := Policy{
+		Breached:       Corpus("testdata/breached"),
+		MinBreachCount: 100,
+		MaxSimilarity:  1,
+	}
+
+	v := validator.New()
+	if err := lenient.Validate(v, "/password", "hunter2hunter2", "hunter@mail.com"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !v.Valid() {
+		t.Errorf("expected every check to pass with lenient thresholds, got %v", v.Errors)
+	}
+}
@@ -0,0 +1,78 @@
+package passpolicy
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Similarity returns how close password is to any of userInputs, from 0
+// (unrelated) to 1 (the same). Comparisons ignore case and common
+// substitutions such as 0 for o, and also look at the parts of each input
+// (an email's local part, its dot separated names), so "J0hn.Smith1" is
+// close to john.smith@example.com. The score is the larger of the edit
+// distance ratio and the share of the password an input makes up.
+func Similarity(password string, userInputs ...string) float64 {
+	pw := []rune(unleet(strings.ToLower(password)))
+	if len(pw) == 0 {
+		return 0
+	}
+
+	var best float64
+	for _, part := range inputParts(userInputs) {
+		p := []rune(part)
+
+		ratio := 1 - float64(levenshtein(pw, p))/float64(max(len(pw), len(p)))
+		if strings.Contains(string(pw), part) {
+			ratio = max(ratio, float64(len(p))/float64(len(pw)))
+		}
+		best = max(best, ratio)
+	}
+	return best
+}
+
+// inputParts splits userInputs into the strings worth comparing a password
+// with: each input, an email's local part and every alphanumeric run of at
+// least 3 characters, lower cased and with substitutions undone.
+func inputParts(userInputs []string) []string {
+	var parts []string
+	add := func(s string) {
+		if len([]rune(s)) >= 3 {
+			parts = append(parts, unleet(s))
+		}
+	}
+
+	for _, input := range userInputs {
+		input = strings.ToLower(input)
+		add(input)
+		if local, _, ok := strings.Cut(input, "@"); ok {
+			add(local)
+		}
+		for _, field := range strings.FieldsFunc(input, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		}) {
+			add(field)
+		}
+	}
+	return parts
+}
+
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,317 @@
+package passpolicy
+
+import (
+	_ "embed"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Strength scores how hard password is to guess, in the manner of zxcvbn:
+// it finds the cheapest way to build the password out of common passwords,
+// the user's own inputs, sequences (abcd, 9876), repeats, keyboard runs
+// (qwerty) and years, guessing the rest character by character, and maps
+// the resulting number of guesses to a score:
+//
+//	0  fewer than 10^3 guesses, among the first tried by any attacker
+//	1  fewer than 10^6, falls to a throttled online attack
+//	2  fewer than 10^8, falls to an unthrottled online attack
+//	3  fewer than 10^10, withstands online attacks
+//	4  10^10 or more
+func Strength(password string, userInputs ...string) int {
+	guesses := Guesses(password, userInputs...)
+	for score, limit := range []float64{1e3, 1e6, 1e8, 1e10} {
+		if guesses < limit {
+			return score
+		}
+	}
+	return 4
+}
+
+// Guesses estimates how many guesses an attacker who knows userInputs needs
+// to find password. Only the first maxEstimateLength runes are looked at,
+// which errs on the side of a lower estimate.
+func Guesses(password string, userInputs ...string) float64 {
+	pw := []rune(password)
+	if len(pw) > maxEstimateLength {
+		pw = pw[:maxEstimateLength]
+	}
+	return minGuesses(pw, findMatches(pw, inputParts(userInputs)))
+}
+
+// maxEstimateLength bounds the work of estimating a password, which grows
+// with the square of its length.
+const maxEstimateLength = 100
+
+// bruteforceCardinality is the cost of guessing one character that is not
+// part of any pattern.
+const bruteforceCardinality = 10
+
+// minMatchGuesses keeps a pattern from being cheaper than guessing a single
+// character, so that splitting a password into many tiny matches never
+// beats one longer match.
+const minMatchGuesses = bruteforceCardinality
+
+// match is a pattern spanning the runes i to j of a password, inclusive.
+type match struct {
+	i, j    int
+	guesses float64
+}
+
+// minGuesses returns the fewest guesses needed to cover pw with matches,
+// guessing the runes no match covers by brute force.
+func minGuesses(pw []rune, matches []match) float64 {
+	if len(pw) == 0 {
+		return 1
+	}
+
+	// best[k] is the fewest guesses for the first k runes.
+	best := make([]float64, len(pw)+1)
+	best[0] = 1
+	for k := 1; k <= len(pw); k++ {
+		best[k] = best[k-1] * bruteforceCardinality
+		for _, m := range matches {
+			if m.j == k-1 {
+				best[k] = min(best[k], best[m.i]*max(m.guesses, minMatchGuesses))
+			}
+		}
+	}
+	return best[len(pw)]
+}
+
+func findMatches(pw []rune, userInputs []string) []match {
+	return append(patternMatches(pw, userInputs), repeatMatches(pw, userInputs)...)
+}
+
+// patternMatches finds every match but repeats, whose blocks are estimated
+// with it.
+func patternMatches(pw []rune, userInputs []string) []match {
+	var matches []match
+	matches = append(matches, dictionaryMatches(pw, userInputs)...)
+	matches = append(matches, sequenceMatches(pw)...)
+	matches = append(matches, keyboardMatches(pw)...)
+	matches = append(matches, yearMatches(pw)...)
+	return matches
+}
+
+//go:embed common.txt
+var commonList string
+
+// common ranks frequently used passwords and words, most common first.
+var common = func() map[string]int {
+	ranks := map[string]int{}
+	for i, word := range strings.Fields(commonList) {
+		if _, ok := ranks[word]; !ok {
+			ranks[word] = i + 1
+		}
+	}
+	return ranks
+}()
+
+// dictionaryMatches finds the substrings of pw that are common passwords
+// or user inputs, in any case, reversed or with common substitutions. The
+// guesses are the word's rank, times the variations an attacker has to try.
+func dictionaryMatches(pw []rune, userInputs []string) []match {
+	inputs := map[string]int{}
+	for i, input := range userInputs {
+		if _, ok := inputs[input]; !ok {
+			inputs[input] = i + 1
+		}
+	}
+
+	var matches []match
+	for i := range pw {
+		for j := i + 2; j < len(pw); j++ {
+			token := string(pw[i : j+1])
+			lower := strings.ToLower(token)
+
+			variations := uppercaseVariations(token)
+			candidates := []struct {
+				word   string
+				factor float64
+			}{
+				{lower, 1},
+				{unleet(lower), 2},
+				{reverse(lower), 2},
+			}
+			for _, c := range candidates {
+				if c.factor > 1 && c.word == lower {
+					continue
+				}
+				rank, ok := inputs[c.word]
+				if !ok {
+					rank, ok = common[c.word]
+				}
+				if ok {
+					matches = append(matches, match{i, j, float64(rank) * variations * c.factor})
+				}
+			}
+		}
+	}
+	return matches
+}
+
+// uppercaseVariations is how many capitalisations of a word an attacker
+// tries before token's: none for lower case, 2 for a capital first or last
+// letter or all capitals, and the combinations of its capitals otherwise.
+func uppercaseVariations(token string) float64 {
+	var upper, lower int
+	for _, r := range token {
+		switch {
+		case unicode.IsUpper(r):
+			upper++
+		case unicode.IsLower(r):
+			lower++
+		}
+	}
+
+	runes := []rune(token)
+	switch {
+	case upper == 0:
+		return 1
+	case lower == 0,
+		upper == 1 && unicode.IsUpper(runes[0]),
+		upper == 1 && unicode.IsUpper(runes[len(runes)-1]):
+		return 2
+	}
+
+	var variations float64
+	for k := 1; k <= min(upper, lower); k++ {
+		variations += binomial(upper+lower, k)
+	}
+	return variations
+}
+
+// sequenceMatches finds runs of at least 3 runes that step by one, such as
+// abcd, 6543 or XYZ. Runs starting at an obvious place (a, z, 0, 1, 9)
+// are tried first.
+func sequenceMatches(pw []rune) []match {
+	var matches []match
+	for i := 0; i+2 < len(pw); {
+		delta := pw[i+1] - pw[i]
+		j := i + 1
+		for j+1 < len(pw) && pw[j+1]-pw[j] == delta {
+			j++
+		}
+
+		if (delta == 1 || delta == -1) && j-i >= 2 {
+			base := 26.0
+			switch {
+			case strings.ContainsRune("aAzZ019", pw[i]):
+				base = 4
+			case unicode.IsDigit(pw[i]):
+				base = 10
+			}
+			if delta < 0 {
+				base *= 2
+			}
+			matches = append(matches, match{i, j, base * float64(j-i+1)})
+		}
+
+		if j == i+1 {
+			i++
+		} else {
+			i = j
+		}
+	}
+	return matches
+}
+
+// repeatMatches finds, at each position, the shortest block repeated back
+// to back, such as aaaa or abcabc. Guessing it costs guessing the block
+// once, times the number of repetitions.
+func repeatMatches(pw []rune, userInputs []string) []match {
+	var matches []match
+	for i := range pw {
+		for size := 1; i+2*size <= len(pw); size++ {
+			block := pw[i : i+size]
+			count := 1
+			for i+(count+1)*size <= len(pw) && slices.Equal(pw[i+count*size:i+(count+1)*size], block) {
+				count++
+			}
+			if count < 2 || size*count < 3 {
+				continue
+			}
+
+			guesses := minGuesses(block, patternMatches(block, userInputs))
+			matches = append(matches, match{i, i + size*count - 1, guesses * float64(count)})
+			break
+		}
+	}
+	return matches
+}
+
+// keyboardRows are the rows of a US keyboard, unshifted and shifted.
+var keyboardRows = []string{
+	"`1234567890-=", "qwertyuiop[]\\", "asdfghjkl;'", "zxcvbnm,./",
+	"~!@#$%^&*()_+", "QWERTYUIOP{}|", "ASDFGHJKL:\"", "ZXCVBNM<>?",
+}
+
+// keyboardStartingPositions and keyboardDegree approximate the number of
+// keys a run may start on and the average number of neighbours of a key.
+const (
+	keyboardStartingPositions = 94
+	keyboardDegree            = 4.6
+)
+
+// keyboardMatches finds runs of at least 4 adjacent keys along a keyboard
+// row, in either direction, such as qwerty or 0987.
+func keyboardMatches(pw []rune) []match {
+	var matches []match
+	for i := range pw {
+		for j := i + 3; j < len(pw); j++ {
+			token := string(pw[i : j+1])
+			for _, row := range keyboardRows {
+				if strings.Contains(row, token) || strings.Contains(row, reverse(token)) {
+					guesses := keyboardStartingPositions * keyboardDegree * float64(j-i)
+					matches = append(matches, match{i, j, guesses})
+					break
+				}
+			}
+		}
+	}
+	return matches
+}
+
+// yearMatches finds four digit years from 1900 to 2099. Years close to the
+// present are guessed first.
+func yearMatches(pw []rune) []match {
+	var matches []match
+	for i := 0; i+4 <= len(pw); i++ {
+		year, err := strconv.Atoi(string(pw[i : i+4]))
+		if err != nil || year < 1900 || year > 2099 {
+			continue
+		}
+		distance := math.Abs(float64(year - time.Now().Year()))
+		matches = append(matches, match{i, i + 3, max(distance, 20)})
+	}
+	return matches
+}
+
+var leet = strings.NewReplacer(
+	"4", "a", "@", "a", "8", "b", "(", "c", "3", "e", "6", "g", "1", "i",
+	"!", "i", "|", "l", "0", "o", "$", "s", "5", "s", "7", "t", "+", "t",
+	"2", "z",
+)
+
+// unleet undoes the character substitutions people use to dress up words.
+func unleet(s string) string {
+	return leet.Replace(s)
+}
+
+func reverse(s string) string {
+	r := []rune(s)
+	slices.Reverse(r)
+	return string(r)
+}
+
+func binomial(n, k int) float64 {
+	result := 1.0
+	for i := 1; i <= k; i++ {
+		result *= float64(n-k+i) / float64(i)
+	}
+	return result
+}
@@ -0,0 +1,169 @@
+// Package useragent turns a User-Agent header into the browser, operating
+// system and device a person would recognize in a list of their sessions.
+// It only knows the common browsers and platforms; anything else is left
+// empty rather than guessed.
+package useragent
+
+import "strings"
+
+// UserAgent is what Parse could tell from a User-Agent header.
+type UserAgent struct {
+	// Browser is the browser name and major version, such as "Chrome 120".
+	Browser string
+	// OS is the operating system and, where the header still reveals it,
+	// its version, such as "Android 14" or "macOS".
+	OS string
+	// Device is the device model or kind, such as "iPhone" or "Pixel 8",
+	// when the header names one.
+	Device string
+	// DeviceType is desktop, mobile or tablet, or empty for bots and
+	// clients that could not be told apart.
+	DeviceType string
+}
+
+// browsers are matched in order; the first token found wins. Browsers built
+// on Chromium also send "Chrome/" and "Safari/", and Chrome sends "Safari/",
+// so the more specific tokens come first.
+var browsers = []struct {
+	token, name string
+}{
+	{"EdgA/", "Edge"},
+	{"EdgiOS/", "Edge"},
+	{"Edg/", "Edge"},
+	{"OPR/", "Opera"},
+	{"OPiOS/", "Opera"},
+	{"SamsungBrowser/", "Samsung Internet"},
+	{"FxiOS/", "Firefox"},
+	{"Firefox/", "Firefox"},
+	{"CriOS/", "Chrome"},
+	{"Chrome/", "Chrome"},
+	{"Version/", "Safari"},
+}
+
+// windowsVersions maps the NT version in the header to the marketing name.
+// Windows 11 still reports NT 10.0.
+var windowsVersions = map[string]string{
+	"10.0": "Windows",
+	"6.3":  "Windows 8.1",
+	"6.2":  "Windows 8",
+	"6.1":  "Windows 7",
+}
+
+// Parse reads ua. It never fails; the fields it cannot fill are empty.
+func Parse(ua string) UserAgent {
+	var u UserAgent
+	if ua == "" || isBot(ua) {
+		return u
+	}
+
+	for _, b := range browsers {
+		if i := strings.Index(ua, b.token); i >= 0 {
+			if b.name == "Safari" && !strings.Contains(ua, "Safari/") {
+				continue
+			}
+			u.Browser = withVersion(b.name, majorVersion(ua[i+len(b.token):]))
+			break
+		}
+	}
+
+	switch {
+	case strings.Contains(ua, "iPhone"):
+		u.OS = withVersion("iOS", appleVersion(ua, "iPhone OS "))
+		u.Device, u.DeviceType = "iPhone", "mobile"
+	case strings.Contains(ua, "iPad"):
+		u.OS = withVersion("iPadOS", appleVersion(ua, "CPU OS "))
+		u.Device, u.DeviceType = "iPad", "tablet"
+	case strings.Contains(ua, "Android"):
+		version, model := android(ua)
+		u.OS = withVersion("Android", version)
+		u.Device, u.DeviceType = model, "tablet"
+		if strings.Contains(ua, "Mobile") {
+			u.DeviceType = "mobile"
+		}
+	case strings.Contains(ua, "Windows NT "):
+		nt := ua[strings.Index(ua, "Windows NT ")+len("Windows NT "):]
+		nt = nt[:strings.IndexFunc(nt+";", func(r rune) bool { return r == ';' || r == ')' })]
+		u.OS = windowsVersions[nt]
+		if u.OS == "" {
+			u.OS = "Windows"
+		}
+		u.DeviceType = "desktop"
+	case strings.Contains(ua, "CrOS"):
+		u.OS, u.Device, u.DeviceType = "ChromeOS", "Chromebook", "desktop"
+	case strings.Contains(ua, "Macintosh"):
+		// Browsers froze the reported macOS version at 10.15.7, so it says
+		// nothing about the real one.
+		u.OS, u.Device, u.DeviceType = "macOS", "Mac", "desktop"
+	case strings.Contains(ua, "Linux"):
+		u.OS, u.DeviceType = "Linux", "desktop"
+	}
+
+	return u
+}
+
+func isBot(ua string) bool {
+	lower := strings.ToLower(ua)
+	for _, s := range []string{"bot", "crawler", "spider", "curl/", "wget/"} {
+		if strings.Contains(lower, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func withVersion(name, version string) string {
+	if version == "" {
+		return name
+	}
+	return name + " " + version
+}
+
+// majorVersion returns the leading digits of s.
+func majorVersion(s string) string {
+	end := strings.IndexFunc(s, func(r rune) bool { return r < '0' || r > '9' })
+	if end < 0 {
+		return s
+	}
+	return s[:end]
+}
+
+// appleVersion returns the version after prefix, "17_1_2" read as "17.1.2".
+func appleVersion(ua, prefix string) string {
+	i := strings.Index(ua, prefix)
+	if i < 0 {
+		return ""
+	}
+	v := ua[i+len(prefix):]
+	if end := strings.IndexByte(v, ' '); end >= 0 {
+		v = v[:end]
+	}
+	return strings.ReplaceAll(v, "_", ".")
+}
+
+// android returns the Android version and the device model from the
+// platform part of ua, "(Linux; Android 14; Pixel 8 Build/UQ1A)". Chrome's
+// reduced user agent replaces the model with "K", which is dropped.
+func android(ua string) (version, model string) {
+	start := strings.IndexByte(ua, '(')
+	end := strings.IndexByte(ua, ')')
+	if start < 0 || end < start {
+		return "", ""
+	}
+
+	parts := strings.Split(ua[start+1:end], ";")
+	for i, p := range parts {
+		p = strings.TrimSpace(p)
+		if !strings.HasPrefix(p, "Android") {
+			continue
+		}
+		version = strings.TrimSpace(strings.TrimPrefix(p, "Android"))
+		if i+1 < len(parts) {
+			model, _, _ = strings.Cut(strings.TrimSpace(parts[i+1]), " Build/")
+		}
+		break
+	}
+	if model == "K" {
+		model = ""
+	}
+	return version, model
+}
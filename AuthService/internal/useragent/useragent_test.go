@@ -0,0 +1,72 @@
+package useragent
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		ua   string
+		want UserAgent
+	}{
+		{
+			"chrome on windows",
+			"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+			UserAgent{Browser: "Chrome 120", OS: "Windows", DeviceType: "desktop"},
+		},
+		{
+			"edge on windows 7",
+			"Mozilla/5.0 (Windows NT 6.1; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/109.0.0.0 Safari/537.36 Edg/109.0.1518.78",
+			UserAgent{Browser: "Edge 109", OS: "Windows 7", DeviceType: "desktop"},
+		},
+		{
+			"safari on mac",
+			"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Safari/605.1.15",
+			UserAgent{Browser: "Safari 17", OS: "macOS", Device: "Mac", DeviceType: "desktop"},
+		},
+		{
+			"firefox on linux",
+			"Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0",
+			UserAgent{Browser: "Firefox 121", OS: "Linux", DeviceType: "desktop"},
+		},
+		{
+			"safari on iphone",
+			"Mozilla/5.0 (iPhone; CPU iPhone OS 17_1_2 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1.2 Mobile/15E148 Safari/604.1",
+			UserAgent{Browser: "Safari 17", OS: "iOS 17.1.2", Device: "iPhone", DeviceType: "mobile"},
+		},
+		{
+			"chrome on ipad",
+			"Mozilla/5.0 (iPad; CPU OS 16_6 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) CriOS/119.0.6045.169 Mobile/15E148 Safari/604.1",
+			UserAgent{Browser: "Chrome 119", OS: "iPadOS 16.6", Device: "iPad", DeviceType: "tablet"},
+		},
+		{
+			"samsung internet on android phone",
+			"Mozilla/5.0 (Linux; Android 13; SM-S911B Build/TP1A.220624.014) AppleWebKit/537.36 (KHTML, like Gecko) SamsungBrowser/23.0 Chrome/115.0.0.0 Mobile Safari/537.36",
+			UserAgent{Browser: "Samsung Internet 23", OS: "Android 13", Device: "SM-S911B", DeviceType: "mobile"},
+		},
+		{
+			"chrome reduced user agent on android tablet",
+			"Mozilla/5.0 (Linux; Android 10; K) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+			UserAgent{Browser: "Chrome 120", OS: "Android 10", DeviceType: "tablet"},
+		},
+		{
+			"chromebook",
+			"Mozilla/5.0 (X11; CrOS x86_64 14541.0.0) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+			UserAgent{Browser: "Chrome 120", OS: "ChromeOS", Device: "Chromebook", DeviceType: "desktop"},
+		},
+		{
+			"bot",
+			"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+			UserAgent{},
+		},
+		{"unknown", "my-client/1.0", UserAgent{}},
+		{"empty", "", UserAgent{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Parse(tt.ua); got != tt.want {
+				t.Errorf("got %+v want %+v", got, tt.want)
+			}
+		})
+	}
+}
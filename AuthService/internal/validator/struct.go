@@ -0,0 +1,275 @@
+package validator
+
+import (
+	"fmt"
+	"net/netip"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Struct checks s, a struct or a pointer to one, against the rules declared
+// in the validate tags of its fields, and records every failure under the
+// JSON pointer (RFC 6901) of the offending value, such as /email or
+// /devices/0/ip_address. Nested structs, and structs held in slices, are
+// checked too.
+//
+// A tag is a comma-separated list of rules:
+//
+//	required       the value is not empty (zero, "", nil or no elements)
+//	min_bytes=N    a string is at least N bytes long
+//	max_bytes=N    a string is at most N bytes long
+//	min_chars=N    a string is at least N characters (runes) long
+//	max_chars=N    a string is at most N characters (runes) long
+//	min=N, max=N   a number is within the bound
+//	min_items=N    a slice has at least N elements
+//	max_items=N    a slice has at most N elements
+//	oneof=a|b|c    the value is one of the listed ones
+//	email          a string is an email address
+//	ip             a string is an IPv4 or IPv6 address
+//	uuid           a string is a UUID
+//	url            a string is an absolute URL
+//	dive           the rules after it apply to each element of a slice
+//
+// Every rule but required accepts an empty value, so optional fields only
+// declare the constraints they must meet when present. Struct panics on a
+// malformed tag, which is a programming error.
+func (v *Validator) Struct(s any) {
+	rv := reflect.ValueOf(s)
+	for rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("validator: Struct called with %s", rv.Type()))
+	}
+	v.checkStruct("", rv)
+}
+
+type rule struct {
+	name  string
+	param string
+}
+
+type field struct {
+	index int
+	name  string
+	rules []rule
+	dive  []rule
+}
+
+var fieldCache sync.Map // reflect.Type -> []field
+
+func fieldsOf(t reflect.Type) []field {
+	if cached, ok := fieldCache.Load(t); ok {
+		return cached.([]field)
+	}
+
+	var fields []field
+	for i := range t.NumField() {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+
+		name, _, _ := strings.Cut(sf.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+
+		f := field{index: i, name: name}
+		if sf.Anonymous && sf.Tag.Get("json") == "" {
+			f.name = ""
+		}
+
+		if tag := sf.Tag.Get("validate"); tag != "" {
+			dive := false
+			for _, part := range strings.Split(tag, ",") {
+				r := parseRule(t, sf, part)
+				switch {
+				case r.name == "dive":
+					dive = true
+				case dive:
+					f.dive = append(f.dive, r)
+				default:
+					f.rules = append(f.rules, r)
+				}
+			}
+		}
+
+		fields = append(fields, f)
+	}
+
+	fieldCache.Store(t, fields)
+	return fields
+}
+
+func parseRule(t reflect.Type, sf reflect.StructField, s string) rule {
+	name, param, _ := strings.Cut(strings.TrimSpace(s), "=")
+	r := rule{name: name, param: param}
+
+	switch name {
+	case "required", "email", "ip", "uuid", "url", "dive":
+		if param != "" {
+			break
+		}
+		return r
+	case "min_bytes", "max_bytes", "min_chars", "max_chars", "min_items", "max_items":
+		if _, err := strconv.Atoi(param); err == nil {
+			return r
+		}
+	case "min", "max":
+		if _, err := strconv.ParseFloat(param, 64); err == nil {
+			return r
+		}
+	case "oneof":
+		if param != "" {
+			return r
+		}
+	}
+	panic(fmt.Sprintf("validator: invalid rule %q on %s.%s", s, t, sf.Name))
+}
+
+func (v *Validator) checkStruct(path string, rv reflect.Value) {
+	for _, f := range fieldsOf(rv.Type()) {
+		fieldPath := path
+		if f.name != "" {
+			fieldPath = path + "/" + escapePointer(f.name)
+		}
+		v.checkValue(fieldPath, rv.Field(f.index), f.rules, f.dive)
+	}
+}
+
+func (v *Validator) checkValue(path string, rv reflect.Value, rules, dive []rule) {
+	for _, r := range rules {
+		if !v.checkRule(path, rv, r) {
+			// Report only the first broken rule of a value.
+			return
+		}
+	}
+
+	for rv.Kind() == reflect.Pointer || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return
+		}
+		rv = rv.Elem()
+	}
+
+	switch rv.Kind() {
+	case reflect.Struct:
+		v.checkStruct(path, rv)
+	case reflect.Slice, reflect.Array:
+		for i := range rv.Len() {
+			v.checkValue(path+"/"+strconv.Itoa(i), rv.Index(i), dive, nil)
+		}
+	}
+}
+
+// checkRule records an error at path and reports false when rv breaks r.
+func (v *Validator) checkRule(path string, rv reflect.Value, r rule) bool {
+	if r.name == "required" {
+		ok := !isEmpty(rv)
+		v.Check(ok, path, "required")
+		return ok
+	}
+	if isEmpty(rv) {
+		return true
+	}
+	for rv.Kind() == reflect.Pointer || rv.Kind() == reflect.Interface {
+		rv = rv.Elem()
+	}
+
+	var (
+		ok   = true
+		args []any
+	)
+	switch r.name {
+	case "min_bytes", "max_bytes", "min_chars", "max_chars", "min_items", "max_items":
+		n, _ := strconv.Atoi(r.param)
+		args = []any{n}
+
+		var length int
+		switch {
+		case strings.HasSuffix(r.name, "_chars") && rv.Kind() == reflect.String:
+			length = utf8.RuneCountInString(rv.String())
+		case strings.HasSuffix(r.name, "_bytes") && rv.Kind() == reflect.String,
+			strings.HasSuffix(r.name, "_items"):
+			length = rv.Len()
+		default:
+			panic(fmt.Sprintf("validator: rule %s does not apply to %s", r.name, rv.Type()))
+		}
+		if strings.HasPrefix(r.name, "min") {
+			ok = length >= n
+		} else {
+			ok = length <= n
+		}
+	case "min", "max":
+		bound, _ := strconv.ParseFloat(r.param, 64)
+		args = []any{r.param}
+
+		var n float64
+		switch {
+		case rv.CanInt():
+			n = float64(rv.Int())
+		case rv.CanUint():
+			n = float64(rv.Uint())
+		case rv.CanFloat():
+			n = rv.Float()
+		default:
+			panic(fmt.Sprintf("validator: rule %s does not apply to %s", r.name, rv.Type()))
+		}
+		if r.name == "min" {
+			ok = n >= bound
+		} else {
+			ok = n <= bound
+		}
+	case "oneof":
+		allowed := strings.Split(r.param, "|")
+		args = []any{strings.Join(allowed, ", ")}
+		ok = PermittedValue(fmt.Sprint(rv.Interface()), allowed...)
+	case "email":
+		ok = Matches(stringOf(rv, r), EmailRX)
+	case "ip":
+		// Postgres INET has no room for the zone of an IPv6 address.
+		addr, err := netip.ParseAddr(stringOf(rv, r))
+		ok = err == nil && addr.Zone() == ""
+	case "uuid":
+		ok = Matches(stringOf(rv, r), UUIDRX)
+	case "url":
+		u, err := url.Parse(stringOf(rv, r))
+		ok = err == nil && u.Scheme != "" && u.Host != ""
+	}
+
+	v.Check(ok, path, r.name, args...)
+	return ok
+}
+
+func stringOf(rv reflect.Value, r rule) string {
+	if rv.Kind() != reflect.String {
+		panic(fmt.Sprintf("validator: rule %s does not apply to %s", r.name, rv.Type()))
+	}
+	return rv.String()
+}
+
+func isEmpty(rv reflect.Value) bool {
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Map:
+		return rv.Len() == 0
+	case reflect.Invalid:
+		return true
+	}
+	return rv.IsZero()
+}
+
+// escapePointer escapes a JSON pointer reference token.
+func escapePointer(s string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(s)
+}
@@ -0,0 +1,130 @@
+package validator
+
+import (
+	"maps"
+	"slices"
+	"testing"
+)
+
+type device struct {
+	Name string `json:"name" validate:"required,max_chars=5"`
+	IP   string `json:"ip_address" validate:"ip"`
+}
+
+type Audit struct {
+	Source string `json:"source" validate:"url"`
+}
+
+type profile struct {
+	Audit
+	Username string   `json:"username" validate:"required,min_chars=3,max_chars=5"`
+	Password string   `json:"password" validate:"min_bytes=4,max_bytes=6"`
+	Kind     string   `json:"kind" validate:"oneof=desktop|mobile|tablet"`
+	ID       string   `json:"id" validate:"uuid"`
+	Email    string   `json:"email" validate:"email"`
+	Age      int      `json:"age" validate:"min=18,max=130"`
+	Primary  device   `json:"primary"`
+	Backup   *device  `json:"backup"`
+	Devices  []device `json:"devices" validate:"max_items=2"`
+	Tags     []string `json:"a/b~c" validate:"dive,required,max_bytes=3"`
+	Ignored  string   `json:"-" validate:"required"`
+}
+
+func valid() profile {
+	return profile{
+		Audit:    Audit{Source: "https://example.com/a"},
+		Username: "tomás",
+		Password: "secret",
+		Kind:     "mobile",
+		ID:       "0b6e3f5c-7a8f-4c7e-9b1d-2f3a4b5c6d7e",
+		Email:    "a@b.co",
+		Age:      30,
+		Primary:  device{Name: "phone", IP: "::1"},
+		Devices:  []device{{Name: "pc", IP: "10.0.0.1"}},
+		Tags:     []string{"a", "bcd"},
+	}
+}
+
+func TestStruct(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(p *profile)
+		want   map[string]string
+	}{
+		{"valid", func(p *profile) {}, map[string]string{}},
+		{"optional fields may be empty", func(p *profile) {
+			*p = profile{Username: "abc", Primary: device{Name: "x"}}
+		}, map[string]string{}},
+		{"required", func(p *profile) { p.Username = "" }, map[string]string{"/username": "required"}},
+		{"length counts runes", func(p *profile) { p.Username = "ñññññ" }, map[string]string{}},
+		{"max chars", func(p *profile) { p.Username = "ñññññn" }, map[string]string{"/username": "max_chars"}},
+		{"min chars", func(p *profile) { p.Username = "ab" }, map[string]string{"/username": "min_chars"}},
+		{"length counts bytes", func(p *profile) { p.Password = "ññña" }, map[string]string{"/password": "max_bytes"}},
+		{"min bytes", func(p *profile) { p.Password = "abc" }, map[string]string{"/password": "min_bytes"}},
+		{"enum", func(p *profile) { p.Kind = "toaster" }, map[string]string{"/kind": "oneof"}},
+		{"uuid", func(p *profile) { p.ID = "1234" }, map[string]string{"/id": "uuid"}},
+		{"email", func(p *profile) { p.Email = "nope" }, map[string]string{"/email": "email"}},
+		{"url", func(p *profile) { p.Source = "/relative" }, map[string]string{"/source": "url"}},
+		{"number bounds", func(p *profile) { p.Age = 7 }, map[string]string{"/age": "min"}},
+		{"nested struct", func(p *profile) { p.Primary.IP = "300.1.1.1" }, map[string]string{"/primary/ip_address": "ip"}},
+		{"ipv6 zone", func(p *profile) { p.Primary.IP = "fe80::1%eth0" }, map[string]string{"/primary/ip_address": "ip"}},
+		{"ipv6", func(p *profile) { p.Primary.IP = "2001:db8::1" }, map[string]string{}},
+		{"nested pointer", func(p *profile) { p.Backup = &device{} }, map[string]string{"/backup/name": "required"}},
+		{"slice of structs", func(p *profile) {
+			p.Devices = append(p.Devices, device{Name: "laptop"})
+		}, map[string]string{"/devices/1/name": "max_chars"}},
+		{"slice length", func(p *profile) {
+			p.Devices = []device{{Name: "a"}, {Name: "b"}, {Name: "c"}}
+		}, map[string]string{"/devices": "max_items"}},
+		{"dive with escaped pointer", func(p *profile) {
+			p.Tags = []string{"ok", "", "long"}
+		}, map[string]string{"/a~1b~0c/1": "required", "/a~1b~0c/2": "max_bytes"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := valid()
+			tt.modify(&p)
+
+			v := New()
+			v.Struct(&p)
+
+			got := make(map[string]string, len(v.Errors))
+			for path, e := range v.Errors {
+				got[path] = e.Rule
+			}
+			if !maps.Equal(got, tt.want) {
+				t.Errorf("got %v want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStructRuleArgs(t *testing.T) {
+	p := valid()
+	p.Kind = "toaster"
+	p.Username = "ab"
+
+	v := New()
+	v.Struct(p)
+
+	if args := v.Errors["/username"].Args; !slices.Equal(args, []any{3}) {
+		t.Errorf("got min_chars args %v want [3]", args)
+	}
+	if args := v.Errors["/kind"].Args; !slices.Equal(args, []any{"desktop, mobile, tablet"}) {
+		t.Errorf("got oneof args %v", args)
+	}
+}
+
+func TestStructInvalidTag(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a malformed tag to panic")
+		}
+	}()
+
+	var s struct {
+		Name string `validate:"max_chars=many"`
+	}
+	New().Struct(s)
+}
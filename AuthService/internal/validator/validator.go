@@ -2,17 +2,28 @@ package validator
 
 import (
 	"regexp"
+	"slices"
 )
 
+var UUIDRX = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
 var EmailRX = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
 
+// Error describes why a field is invalid: the rule it broke, such as
+// "required" or "min_bytes", and the rule's parameters. It is rendered into
+// a message by the i18n catalogs under validation.<Rule>.
+type Error struct {
+	Rule string
+	Args []any
+}
+
 type Validator struct {
-	Errors map[string]string
+	Errors map[string]Error
 }
 
 func New() *Validator {
 	return &Validator{
-		Errors: make(map[string]string),
+		Errors: make(map[string]Error),
 	}
 }
 
@@ -20,9 +31,9 @@ func (v *Validator) Valid() bool {
 	return len(v.Errors) == 0
 }
 
-func (v *Validator) Check(ok bool, key, msg string) {
+func (v *Validator) Check(ok bool, key, rule string, args ...any) {
 	if !ok {
-		v.Errors[key] = msg
+		v.Errors[key] = Error{Rule: rule, Args: args}
 	}
 }
 
@@ -30,8 +41,12 @@ func Matches(value string, rx *regexp.Regexp) bool {
 	return rx.MatchString(value)
 }
 
-func (v *Validator) AddError(key, msg string) {
+func PermittedValue[T comparable](value T, permittedValues ...T) bool {
+	return slices.Contains(permittedValues, value)
+}
+
+func (v *Validator) AddError(key, rule string, args ...any) {
 	if _, ok := v.Errors[key]; !ok {
-		v.Errors[key] = msg
+		v.Errors[key] = Error{Rule: rule, Args: args}
 	}
 }
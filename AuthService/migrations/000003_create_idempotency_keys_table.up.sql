@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    subject           TEXT NOT NULL,
+    key               TEXT NOT NULL,
+    request_hash      BYTEA NOT NULL,
+    response          BYTEA,
+    error_code        TEXT,
+    error_description TEXT,
+    created_at        TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    -- When the request handling the key claimed it. A claim without a
+    -- response is taken over by a retry once its lease has run out.
+    claimed_at        TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at        TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (subject, key)
+);
+
+CREATE INDEX idx_idempotency_keys_expires ON idempotency_keys(expires_at);
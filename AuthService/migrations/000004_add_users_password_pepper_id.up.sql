@@ -0,0 +1,2 @@
+-- Key ID of the pepper mixed into password_hash; NULL when none was.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS password_pepper_id VARCHAR(16);
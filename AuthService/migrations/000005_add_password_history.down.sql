@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_password_history_user;
+DROP TABLE IF EXISTS password_history;
+ALTER TABLE users DROP COLUMN IF EXISTS password_changed_at;
@@ -0,0 +1,14 @@
+-- When the current password was set; existing users start counting now.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS password_changed_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+
+-- Hashes of the passwords users had before their current one, kept to stop
+-- them from being reused.
+CREATE TABLE IF NOT EXISTS password_history (
+    id                 BIGSERIAL PRIMARY KEY,
+    user_id            UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    password_hash      VARCHAR(255) NOT NULL,
+    password_pepper_id VARCHAR(16),
+    created_at         TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idx_password_history_user ON password_history(user_id, created_at DESC);
@@ -0,0 +1,4 @@
+-- Copied into every access token; bumping it makes the user's outstanding
+-- tokens stale. It starts at 0, which is what tokens issued before the
+-- claim existed decode to, so they stay valid until they expire.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS token_version INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,16 @@
+-- Every emergency revocation, kept as its audit trail. The latest row is in
+-- force: tokens issued before its not_before, or signed with one of its
+-- revoked_key_ids, are rejected, and the key derived from
+-- JWT_BREAK_GLASS_SECRET and signing_key_salt replaces a revoked
+-- JWT_ACCESS_SECRET until a new one is configured. The key itself is never
+-- stored.
+CREATE TABLE IF NOT EXISTS break_glass (
+    id               BIGSERIAL PRIMARY KEY,
+    not_before       TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    actor            TEXT NOT NULL,
+    reason           TEXT NOT NULL,
+    signing_key_salt TEXT NOT NULL,
+    revoked_key_ids  TEXT[] NOT NULL,
+    sessions_revoked BIGINT,
+    completed_at     TIMESTAMPTZ
+);
@@ -0,0 +1,5 @@
+-- expires_at is the idle expiry, pushed forward by every refresh;
+-- absolute_expires_at is fixed at login and caps it.
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS absolute_expires_at TIMESTAMPTZ;
+UPDATE sessions SET absolute_expires_at = expires_at WHERE absolute_expires_at IS NULL;
+ALTER TABLE sessions ALTER COLUMN absolute_expires_at SET NOT NULL;
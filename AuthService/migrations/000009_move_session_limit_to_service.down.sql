@@ -0,0 +1,24 @@
+ALTER TABLE users DROP COLUMN IF EXISTS role;
+
+CREATE OR REPLACE FUNCTION prune_user_sessions()
+RETURNS TRIGGER AS $$
+BEGIN
+UPDATE sessions
+SET revoked_at = NOW()
+WHERE session_id IN (
+    SELECT session_id
+    FROM sessions
+    WHERE user_id = NEW.user_id
+      AND revoked_at IS NULL
+      AND expires_at > NOW()
+    ORDER BY last_used_at DESC, created_at DESC, session_id DESC
+    OFFSET 5
+);
+RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER trigger_prune_sessions
+    AFTER INSERT ON sessions
+    FOR EACH ROW
+    EXECUTE FUNCTION prune_user_sessions();
@@ -0,0 +1,7 @@
+-- The session limit is enforced by SessionModel.Create from the config; the
+-- trigger's hard-coded limit would evict sessions the config allows.
+DROP TRIGGER IF EXISTS trigger_prune_sessions ON sessions;
+DROP FUNCTION IF EXISTS prune_user_sessions();
+
+-- Selects the role's entry in session.role_max_sessions.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT 'user';
@@ -0,0 +1,8 @@
+-- Parsed from the user agent and located from the IP address at login, so
+-- that users can recognize their sessions.
+ALTER TABLE sessions
+    ADD COLUMN IF NOT EXISTS browser TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS os      TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS device  TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS country TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS city    TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,19 @@
+-- Where each user logged in from, so that logins from an unseen device, a
+-- new country or too far away too soon can be flagged.
+CREATE TABLE IF NOT EXISTS login_history (
+    id              BIGSERIAL PRIMARY KEY,
+    user_id         UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    session_id      UUID NOT NULL,
+    device          TEXT NOT NULL,
+    ip_address      INET,
+    country         TEXT NOT NULL DEFAULT '',
+    city            TEXT NOT NULL DEFAULT '',
+    latitude        DOUBLE PRECISION NOT NULL DEFAULT 0,
+    longitude       DOUBLE PRECISION NOT NULL DEFAULT 0,
+    -- 0 when the login was not located.
+    accuracy_radius INTEGER NOT NULL DEFAULT 0,
+    flags           TEXT[] NOT NULL DEFAULT '{}',
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_login_history_user_id ON login_history(user_id, created_at DESC);
@@ -1,6 +1,8 @@
 package migrations
 
 import (
+	"context"
+	"database/sql"
 	"embed"
 	"errors"
 
@@ -27,3 +29,14 @@ func RunUpMigrations(dsn string) error {
 	}
 	return err
 }
+
+// Version reports the schema version recorded by golang-migrate and whether
+// the last migration failed halfway (dirty). It reads the bookkeeping table
+// directly so it can be polled cheaply on the service's own connection pool.
+func Version(ctx context.Context, db *sql.DB) (version int64, dirty bool, err error) {
+	err = db.QueryRowContext(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&version, &dirty)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+	return version, dirty, err
+}
@@ -1,4 +1,4 @@
-import { Controller, Get, Post, Body, Patch, Param, Delete, UseGuards, Inject } from '@nestjs/common';
+import { Controller, Get, Post, Body, Patch, Param, Delete, UseGuards, Inject, Ip, Headers } from '@nestjs/common';
 import { Registeruserdto } from './dto/register-user.dto';
 import { LoginUserDto } from './dto/login-user.dto';
 import { AuthGuard } from './auth.guard';
@@ -16,7 +16,7 @@ export class AuthController {
 
   @Post('register')
   async register (@Body() registerUserDto: Registeruserdto) {
-    return await this.client.send('auth.register', registerUserDto)
+    return await this.client.send('auth.v1.register', registerUserDto)
      .pipe(
       catchError((err) => {
         throw new RpcException(err.message);
@@ -25,9 +25,15 @@ export class AuthController {
   }
 
 
+  // The auth service records the client's address and user agent on the
+  // session; it cannot see them itself, so they are forwarded here.
   @Post('login')
-  login(@Body() loginUserDto: LoginUserDto){
-    return this.client.send('auth.login', loginUserDto).pipe(
+  login(@Body() loginUserDto: LoginUserDto, @Ip() ip: string, @Headers('user-agent') userAgent?: string){
+    return this.client.send('auth.v1.login', {
+      ...loginUserDto,
+      ip_address: ip,
+      user_agent: userAgent?.slice(0, 1024),
+    }).pipe(
       catchError((err) => {
         throw new RpcException(err.message);
       })
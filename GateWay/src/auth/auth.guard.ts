@@ -4,7 +4,7 @@ import {
   Inject,
   UnauthorizedException,
 } from '@nestjs/common';
-import { firstValueFrom, Observable } from 'rxjs';
+import { firstValueFrom } from 'rxjs';
 import { Request } from 'express';
 import { NATS_SERVICES } from 'src/config';
 import { ClientProxy } from '@nestjs/microservices';
@@ -21,30 +21,27 @@ export class AuthGuard implements CanActivate {
       throw new UnauthorizedException();
     }
 
+    // The auth service answers failures with an error envelope rather than
+    // an RPC error, so the status of the reply has to be checked.
+    let reply: { status: number; code?: string; message?: string; data?: User };
     try {
-      const user: User = await firstValueFrom(
-        this.client.send('auth.validate', token),
+      reply = await firstValueFrom(
+        this.client.send('auth.v1.validate', { access_token: token }),
       );
-      request.user = user;
-    
-      return true;
-    } catch (error: any) {
-      if (error.response) {
-        const { statusCode, data } = error.response;
-
-        if (data == true) {
-          throw new UnauthorizedException(error);
-        } else {
-          const newToken = await this.client.send('auth.refresh', token);
-
-          request.token = newToken;
-
-          return true;
-        }
-      }
-
+    } catch {
       return false;
     }
+
+    // AUTH_TOKEN_EXPIRED tells the client to call refresh with its refresh
+    // token, which the gateway never sees; every other code means logging in
+    // again.
+    if (reply?.status !== 200) {
+      throw new UnauthorizedException({ code: reply?.code, message: reply?.message });
+    }
+
+    request.user = reply.data;
+    request.token = token;
+    return true;
   }
 
   tokenExtractor(request: Request): string | undefined {